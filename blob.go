@@ -0,0 +1,335 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"unsafe"
+)
+
+// blobRunSize is the maximum size of a page run holding part of a blob value,
+// and of the buffer used to write it.
+const blobRunSize = 1 << 20
+
+const blobRefSize = int(unsafe.Sizeof(blobRef{}))
+
+const blobHeaderSize = int(unsafe.Sizeof(blobHeader{}))
+
+// blobRef is the value stored in a leaf element flagged with blobLeafFlag.
+// A blob value is stored outside of the tree, in a chain of page runs.
+type blobRef struct {
+	first pgid   // first page of the first run
+	size  uint64 // size of the value, in bytes
+}
+
+// blobHeader follows the page header of each page run of a blob value.
+type blobHeader struct {
+	next pgid   // first page of the next run, or 0 for the last run
+	size uint64 // number of value bytes held by the run
+}
+
+// readBlobRef copies a blob reference from a leaf value, which may not be
+// aligned.
+func readBlobRef(v []byte) blobRef {
+	var ref blobRef
+	copy(unsafeByteSlice(unsafe.Pointer(&ref), 0, 0, blobRefSize), v)
+	return ref
+}
+
+// write returns the leaf value holding the blob reference.
+func (ref blobRef) write() []byte {
+	var value = make([]byte, blobRefSize)
+	copy(value, unsafeByteSlice(unsafe.Pointer(&ref), 0, 0, blobRefSize))
+	return value
+}
+
+// PutReader sets the value for a key in the bucket to the size bytes read
+// from r. Unlike with Put, the value is never held in memory: values larger
+// than a page are written to the data file as they are read, in a chain of
+// page runs of up to 1MB. If the key exists then its previous value will be
+// overwritten. It returns io.ErrUnexpectedEOF if r holds less than size bytes,
+// leaving the bucket unchanged.
+//
+// Such values are read back with GetReader. Get and cursors return a nil
+// value for them, as for nested buckets. Returns ErrIncompatibleValue in a
+// bucket created with DupSort.
+func (b *Bucket) PutReader(key []byte, r io.Reader, size int64) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if b.dupSort {
+		return ErrIncompatibleValue
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if size < 0 {
+		return fmt.Errorf("invalid value size: %d", size)
+	}
+
+	// Small values are stored in the leaf like any other.
+	if size < int64(b.tx.db.pageSize) {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	}
+
+	// Return an error if there is an existing key with a bucket value.
+	c := b.Cursor()
+	k, _, flags := c.seek(key)
+	if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
+		return ErrIncompatibleValue
+	}
+
+	first, err := b.tx.writeBlob(r, size)
+	if err != nil {
+		return err
+	}
+
+	// Release the previous blob value of the key, if any.
+	if bytes.Equal(key, k) && (flags&blobLeafFlag) != 0 {
+		_, v, _ := c.keyValue()
+		if err := b.tx.freeBlob(v); err != nil {
+			return err
+		}
+	}
+
+	key = cloneBytes(key)
+	c.node().put(key, key, blobRef{first: first, size: uint64(size)}.write(), 0, blobLeafFlag)
+	return nil
+}
+
+// GetReader returns a reader over the value for a key in the bucket, whether
+// it was set by Put or by PutReader. Returns nil if the key does not exist or
+// if the key is a nested bucket. In a bucket created with DupSort, the first
+// value of the key is read. The reader is only valid for the life of the
+// transaction.
+func (b *Bucket) GetReader(key []byte) io.ReadSeeker {
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+	if !bytes.Equal(key, k) {
+		return nil
+	} else if (flags & dupLeafFlag) != 0 {
+		return bytes.NewReader(c.newDupCursor().value())
+	} else if (flags & bucketLeafFlag) != 0 {
+		return nil
+	} else if (flags & blobLeafFlag) == 0 {
+		return bytes.NewReader(v)
+	}
+
+	ref := readBlobRef(v)
+	return &blobReader{tx: b.tx, size: int64(ref.size), next: ref.first}
+}
+
+// writeBlob writes the size bytes read from r to a chain of page runs, and
+// returns the first page of the chain. The runs are written directly to the
+// data file, ahead of the dirty pages of the transaction. On error, the pages
+// allocated so far are released.
+func (tx *Tx) writeBlob(r io.Reader, size int64) (first pgid, err error) {
+	db := tx.db
+	runPages := blobRunSize / db.pageSize
+	if runPages == 0 {
+		runPages = 1
+	}
+
+	// pages returns the number of pages of the run holding the next n bytes.
+	pages := func(n int64) int {
+		count := (n + int64(pageHeaderSize) + int64(blobHeaderSize) + int64(db.pageSize) - 1) / int64(db.pageSize)
+		if count > int64(runPages) {
+			return runPages
+		}
+		return int(count)
+	}
+
+	type run struct {
+		id    pgid
+		count int
+	}
+	var runs []run
+	allocate := func(count int) (pgid, error) {
+		id, err := db.allocatePages(tx.meta.txid, count)
+		if err != nil {
+			return 0, err
+		}
+		runs = append(runs, run{id: id, count: count})
+		tx.stats.PageCount += count
+		tx.stats.PageAlloc += count * db.pageSize
+		return id, nil
+	}
+	defer func() {
+		if err != nil {
+			for _, r := range runs {
+				db.freelist.free(tx.meta.txid, &page{id: r.id, overflow: uint32(r.count - 1)})
+			}
+		}
+	}()
+
+	buf := make([]byte, pages(size)*db.pageSize)
+	id, err := allocate(pages(size))
+	if err != nil {
+		return 0, err
+	}
+	first = id
+
+	for rem := size; rem > 0; {
+		count := runs[len(runs)-1].count
+		p := (*page)(unsafe.Pointer(&buf[0]))
+		h := (*blobHeader)(unsafeAdd(unsafe.Pointer(p), pageHeaderSize))
+		*p = page{id: id, flags: blobPageFlag, overflow: uint32(count - 1)}
+
+		// Fill the run from the reader.
+		data := buf[int(pageHeaderSize)+blobHeaderSize : count*db.pageSize]
+		if int64(len(data)) > rem {
+			data = data[:rem]
+		}
+		if _, err := io.ReadFull(r, data); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		rem -= int64(len(data))
+
+		// Allocate the next run to link it from this one.
+		*h = blobHeader{size: uint64(len(data))}
+		if rem > 0 {
+			if h.next, err = allocate(pages(rem)); err != nil {
+				return 0, err
+			}
+		}
+
+		// Runs can be written past the end of the file, so a full disk
+		// shows up here rather than when growing the file.
+		n := int(pageHeaderSize) + blobHeaderSize + len(data)
+		if written, err := db.ops.writeAt(buf[:n], int64(id)*int64(db.pageSize)); isNoSpace(err) || (err == nil && written < n) {
+			return 0, ErrNoSpace
+		} else if err != nil {
+			return 0, err
+		}
+		tx.stats.Write++
+		if db.pageCache != nil {
+			db.pageCache.invalidate(id, count)
+		}
+		id = h.next
+	}
+	return first, nil
+}
+
+// freeBlob releases the page runs of the blob value referenced by v.
+func (tx *Tx) freeBlob(v []byte) error {
+	for id := readBlobRef(v).first; id != 0; {
+		p, h, err := tx.readBlobHeader(id)
+		if err != nil {
+			return err
+		}
+		tx.db.freelist.free(tx.meta.txid, &p)
+		id = h.next
+	}
+	return nil
+}
+
+// readBlobHeader reads the page and blob headers of the page run id of a
+// blob value.
+func (tx *Tx) readBlobHeader(id pgid) (page, blobHeader, error) {
+	var hdr struct {
+		p page
+		h blobHeader
+	}
+	buf := unsafeByteSlice(unsafe.Pointer(&hdr), 0, 0, int(unsafe.Sizeof(hdr)))
+	if _, err := tx.db.file.ReadAt(buf, int64(id)*int64(tx.db.pageSize)); err != nil {
+		return page{}, blobHeader{}, fmt.Errorf("read blob page %d: %s", id, err)
+	}
+	if hdr.p.id != id || (hdr.p.flags&blobPageFlag) == 0 {
+		return page{}, blobHeader{}, fmt.Errorf("blob page %d: invalid page %d of type %s", id, hdr.p.id, hdr.p.typ())
+	}
+	return hdr.p, hdr.h, nil
+}
+
+// blobReader reads a blob value from its page runs in the data file. The runs
+// are located as the reader moves forward.
+type blobReader struct {
+	tx   *Tx
+	size int64
+	pos  int64
+
+	runs []blobRun // runs located so far
+	next pgid      // first page of the next run to locate
+}
+
+// blobRun is a located page run of a blob value.
+type blobRun struct {
+	id   pgid
+	off  int64 // offset of the run in the value
+	size int64
+}
+
+// Read reads up to len(p) bytes of the value.
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	run, err := r.run(r.pos)
+	if err != nil {
+		return 0, err
+	}
+	if rem := run.off + run.size - r.pos; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	off := int64(run.id)*int64(r.tx.db.pageSize) + int64(pageHeaderSize) + int64(blobHeaderSize) + r.pos - run.off
+	n, err := r.tx.db.file.ReadAt(p, off)
+	r.pos += int64(n)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read.
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position: %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// run returns the page run holding the byte at pos, locating the runs up to
+// it.
+func (r *blobReader) run(pos int64) (blobRun, error) {
+	for {
+		if n := len(r.runs); n > 0 && pos < r.runs[n-1].off+r.runs[n-1].size {
+			i := sort.Search(n, func(i int) bool { return pos < r.runs[i].off+r.runs[i].size })
+			return r.runs[i], nil
+		} else if r.next == 0 {
+			return blobRun{}, fmt.Errorf("blob value ends before %d bytes", pos+1)
+		}
+
+		_, h, err := r.tx.readBlobHeader(r.next)
+		if err != nil {
+			return blobRun{}, err
+		}
+		var off int64
+		if n := len(r.runs); n > 0 {
+			off = r.runs[n-1].off + r.runs[n-1].size
+		}
+		r.runs = append(r.runs, blobRun{id: r.next, off: off, size: int64(h.size)})
+		r.next = h.next
+	}
+}
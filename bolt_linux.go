@@ -8,3 +8,13 @@ import (
 func fdatasync(db *DB) error {
 	return syscall.Fdatasync(int(db.file.Fd()))
 }
+
+// fallocate grows the data file to size bytes, reserving its blocks on
+// disk. It falls back to truncate() on file systems without fallocate().
+func fallocate(db *DB, size int64) error {
+	err := syscall.Fallocate(int(db.file.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return db.file.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package bbolt_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Ensure growing the data file with Preallocate reserves its blocks on disk,
+// rather than leaving the unwritten pages sparse like truncate() does.
+func TestDB_Grow_Preallocate_Blocks(t *testing.T) {
+	// The file grows to the initial mmap size, far beyond the written pages.
+	db := MustOpenWithOption(&bolt.Options{Preallocate: true, InitialMmapSize: 8 << 20})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), make([]byte, 100000))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() < 8<<20 {
+		t.Fatalf("unexpected file size: %d", fi.Size())
+	} else if allocated := fi.Sys().(*syscall.Stat_t).Blocks * 512; allocated < fi.Size() {
+		t.Fatalf("unexpected allocated size: %d, expected at least %d", allocated, fi.Size())
+	}
+}
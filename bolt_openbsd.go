@@ -12,15 +12,17 @@ const (
 )
 
 func msync(db *DB) error {
-	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(db.data)), uintptr(db.datasz), msInvalidate)
-	if errno != 0 {
-		return errno
+	for _, s := range db.loadSegments() {
+		_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(s.data)), uintptr(len(s.ref)), msInvalidate)
+		if errno != 0 {
+			return errno
+		}
 	}
 	return nil
 }
 
 func fdatasync(db *DB) error {
-	if db.data != nil {
+	if db.loadSegments() != nil {
 		return msync(db)
 	}
 	return db.file.Sync()
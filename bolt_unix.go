@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"syscall"
 	"time"
-	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -49,39 +48,26 @@ func funlock(db *DB) error {
 	return syscall.Flock(int(db.file.Fd()), syscall.LOCK_UN)
 }
 
-// mmap memory maps a DB's data file.
-func mmap(db *DB, sz int) error {
+// mmap memory maps sz bytes of a DB's data file starting at offset off.
+func mmap(db *DB, off, sz int) ([]byte, error) {
 	// Map the data file to memory.
-	b, err := unix.Mmap(int(db.file.Fd()), 0, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
+	b, err := unix.Mmap(int(db.file.Fd()), int64(off), sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Advise the kernel that the mmap is accessed randomly.
 	err = unix.Madvise(b, syscall.MADV_RANDOM)
 	if err != nil && err != syscall.ENOSYS {
 		// Ignore not implemented error in kernel because it still works.
-		return fmt.Errorf("madvise: %s", err)
+		_ = unix.Munmap(b)
+		return nil, fmt.Errorf("madvise: %s", err)
 	}
 
-	// Save the original byte slice and convert to a byte array pointer.
-	db.dataref = b
-	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&b[0]))
-	db.datasz = sz
-	return nil
+	return b, nil
 }
 
-// munmap unmaps a DB's data file from memory.
-func munmap(db *DB) error {
-	// Ignore the unmap if we have no mapped data.
-	if db.dataref == nil {
-		return nil
-	}
-
-	// Unmap using the original byte slice.
-	err := unix.Munmap(db.dataref)
-	db.dataref = nil
-	db.data = nil
-	db.datasz = 0
-	return err
+// munmap unmaps a region of a DB's data file from memory.
+func munmap(b []byte) error {
+	return unix.Munmap(b)
 }
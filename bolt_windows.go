@@ -59,50 +59,49 @@ func funlock(db *DB) error {
 	})
 }
 
-// mmap memory maps a DB's data file.
+// mmap memory maps sz bytes of a DB's data file starting at offset off.
 // Based on: https://github.com/edsrzf/mmap-go
-func mmap(db *DB, sz int) error {
+func mmap(db *DB, off, sz int) ([]byte, error) {
 	if !db.readOnly {
-		// Truncate the database to the size of the mmap.
-		if err := db.file.Truncate(int64(sz)); err != nil {
-			return fmt.Errorf("truncate: %s", err)
+		// Extend the database to the end of the mmap.
+		info, err := db.file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("stat: %s", err)
+		} else if info.Size() < int64(off+sz) {
+			if err := db.file.Truncate(int64(off + sz)); err != nil {
+				return nil, fmt.Errorf("truncate: %s", err)
+			}
 		}
 	}
 
 	// Open a file mapping handle.
-	sizelo := uint32(sz >> 32)
-	sizehi := uint32(sz) & 0xffffffff
+	sizelo := uint32((off + sz) >> 32)
+	sizehi := uint32(off+sz) & 0xffffffff
 	h, errno := syscall.CreateFileMapping(syscall.Handle(db.file.Fd()), nil, syscall.PAGE_READONLY, sizelo, sizehi, nil)
 	if h == 0 {
-		return os.NewSyscallError("CreateFileMapping", errno)
+		return nil, os.NewSyscallError("CreateFileMapping", errno)
 	}
 
 	// Create the memory map.
-	addr, errno := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, 0, 0, uintptr(sz))
+	addr, errno := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, uint32(off>>32), uint32(off)&0xffffffff, uintptr(sz))
 	if addr == 0 {
-		return os.NewSyscallError("MapViewOfFile", errno)
+		_ = syscall.CloseHandle(h)
+		return nil, os.NewSyscallError("MapViewOfFile", errno)
 	}
 
 	// Close mapping handle.
 	if err := syscall.CloseHandle(syscall.Handle(h)); err != nil {
-		return os.NewSyscallError("CloseHandle", err)
+		return nil, os.NewSyscallError("CloseHandle", err)
 	}
 
-	// Convert to a byte array.
-	db.data = ((*[maxMapSize]byte)(unsafe.Pointer(addr)))
-	db.datasz = sz
-
-	return nil
+	// Convert to a byte slice.
+	return ((*[maxMapSize]byte)(unsafe.Pointer(addr)))[:sz:sz], nil
 }
 
-// munmap unmaps a pointer from a file.
+// munmap unmaps a region of a DB's data file from memory.
 // Based on: https://github.com/edsrzf/mmap-go
-func munmap(db *DB) error {
-	if db.data == nil {
-		return nil
-	}
-
-	addr := (uintptr)(unsafe.Pointer(&db.data[0]))
+func munmap(b []byte) error {
+	addr := (uintptr)(unsafe.Pointer(&b[0]))
 	if err := syscall.UnmapViewOfFile(addr); err != nil {
 		return os.NewSyscallError("UnmapViewOfFile", err)
 	}
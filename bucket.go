@@ -34,6 +34,11 @@ type Bucket struct {
 	rootNode *node              // materialized node for the root page.
 	nodes    map[pgid]*node     // node cache
 
+	comparator string     // name of the key comparator, if any
+	compare    Comparator // registered key comparator, or nil
+	dupSort    bool       // keys have several values
+	dupTree    bool       // keys are the values of a key of the parent
+
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
 	// amount if you know that your write workloads are mostly append-only.
@@ -91,12 +96,22 @@ func (b *Bucket) Cursor() *Cursor {
 }
 
 // Bucket retrieves a nested bucket by name.
-// Returns nil if the bucket does not exist.
+// Returns nil if the bucket does not exist, or if it was created with a
+// comparator which is not registered, unless the database was opened with
+// Options.RawKeyOrder and the transaction is read-only.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	child, _ := b.lookupBucket(name)
+	return child
+}
+
+// lookupBucket retrieves a nested bucket by name. It returns a nil bucket if
+// the bucket does not exist, and ErrUnknownComparator if it was created with
+// a comparator which is not registered.
+func (b *Bucket) lookupBucket(name []byte) (*Bucket, error) {
 	if b.buckets != nil {
-		if child := b.buckets[string(name)]; child != nil {
-			return child
+		if child := b.buckets[string(name)]; child != nil && !child.dupTree {
+			return child, nil
 		}
 	}
 
@@ -105,22 +120,26 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 	k, v, flags := c.seek(name)
 
 	// Return nil if the key doesn't exist or it is not a bucket.
-	if !bytes.Equal(name, k) || (flags&bucketLeafFlag) == 0 {
-		return nil
+	if !bytes.Equal(name, k) || (flags&bucketLeafFlag) == 0 || (flags&dupLeafFlag) != 0 {
+		return nil, nil
 	}
 
 	// Otherwise create a bucket and cache it.
-	var child = b.openBucket(v)
+	var child = b.openBucket(v, flags)
+	if child.rawKeyOrder() && (b.tx.writable || !b.tx.db.rawKeyOrder) {
+		return nil, ErrUnknownComparator
+	}
 	if b.buckets != nil {
 		b.buckets[string(name)] = child
 	}
 
-	return child
+	return child, nil
 }
 
 // Helper method that re-interprets a sub-bucket value
-// from a parent into a Bucket
-func (b *Bucket) openBucket(value []byte) *Bucket {
+// from a parent into a Bucket. The comparator of the bucket is left nil if
+// it is not registered.
+func (b *Bucket) openBucket(value []byte, flags uint32) *Bucket {
 	var child = newBucket(b.tx)
 
 	// Unaligned access requires a copy to be made.
@@ -142,9 +161,20 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 		child.bucket = (*bucket)(unsafe.Pointer(&value[0]))
 	}
 
+	// Read the options stored after the header.
+	var extSize int
+	if (flags & bucketExtLeafFlag) != 0 {
+		var opts BucketOptions
+		opts, extSize, _ = readBucketExt(value)
+		child.comparator = opts.Comparator
+		child.compare = lookupComparator(opts.Comparator)
+		child.dupSort = opts.DupSort
+	}
+	child.dupTree = (flags & dupLeafFlag) != 0
+
 	// Save a reference to the inline page if the bucket is inline.
 	if child.root == 0 {
-		child.page = (*page)(unsafe.Pointer(&value[bucketHeaderSize]))
+		child.page = (*page)(unsafe.Pointer(&value[bucketHeaderSize+extSize]))
 	}
 
 	return &child
@@ -154,12 +184,33 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 // Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
+	return b.CreateBucketWithOptions(key, nil)
+}
+
+// CreateBucketWithOptions creates a new bucket at the given key with the given
+// options and returns the new bucket. Passing nil options is the same as
+// calling CreateBucket. Returns an error if the key already exists, if the
+// bucket name is blank, if the comparator is not registered, or if the bucket
+// was created with DupSort.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (b *Bucket) CreateBucketWithOptions(key []byte, opts *BucketOptions) (*Bucket, error) {
 	if b.tx.db == nil {
 		return nil, ErrTxClosed
 	} else if !b.tx.writable {
 		return nil, ErrTxNotWritable
 	} else if len(key) == 0 {
 		return nil, ErrBucketNameRequired
+	} else if b.dupSort {
+		return nil, ErrIncompatibleValue
+	}
+	if opts == nil {
+		opts = &BucketOptions{}
+	}
+	var compare Comparator
+	if opts.Comparator != "" {
+		if compare = lookupComparator(opts.Comparator); compare == nil {
+			return nil, ErrUnknownComparator
+		}
 	}
 
 	// Move cursor to correct position.
@@ -168,7 +219,7 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 
 	// Return an error if there is an existing key.
 	if bytes.Equal(key, k) {
-		if (flags & bucketLeafFlag) != 0 {
+		if (flags & (bucketLeafFlag | dupLeafFlag)) == bucketLeafFlag {
 			return nil, ErrBucketExists
 		}
 		return nil, ErrIncompatibleValue
@@ -179,12 +230,15 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 		bucket:      &bucket{},
 		rootNode:    &node{isLeaf: true},
 		FillPercent: DefaultFillPercent,
+		comparator:  opts.Comparator,
+		compare:     compare,
+		dupSort:     opts.DupSort,
 	}
 	var value = bucket.write()
 
 	// Insert into node.
 	key = cloneBytes(key)
-	c.node().put(key, key, value, 0, bucketLeafFlag)
+	c.node().put(key, key, value, 0, bucket.leafFlags())
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
@@ -198,9 +252,22 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 // Returns an error if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
-	child, err := b.CreateBucket(key)
+	return b.CreateBucketIfNotExistsWithOptions(key, nil)
+}
+
+// CreateBucketIfNotExistsWithOptions creates a new bucket with the given
+// options if it doesn't already exist and returns a reference to it. Returns
+// ErrIncompatibleValue if the bucket exists with different options.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (b *Bucket) CreateBucketIfNotExistsWithOptions(key []byte, opts *BucketOptions) (*Bucket, error) {
+	child, err := b.CreateBucketWithOptions(key, opts)
 	if err == ErrBucketExists {
-		return b.Bucket(key), nil
+		if child, err = b.lookupBucket(key); err != nil {
+			return nil, err
+		} else if opts != nil && child.options() != *opts {
+			return nil, ErrIncompatibleValue
+		}
+		return child, nil
 	} else if err != nil {
 		return nil, err
 	}
@@ -223,17 +290,27 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 	// Return an error if bucket doesn't exist or is not a bucket.
 	if !bytes.Equal(key, k) {
 		return ErrBucketNotFound
-	} else if (flags & bucketLeafFlag) == 0 {
+	} else if (flags & (bucketLeafFlag | dupLeafFlag)) != bucketLeafFlag {
 		return ErrIncompatibleValue
 	}
 
-	// Recursively delete all child buckets.
-	child := b.Bucket(key)
-	err := child.ForEach(func(k, v []byte) error {
-		if _, _, childFlags := child.Cursor().seek(k); (childFlags & bucketLeafFlag) != 0 {
+	// Recursively delete all child buckets, and release the values set by
+	// PutReader and the trees of duplicate values.
+	child, err := b.lookupBucket(key)
+	if err != nil {
+		return err
+	}
+	err = child.ForEach(func(k, v []byte) error {
+		if _, v, childFlags := child.Cursor().seek(k); (childFlags & (bucketLeafFlag | dupLeafFlag)) == bucketLeafFlag|dupLeafFlag {
+			child.freeDupTree(k, v)
+		} else if (childFlags & bucketLeafFlag) != 0 {
 			if err := child.DeleteBucket(k); err != nil {
 				return fmt.Errorf("delete bucket: %s", err)
 			}
+		} else if (childFlags & blobLeafFlag) != 0 {
+			if err := child.tx.freeBlob(v); err != nil {
+				return fmt.Errorf("delete bucket: %s", err)
+			}
 		}
 		return nil
 	})
@@ -256,13 +333,21 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 }
 
 // Get retrieves the value for a key in the bucket.
-// Returns a nil value if the key does not exist or if the key is a nested bucket.
+// Returns a nil value if the key does not exist, if the key is a nested bucket
+// or if its value was set by PutReader. In a bucket created with DupSort,
+// the first value of the key is returned.
 // The returned value is only valid for the life of the transaction.
 func (b *Bucket) Get(key []byte) []byte {
-	k, v, flags := b.Cursor().seek(key)
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
 
-	// Return nil if this is a bucket.
-	if (flags & bucketLeafFlag) != 0 {
+	// Return the first of the values of a key with duplicates.
+	if (flags&dupLeafFlag) != 0 && bytes.Equal(key, k) {
+		return c.newDupCursor().value()
+	}
+
+	// Return nil if this is a bucket or a value set by PutReader.
+	if (flags & (bucketLeafFlag | blobLeafFlag)) != 0 {
 		return nil
 	}
 
@@ -274,11 +359,14 @@ func (b *Bucket) Get(key []byte) []byte {
 }
 
 // Put sets the value for a key in the bucket.
-// If the key exist then its previous value will be overwritten.
+// If the key exist then its previous value will be overwritten. In a bucket
+// created with DupSort, all the previous values of the key are replaced.
 // Supplied value must remain valid for the life of the transaction.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
 func (b *Bucket) Put(key []byte, value []byte) error {
-	if b.tx.db == nil {
+	if b.dupSort {
+		return b.putDup(key, value)
+	} else if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
 		return ErrTxNotWritable
@@ -292,13 +380,20 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return an error if there is an existing key with a bucket value.
 	if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
 
+	// Release the previous value if it was set by PutReader.
+	if bytes.Equal(key, k) && (flags&blobLeafFlag) != 0 {
+		if err := b.tx.freeBlob(v); err != nil {
+			return err
+		}
+	}
+
 	// Insert into node.
 	key = cloneBytes(key)
 	c.node().put(key, key, value, 0, 0)
@@ -306,7 +401,8 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 	return nil
 }
 
-// Delete removes a key from the bucket.
+// Delete removes a key from the bucket, with all its values in a bucket
+// created with DupSort.
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
 func (b *Bucket) Delete(key []byte) error {
@@ -318,18 +414,32 @@ func (b *Bucket) Delete(key []byte) error {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return nil if the key doesn't exist.
 	if !bytes.Equal(key, k) {
 		return nil
 	}
 
+	// Release the tree of values of a key with duplicates.
+	if (flags & (bucketLeafFlag | dupLeafFlag)) == bucketLeafFlag|dupLeafFlag {
+		b.freeDupTree(key, v)
+		c.node().del(key)
+		return nil
+	}
+
 	// Return an error if there is already existing bucket value.
 	if (flags & bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
 
+	// Release the value if it was set by PutReader.
+	if (flags & blobLeafFlag) != 0 {
+		if err := b.tx.freeBlob(v); err != nil {
+			return err
+		}
+	}
+
 	// Delete the node if we have a matching key.
 	c.node().del(key)
 
@@ -396,6 +506,17 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 
 // Stat returns stats on a bucket.
 func (b *Bucket) Stats() BucketStats {
+	return b.stats(true)
+}
+
+// OwnStats returns stats on the pages of a bucket, excluding the pages, keys
+// and buckets of its nested buckets. Adding the OwnStats of a bucket and of
+// all its nested buckets gives its Stats, except for Depth.
+func (b *Bucket) OwnStats() BucketStats {
+	return b.stats(false)
+}
+
+func (b *Bucket) stats(recursive bool) BucketStats {
 	var s, subStats BucketStats
 	pageSize := b.tx.db.pageSize
 	s.BucketN += 1
@@ -422,6 +543,10 @@ func (b *Bucket) Stats() BucketStats {
 				used += uintptr(lastElement.pos + lastElement.ksize + lastElement.vsize)
 			}
 
+			if prefix := p.prefix(); len(prefix) > 0 {
+				s.LeafPrefixSaved += int(p.count-1)*len(prefix) - prefixHeaderSize
+			}
+
 			if b.root == 0 {
 				// For inlined bucket just update the inline stats
 				s.InlineBucketInuse += int(used)
@@ -434,12 +559,12 @@ func (b *Bucket) Stats() BucketStats {
 				// Collect stats from sub-buckets.
 				// Do that by iterating over all element headers
 				// looking for the ones with the bucketLeafFlag.
-				for i := uint16(0); i < p.count; i++ {
+				for i := uint16(0); recursive && i < p.count; i++ {
 					e := p.leafPageElement(i)
 					if (e.flags & bucketLeafFlag) != 0 {
 						// For any bucket element, open the element value
 						// and recursively call Stats on the contained bucket.
-						subStats.Add(b.openBucket(e.value()).Stats())
+						subStats.Add(b.openBucket(e.value(), e.flags).Stats())
 					}
 				}
 			}
@@ -457,6 +582,9 @@ func (b *Bucket) Stats() BucketStats {
 			used += uintptr(lastElement.pos + lastElement.ksize)
 			s.BranchInuse += int(used)
 			s.BranchOverflowN += int(p.overflow)
+			if prefix := p.prefix(); len(prefix) > 0 {
+				s.BranchPrefixSaved += int(p.count-1)*len(prefix) - prefixHeaderSize
+			}
 		}
 
 		// Keep track of maximum page depth.
@@ -539,9 +667,11 @@ func (b *Bucket) spill() error {
 			}
 
 			// Update the child bucket header in this bucket.
-			value = make([]byte, unsafe.Sizeof(bucket{}))
+			ext := child.options().ext()
+			value = make([]byte, bucketHeaderSize+len(ext))
 			var bucket = (*bucket)(unsafe.Pointer(&value[0]))
 			*bucket = *child.bucket
+			copy(value[bucketHeaderSize:], ext)
 		}
 
 		// Skip writing the bucket if there are no materialized nodes.
@@ -558,7 +688,7 @@ func (b *Bucket) spill() error {
 		if flags&bucketLeafFlag == 0 {
 			panic(fmt.Sprintf("unexpected bucket header flag: %x", flags))
 		}
-		c.node().put([]byte(name), []byte(name), value, 0, bucketLeafFlag)
+		c.node().put([]byte(name), []byte(name), value, 0, child.leafFlags())
 	}
 
 	// Ignore if there's not a materialized root node.
@@ -616,14 +746,16 @@ func (b *Bucket) maxInlineBucketSize() uintptr {
 func (b *Bucket) write() []byte {
 	// Allocate the appropriate size.
 	var n = b.rootNode
-	var value = make([]byte, bucketHeaderSize+n.size())
+	var ext = b.options().ext()
+	var value = make([]byte, bucketHeaderSize+len(ext)+n.size())
 
-	// Write a bucket header.
+	// Write a bucket header, followed by the options.
 	var bucket = (*bucket)(unsafe.Pointer(&value[0]))
 	*bucket = *b.bucket
+	copy(value[bucketHeaderSize:], ext)
 
 	// Convert byte slice to a fake page and write the root node.
-	var p = (*page)(unsafe.Pointer(&value[bucketHeaderSize]))
+	var p = (*page)(unsafe.Pointer(&value[bucketHeaderSize+len(ext)]))
 	n.write(p)
 
 	return value
@@ -729,25 +861,29 @@ func (b *Bucket) pageNode(id pgid) (*page, *node) {
 // BucketStats records statistics about resources used by a bucket.
 type BucketStats struct {
 	// Page count statistics.
-	BranchPageN     int // number of logical branch pages
-	BranchOverflowN int // number of physical branch overflow pages
-	LeafPageN       int // number of logical leaf pages
-	LeafOverflowN   int // number of physical leaf overflow pages
+	BranchPageN     int `json:"branch_page_n"`     // number of logical branch pages
+	BranchOverflowN int `json:"branch_overflow_n"` // number of physical branch overflow pages
+	LeafPageN       int `json:"leaf_page_n"`       // number of logical leaf pages
+	LeafOverflowN   int `json:"leaf_overflow_n"`   // number of physical leaf overflow pages
 
 	// Tree statistics.
-	KeyN  int // number of keys/value pairs
-	Depth int // number of levels in B+tree
+	KeyN  int `json:"key_n"` // number of keys/value pairs
+	Depth int `json:"depth"` // number of levels in B+tree
 
 	// Page size utilization.
-	BranchAlloc int // bytes allocated for physical branch pages
-	BranchInuse int // bytes actually used for branch data
-	LeafAlloc   int // bytes allocated for physical leaf pages
-	LeafInuse   int // bytes actually used for leaf data
+	BranchAlloc int `json:"branch_alloc"` // bytes allocated for physical branch pages
+	BranchInuse int `json:"branch_inuse"` // bytes actually used for branch data
+	LeafAlloc   int `json:"leaf_alloc"`   // bytes allocated for physical leaf pages
+	LeafInuse   int `json:"leaf_inuse"`   // bytes actually used for leaf data
+
+	// Prefix compression savings.
+	BranchPrefixSaved int `json:"branch_prefix_saved"` // bytes saved on branch pages by prefix compression
+	LeafPrefixSaved   int `json:"leaf_prefix_saved"`   // bytes saved on leaf pages, including inline buckets, by prefix compression
 
 	// Bucket statistics
-	BucketN           int // total number of buckets including the top bucket
-	InlineBucketN     int // total number on inlined buckets
-	InlineBucketInuse int // bytes used for inlined buckets (also accounted for in LeafInuse)
+	BucketN           int `json:"bucket_n"`            // total number of buckets including the top bucket
+	InlineBucketN     int `json:"inline_bucket_n"`     // total number on inlined buckets
+	InlineBucketInuse int `json:"inline_bucket_inuse"` // bytes used for inlined buckets (also accounted for in LeafInuse)
 }
 
 func (s *BucketStats) Add(other BucketStats) {
@@ -763,6 +899,8 @@ func (s *BucketStats) Add(other BucketStats) {
 	s.BranchInuse += other.BranchInuse
 	s.LeafAlloc += other.LeafAlloc
 	s.LeafInuse += other.LeafInuse
+	s.BranchPrefixSaved += other.BranchPrefixSaved
+	s.LeafPrefixSaved += other.LeafPrefixSaved
 
 	s.BucketN += other.BucketN
 	s.InlineBucketN += other.InlineBucketN
@@ -5,9 +5,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -237,6 +239,683 @@ func TestDB_Put_VeryLarge(t *testing.T) {
 	}
 }
 
+// Ensure that a bucket can store a value read from a reader over several page runs.
+func TestBucket_PutReader(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	value := make([]byte, 3<<20+12345)
+	rand.New(rand.NewSource(0)).Read(value)
+
+	check := func(b *bolt.Bucket) {
+		r := b.GetReader([]byte("blob"))
+		if r == nil {
+			t.Fatal("expected reader")
+		} else if v := b.Get([]byte("blob")); v != nil {
+			t.Fatalf("unexpected value: %x", v)
+		}
+		if buf, err := io.ReadAll(r); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, value) {
+			t.Fatalf("unexpected value of %d bytes", len(buf))
+		}
+
+		// Read across the end of the first run.
+		buf := make([]byte, 4096)
+		if _, err := r.Seek(1<<20-2048, io.SeekStart); err != nil {
+			t.Fatal(err)
+		} else if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, value[1<<20-2048:1<<20+2048]) {
+			t.Fatal("unexpected value across runs")
+		}
+		if n, err := r.Seek(0, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		} else if n != int64(len(value)) {
+			t.Fatalf("unexpected size: %d", n)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutReader([]byte("blob"), bytes.NewReader(value), int64(len(value))); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutReader([]byte("small"), strings.NewReader("bar"), 3); err != nil {
+			t.Fatal(err)
+		}
+		check(b)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		check(b)
+		if v := b.Get([]byte("small")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if buf, err := io.ReadAll(b.GetReader([]byte("small"))); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", buf)
+		}
+		if r := b.GetReader([]byte("no such key")); r != nil {
+			t.Fatal("expected nil reader")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the pages of a value set by PutReader are released when it is
+// overwritten or deleted.
+func TestBucket_PutReader_Release(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	value := []byte(strings.Repeat("*", 2<<20))
+	put := func(b *bolt.Bucket, key string) {
+		if err := b.PutReader([]byte(key), bytes.NewReader(value), int64(len(value))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(b, "put")
+		put(b, "overwrite")
+		put(b, "delete")
+		put(b, "cursor")
+		put(child, "bucket")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+	if err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			t.Error(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Put([]byte("put"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		put(b, "overwrite")
+		if err := b.Delete([]byte("delete")); err != nil {
+			t.Fatal(err)
+		}
+		c := b.Cursor()
+		if k, _ := c.Seek([]byte("cursor")); !bytes.Equal(k, []byte("cursor")) {
+			t.Fatalf("unexpected key: %s", k)
+		} else if err := c.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.DeleteBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 2 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that PutReader leaves the bucket unchanged if the reader is too short.
+func TestBucket_PutReader_UnexpectedEOF(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutReader([]byte("foo"), strings.NewReader(strings.Repeat("*", 1<<20)), 2<<20); err != io.ErrUnexpectedEOF {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func init() {
+	bolt.RegisterComparator("test-reverse", func(a, b []byte) int { return bytes.Compare(b, a) })
+}
+
+// Ensure that the keys of a bucket created with a comparator are ordered by it.
+func TestBucket_Comparator(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	opts := &bolt.BucketOptions{Comparator: "test-reverse"}
+	const n = 1000
+	check := func(tx *bolt.Tx) {
+		b := tx.Bucket([]byte("widgets"))
+		if b == nil {
+			t.Fatal("expected bucket")
+		} else if name := b.Comparator(); name != "test-reverse" {
+			t.Fatalf("unexpected comparator: %q", name)
+		}
+
+		// Keys are iterated in descending order, with the even ones deleted.
+		c := b.Cursor()
+		i := n - 1
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if string(k) == "child" {
+				continue
+			} else if !bytes.Equal(k, u64tob(uint64(i))) || !bytes.Equal(v, k) {
+				t.Fatalf("unexpected key at %d: %x=%x", i, k, v)
+			}
+			i -= 2
+		}
+		if i != -1 {
+			t.Fatalf("unexpected last key: %d", i+2)
+		}
+
+		if v := b.Get(u64tob(501)); !bytes.Equal(v, u64tob(501)) {
+			t.Fatalf("unexpected value: %x", v)
+		} else if v := b.Get(u64tob(500)); v != nil {
+			t.Fatalf("unexpected value: %x", v)
+		}
+		if k, _ := c.Seek(u64tob(500)); !bytes.Equal(k, u64tob(499)) {
+			t.Fatalf("unexpected seek: %x", k)
+		}
+
+		// Nested buckets do not inherit the comparator, but have their own.
+		child := b.Bucket([]byte("child"))
+		if name := child.Comparator(); name != "test-reverse" {
+			t.Fatalf("unexpected child comparator: %q", name)
+		}
+		if k, _ := child.Cursor().First(); string(k) != "c" {
+			t.Fatalf("unexpected first child key: %q", k)
+		}
+		if inner := child.Bucket([]byte("a")); inner == nil || inner.Comparator() != "" {
+			t.Fatal("expected inner bucket without comparator")
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range rand.Perm(n) {
+			if err := b.Put(u64tob(uint64(i)), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		child, err := b.CreateBucketWithOptions([]byte("child"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := child.CreateBucket([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"b", "c"} {
+			if err := child.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < n; i += 2 {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx)
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			t.Errorf("unexpected check error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that creating a bucket with an unregistered comparator returns an
+// error, as does creating an existing bucket with another one.
+func TestBucket_CreateBucketWithOptions_ErrUnknownComparator(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: "test-unknown"}); err != bolt.ErrUnknownComparator {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.CreateBucketIfNotExistsWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: "test-reverse"}); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if b, err := tx.CreateBucketIfNotExistsWithOptions([]byte("widgets"), &bolt.BucketOptions{}); err != nil || b == nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket whose comparator is not registered cannot be opened.
+func TestBucket_Comparator_Unregistered(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: "test-reverse"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rename the comparator stored in the bucket header.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(db.f)
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Count(buf, []byte("test-reverse")) != 1 {
+		t.Fatal("expected comparator name in data file")
+	}
+	if err := os.WriteFile(db.f, bytes.Replace(buf, []byte("test-reverse"), []byte("test-unknown"), 1), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte("widgets")); b != nil {
+			t.Fatal("expected nil bucket")
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("widgets")); err != bolt.ErrUnknownComparator {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tx.DeleteBucket([]byte("widgets")); err != bolt.ErrUnknownComparator {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error { return nil }); err != bolt.ErrUnknownComparator {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// The deep check reports the comparator, but still reaches the pages.
+		var errs []error
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			errs = append(errs, err)
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), `unknown comparator "test-unknown"`) {
+			t.Fatalf("unexpected check errors: %v", errs)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket whose comparator is not registered can be read in
+// stored key order with Options.RawKeyOrder.
+func TestBucket_Comparator_RawKeyOrder(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{RawKeyOrder: true})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: "test-reverse"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("0500")); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if i == 500 {
+				continue
+			}
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rename the comparator stored in the bucket header.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(db.f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(db.f, bytes.Replace(buf, []byte("test-reverse"), []byte("test-unknown"), 1), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b == nil {
+			t.Fatal("expected bucket")
+		}
+
+		// Keys are iterated in the order of the comparator they were stored with.
+		var n int
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if exp := fmt.Sprintf("%04d", 999-n); string(k) != exp {
+				t.Fatalf("unexpected key %q, expected %q", k, exp)
+			}
+			n++
+		}
+		if n != 1000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+
+		// Lookups only find exact keys.
+		if v := b.Get([]byte("0123")); string(v) != "v123" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if v := b.Get([]byte("1000")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if k, _ := c.Seek([]byte("01235")); k != nil {
+			t.Fatalf("unexpected key: %q", k)
+		}
+		if b.Bucket([]byte("0500")) == nil {
+			t.Fatal("expected nested bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writable transactions still refuse the bucket.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte("widgets")); b != nil {
+			t.Fatal("expected nil bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket created with DupSort holds sorted values per key.
+func TestBucket_PutDup(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const n = 2000
+	expected := map[string][][]byte{"a": {[]byte("x"), []byte("y"), []byte("z")}, "c": {[]byte("v")}}
+	for i := 0; i < n; i++ {
+		expected["b"] = append(expected["b"], u64tob(uint64(i)))
+	}
+
+	check := func(tx *bolt.Tx) {
+		b := tx.Bucket([]byte("widgets"))
+		if !b.DupSort() {
+			t.Fatal("expected DupSort bucket")
+		}
+		c := b.Cursor()
+		var keys []string
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			keys = append(keys, string(k))
+			values := [][]byte{v}
+			for _, dv := c.NextDup(); dv != nil; _, dv = c.NextDup() {
+				values = append(values, dv)
+			}
+			if !reflect.DeepEqual(values, expected[string(k)]) {
+				t.Fatalf("unexpected values of %q: %d values", k, len(values))
+			} else if count := c.CountDups(); count != len(values) {
+				t.Fatalf("unexpected count of %q: %d", k, count)
+			}
+
+			// Walk back to the first value.
+			for i := len(values) - 2; i >= 0; i-- {
+				if _, dv := c.PrevDup(); !bytes.Equal(dv, values[i]) {
+					t.Fatalf("unexpected previous value of %q at %d: %x", k, i, dv)
+				}
+			}
+			if dk, dv := c.PrevDup(); dk != nil || dv != nil {
+				t.Fatalf("unexpected value before the first: %q=%x", dk, dv)
+			}
+		}
+		if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+			t.Fatalf("unexpected keys: %q", keys)
+		}
+		if v := b.Get([]byte("b")); !bytes.Equal(v, u64tob(0)) {
+			t.Fatalf("unexpected value: %x", v)
+		}
+		if child := b.Bucket([]byte("b")); child != nil {
+			t.Fatal("unexpected bucket")
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{DupSort: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("a"), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("a"), []byte("y")); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"z", "x", "y"} {
+			if err := b.PutDup([]byte("a"), []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, i := range rand.Perm(n) {
+			if err := b.PutDup([]byte("b"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.PutDup([]byte("b"), u64tob(7)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutDup([]byte("c"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		check(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx)
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			t.Errorf("unexpected check error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting values moves them back from their tree and releases it.
+func TestBucket_DeleteDup(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const n = 2000
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{DupSort: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := b.PutDup([]byte("a"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.PutDup([]byte("b"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if s := b.Stats(); s.BucketN != 3 {
+			t.Fatalf("unexpected bucket count: %d", s.BucketN)
+		}
+		for i := 10; i < n; i++ {
+			if err := b.DeleteDup([]byte("a"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.DeleteDup([]byte("a"), []byte("missing")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete([]byte("b")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if s := b.Stats(); s.BucketN != 1 {
+			t.Fatalf("unexpected bucket count: %d", s.BucketN)
+		}
+		c := b.Cursor()
+		if k, _ := c.First(); string(k) != "a" {
+			t.Fatalf("unexpected key: %q", k)
+		} else if count := c.CountDups(); count != 10 {
+			t.Fatalf("unexpected count: %d", count)
+		}
+		for i := 0; i < 10; i++ {
+			if err := b.DeleteDup([]byte("a"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, _ := b.Cursor().First(); k != nil {
+			t.Fatalf("unexpected key: %q", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that duplicate values are rejected outside of DupSort buckets, and
+// that DupSort buckets reject nested buckets and blank values.
+func TestBucket_PutDup_Incompatible(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		plain, err := tx.CreateBucket([]byte("plain"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := plain.PutDup([]byte("foo"), []byte("bar")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{DupSort: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutDup([]byte("foo"), nil); err != bolt.ErrValueRequired {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := b.PutDup([]byte("foo"), make([]byte, bolt.MaxKeySize+1)); err != bolt.ErrValueTooLarge {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := b.PutReader([]byte("foo"), strings.NewReader("bar"), 3); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := tx.CreateBucketIfNotExistsWithOptions([]byte("widgets"), &bolt.BucketOptions{}); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a setting a value on a key with a bucket value returns an error.
 func TestBucket_Put_IncompatibleValue(t *testing.T) {
 	db := MustOpenDB()
@@ -1187,6 +1866,8 @@ func TestBucket_Put_ValueTooLarge(t *testing.T) {
 func TestBucket_Stats(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
+	} else if os.Getenv(testPrefixCompression) == "true" {
+		t.Skip("page sizes depend on prefix compression")
 	}
 
 	db := MustOpenDB()
@@ -1280,6 +1961,8 @@ func TestBucket_Stats_RandomFill(t *testing.T) {
 		t.Skip("skipping test in short mode.")
 	} else if os.Getpagesize() != 4096 {
 		t.Skip("invalid page size for test")
+	} else if os.Getenv(testPrefixCompression) == "true" {
+		t.Skip("page sizes depend on prefix compression")
 	}
 
 	db := MustOpenDB()
@@ -1568,10 +2251,159 @@ func TestBucket_Stats_Nested(t *testing.T) {
 	}
 }
 
+// Ensure own stats of nested buckets add up to the stats of their parent.
+func TestBucket_OwnStats(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		bar, err := b.CreateBucket([]byte("bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := bar.Put([]byte(strconv.Itoa(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		baz, err := bar.CreateBucket([]byte("baz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return baz.Put([]byte("0"), []byte("0"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		foo := tx.Bucket([]byte("foo"))
+		bar := foo.Bucket([]byte("bar"))
+		baz := bar.Bucket([]byte("baz"))
+
+		own := foo.OwnStats()
+		if own.KeyN != 1001 {
+			t.Fatalf("unexpected KeyN: %d", own.KeyN)
+		} else if own.BucketN != 1 {
+			t.Fatalf("unexpected BucketN: %d", own.BucketN)
+		} else if own.InlineBucketN != 0 {
+			t.Fatalf("unexpected InlineBucketN: %d", own.InlineBucketN)
+		} else if own.Depth != 2 {
+			t.Fatalf("unexpected Depth: %d", own.Depth)
+		}
+		if own := baz.OwnStats(); own.InlineBucketN != 1 || own.KeyN != 1 {
+			t.Fatalf("unexpected inline bucket stats: %+v", own)
+		}
+
+		var sum bolt.BucketStats
+		sum.Add(own)
+		sum.Add(bar.OwnStats())
+		sum.Add(baz.OwnStats())
+		stats := foo.Stats()
+		sum.Depth = stats.Depth
+		if sum != stats {
+			t.Fatalf("unexpected sum of own stats: %+v != %+v", sum, stats)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that prefix compression stores fewer pages and reports its savings.
+func TestBucket_Stats_PrefixCompression(t *testing.T) {
+	fill := func(db *DB) bolt.BucketStats {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10000; i++ {
+				k := fmt.Sprintf("tenant-0042/entity-%04d/%016d", i/100, i)
+				if err := b.Put([]byte(k), []byte("value")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		var stats bolt.BucketStats
+		if err := db.View(func(tx *bolt.Tx) error {
+			for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+				t.Error(err)
+			}
+			stats = tx.Bucket([]byte("widgets")).Stats()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	plain := MustOpenWithOption(&bolt.Options{})
+	defer plain.MustClose()
+	plain.PrefixCompression = false
+	plainStats := fill(plain)
+
+	db := MustOpenWithOption(&bolt.Options{PrefixCompression: true})
+	defer db.MustClose()
+	stats := fill(db)
+
+	if stats.KeyN != 10000 {
+		t.Fatalf("unexpected KeyN: %d", stats.KeyN)
+	} else if stats.LeafPrefixSaved == 0 || stats.BranchPrefixSaved == 0 {
+		t.Fatalf("unexpected savings: %d, %d", stats.LeafPrefixSaved, stats.BranchPrefixSaved)
+	} else if stats.LeafPageN >= plainStats.LeafPageN {
+		t.Fatalf("unexpected LeafPageN: %d, uncompressed %d", stats.LeafPageN, plainStats.LeafPageN)
+	}
+
+	// Compressed pages are read without the option.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.o = &bolt.Options{}
+	db.MustReopen()
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("tenant-0042/entity-0012/0000000000001234")); string(v) != "value" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		c := b.Cursor()
+		k, _ := c.Seek([]byte("tenant-0042/entity-0050"))
+		if string(k) != "tenant-0042/entity-0050/0000000000005000" {
+			t.Fatalf("unexpected key: %s", k)
+		}
+		n := 0
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			n++
+		}
+		if n != 10000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure a large bucket can calculate stats.
 func TestBucket_Stats_Large(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode.")
+	} else if os.Getenv(testPrefixCompression) == "true" {
+		t.Skip("page sizes depend on prefix compression")
 	}
 
 	db := MustOpenDB()
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// benchGenerator draws the keys and values of a benchmark from the
+// distributions set in BenchOptions. It is not safe for concurrent use.
+type benchGenerator struct {
+	options *BenchOptions
+	r       *rand.Rand
+
+	// zipf draws from [0, zipfN).
+	zipf  *rand.Zipf
+	zipfN int
+
+	// keys holds the existing keys drawn from by updates, deletes and
+	// point or scan reads, in key order.
+	keys [][]byte
+}
+
+// newBenchGenerator returns a generator seeded with seed.
+func newBenchGenerator(options *BenchOptions, seed int64) *benchGenerator {
+	return &benchGenerator{
+		options: options,
+		r:       rand.New(rand.NewSource(time.Now().UnixNano() + seed)),
+	}
+}
+
+// validateBenchOptions checks the key and value distribution options.
+func validateBenchOptions(options *BenchOptions) error {
+	switch options.KeyDistribution {
+	case "", "uniform", "zipf", "hotspot":
+	default:
+		return fmt.Errorf("invalid key distribution: %s", options.KeyDistribution)
+	}
+	switch options.ValueDistribution {
+	case "fixed", "uniform", "lognormal":
+	default:
+		return fmt.Errorf("invalid value distribution: %s", options.ValueDistribution)
+	}
+	switch options.WriteOp {
+	case "insert", "update", "delete":
+	default:
+		return fmt.Errorf("invalid write op: %s", options.WriteOp)
+	}
+	switch {
+	case options.KeySize < 4:
+		return fmt.Errorf("key size must be at least 4")
+	case options.KeySizeMax != 0 && options.KeySizeMax < options.KeySize:
+		return fmt.Errorf("key size max must not be less than key size")
+	case options.ValueSizeMax != 0 && options.ValueSizeMax < options.ValueSize:
+		return fmt.Errorf("value size max must not be less than value size")
+	case options.ZipfS <= 1:
+		return fmt.Errorf("zipf exponent must be greater than 1")
+	case options.HotspotKeys <= 0 || options.HotspotKeys > 1 || options.HotspotOps < 0 || options.HotspotOps > 1:
+		return fmt.Errorf("hotspot fractions must be in (0, 1]")
+	case options.KeySpace < 0:
+		return fmt.Errorf("key space must not be negative")
+	}
+	if strings.HasSuffix(options.WriteMode, "-nest") && (options.WriteOp != "insert" || options.ReadMode != "seq") {
+		return fmt.Errorf("nested write modes only support inserts and sequential reads")
+	}
+	return nil
+}
+
+// index returns an index in [0, n) drawn from options.KeyDistribution. The
+// zipf and hotspot distributions favor the lowest indexes.
+func (g *benchGenerator) index(n int) int {
+	switch g.options.KeyDistribution {
+	case "zipf":
+		if g.zipf == nil || g.zipfN != n {
+			g.zipf, g.zipfN = rand.NewZipf(g.r, g.options.ZipfS, 1, uint64(n-1)), n
+		}
+		return int(g.zipf.Uint64())
+	case "hotspot":
+		if hot := int(float64(n) * g.options.HotspotKeys); hot > 0 && g.r.Float64() < g.options.HotspotOps {
+			return g.r.Intn(hot)
+		}
+	}
+	return g.r.Intn(n)
+}
+
+// number returns the number of the next inserted key: the next one of last
+// for the seq write modes, a random one for the rnd write modes, or one drawn
+// from the key distribution over the key space if set.
+func (g *benchGenerator) number(last *uint32) uint32 {
+	switch {
+	case g.options.KeyDistribution != "":
+		return uint32(g.index(g.options.keySpace())) + 1
+	case strings.HasPrefix(g.options.WriteMode, "seq"):
+		return atomic.AddUint32(last, 1)
+	default:
+		return g.r.Uint32()
+	}
+}
+
+// keySpace returns the number of distinct keys inserted with a key
+// distribution.
+func (o *BenchOptions) keySpace() int {
+	if o.KeySpace > 0 {
+		return o.KeySpace
+	} else if o.Iterations > 0 {
+		return o.Iterations
+	}
+	return 1
+}
+
+// key returns the key numbered n. Its length is between KeySize and
+// KeySizeMax, and always the same for a given number.
+func (g *benchGenerator) key(n uint32) []byte {
+	size := g.options.KeySize
+	if span := g.options.KeySizeMax - g.options.KeySize; span > 0 {
+		size += int(n % uint32(span+1))
+	}
+	key := make([]byte, size)
+	binary.BigEndian.PutUint32(key, n)
+	return key
+}
+
+// value returns a zeroed value with a size drawn from options.ValueDistribution:
+// ValueSize for fixed, uniform between ValueSize and ValueSizeMax, or
+// log-normal with a median of ValueSize, capped at ValueSizeMax if set.
+func (g *benchGenerator) value() []byte {
+	size := g.options.ValueSize
+	switch g.options.ValueDistribution {
+	case "uniform":
+		if span := g.options.ValueSizeMax - g.options.ValueSize; span > 0 {
+			size += g.r.Intn(span + 1)
+		}
+	case "lognormal":
+		size = int(float64(g.options.ValueSize) * math.Exp(g.r.NormFloat64()*g.options.ValueSigma))
+		if g.options.ValueSizeMax > 0 && size > g.options.ValueSizeMax {
+			size = g.options.ValueSizeMax
+		}
+	}
+	return make([]byte, size)
+}
+
+// loadKeys reads up to options.KeySpace keys of the bench bucket, or all of
+// them if the key space is not set.
+func (g *benchGenerator) loadKeys(db *bolt.DB) error {
+	g.keys = nil
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(g.options.BucketName))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if g.options.KeySpace > 0 && len(g.keys) >= g.options.KeySpace {
+				break
+			} else if v != nil {
+				g.keys = append(g.keys, cloneBytes(k))
+			}
+		}
+		if len(g.keys) == 0 {
+			return fmt.Errorf("no keys in bucket %q", g.options.BucketName)
+		}
+		return nil
+	})
+}
+
+// existingKey returns one of the loaded keys drawn from the key distribution.
+func (g *benchGenerator) existingKey() []byte {
+	return g.keys[g.index(len(g.keys))]
+}
+
+// runReadsKeys reads existing keys drawn from the key distribution for at
+// least a second: a get reads one key, a range read options.ScanLength keys
+// from it and a prefix read all keys sharing its first options.PrefixLength
+// bytes.
+func (cmd *BenchCommand) runReadsKeys(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
+	g := newBenchGenerator(options, 0)
+	if err := g.loadKeys(db); err != nil {
+		return err
+	}
+
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(options.BucketName))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+
+		for t := time.Now(); time.Since(t) < time.Second; {
+			key := g.existingKey()
+			switch options.ReadMode {
+			case "get":
+				if b.Get(key) == nil {
+					return ErrInvalidValue
+				}
+				results.ReadOps++
+			case "range":
+				c := b.Cursor()
+				n := 0
+				for k, _ := c.Seek(key); k != nil && n < options.ScanLength; k, _ = c.Next() {
+					n++
+				}
+				results.ReadOps += n
+			case "prefix":
+				prefix := key
+				if len(prefix) > options.PrefixLength {
+					prefix = prefix[:options.PrefixLength]
+				}
+				c := b.Cursor()
+				for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+					results.ReadOps++
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BenchSample holds the number of operations completed during one second of
+// a mixed benchmark.
+type BenchSample struct {
+	Reads  int
+	Writes int
+}
+
+// runMixed runs options.Readers reading goroutines alongside
+// options.Writers writing goroutines for options.Duration. Each read is a
+// read-only transaction getting one key and each write is a transaction
+// putting or deleting one key, committed with DB.Update or, if options.WriteBatch is set,
+// with DB.Batch.
+func (cmd *BenchCommand) runMixed(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
+	// Keys are numbered after the ones written before, or random.
+	var last uint32
+	switch options.WriteMode {
+	case "seq":
+		last = uint32(options.Iterations)
+	case "rnd":
+	default:
+		return fmt.Errorf("invalid write mode for mixed workload: %s", options.WriteMode)
+	}
+
+	// Updates, deletes and reads of an existing database apply to its
+	// existing keys.
+	var keys [][]byte
+	if options.Existing || options.WriteOp != "insert" {
+		g := newBenchGenerator(options, 0)
+		if err := g.loadKeys(db); err != nil {
+			return err
+		}
+		keys = g.keys
+	}
+
+	// Profile like the read phase it replaces.
+	if options.ProfileMode == "r" {
+		cmd.startProfiling(options)
+	}
+	if options.ProfileMode == "rw" || options.ProfileMode == "r" {
+		defer cmd.stopProfiling()
+	}
+
+	var reads, writes int64
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errc := make(chan error, options.Readers+options.Writers)
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	readLatencies := make([]LatencyHistogram, options.Readers)
+	for i := 0; i < options.Readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := newBenchGenerator(options, int64(i))
+			g.keys = keys
+			for !stopped() {
+				var key []byte
+				switch {
+				case keys != nil:
+					key = g.existingKey()
+				case options.KeyDistribution != "":
+					key = g.key(uint32(g.index(options.keySpace())) + 1)
+				case options.WriteMode == "seq":
+					key = g.key(uint32(g.r.Int63n(int64(atomic.LoadUint32(&last)) + 1)))
+				default:
+					key = g.key(g.r.Uint32())
+				}
+
+				t := time.Now()
+				if err := db.View(func(tx *bolt.Tx) error {
+					if b := tx.Bucket([]byte(options.BucketName)); b != nil {
+						_ = b.Get(key)
+					}
+					return nil
+				}); err != nil {
+					errc <- err
+					return
+				}
+				readLatencies[i].Record(time.Since(t))
+				atomic.AddInt64(&reads, 1)
+			}
+		}(i)
+	}
+
+	writeLatencies := make([]LatencyHistogram, options.Writers)
+	for i := 0; i < options.Writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := newBenchGenerator(options, -int64(i)-1)
+			g.keys = keys
+			for !stopped() {
+				// Keep the share of writes under 1-ReadRatio. There is
+				// nothing to keep it against without readers.
+				for options.ReadRatio > 0 && options.Readers > 0 && !stopped() &&
+					float64(atomic.LoadInt64(&writes)) >= (1-options.ReadRatio)*float64(atomic.LoadInt64(&reads)+atomic.LoadInt64(&writes)) {
+					time.Sleep(100 * time.Microsecond)
+				}
+				if stopped() {
+					return
+				}
+
+				var key []byte
+				if keys != nil {
+					key = g.existingKey()
+				} else {
+					key = g.key(g.number(&last))
+				}
+				value := g.value()
+				put := func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte(options.BucketName))
+					if err != nil {
+						return err
+					}
+					b.FillPercent = options.FillPercent
+					if options.WriteOp == "delete" {
+						return b.Delete(key)
+					}
+					return b.Put(key, value)
+				}
+
+				t := time.Now()
+				var err error
+				if options.WriteBatch {
+					err = db.Batch(put)
+				} else {
+					err = db.Update(put)
+				}
+				if err != nil {
+					errc <- err
+					return
+				}
+				writeLatencies[i].Record(time.Since(t))
+				atomic.AddInt64(&writes, 1)
+			}
+		}(i)
+	}
+
+	// Sample the throughput every second until the end of the run or the
+	// first error.
+	t := time.Now()
+	ticker := time.NewTicker(time.Second)
+	timer := time.NewTimer(options.Duration)
+	var lastReads, lastWrites int64
+	var err error
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			r, w := atomic.LoadInt64(&reads), atomic.LoadInt64(&writes)
+			results.Series = append(results.Series, BenchSample{Reads: int(r - lastReads), Writes: int(w - lastWrites)})
+			lastReads, lastWrites = r, w
+		case <-timer.C:
+			break loop
+		case err = <-errc:
+			break loop
+		}
+	}
+	ticker.Stop()
+	timer.Stop()
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(t)
+
+	results.ReadOps, results.ReadDuration = int(reads), elapsed
+	results.WriteOps, results.WriteDuration = int(writes), elapsed
+	for i := range readLatencies {
+		results.ReadLatencies.Merge(&readLatencies[i])
+	}
+	for i := range writeLatencies {
+		results.CommitLatencies.Merge(&writeLatencies[i])
+	}
+	return err
+}
+
+// latencySubBuckets is the number of buckets each power of two of
+// nanoseconds is split into by LatencyHistogram.
+const latencySubBuckets = 8
+
+// LatencyHistogram counts latencies in buckets which are twice as wide at
+// every power of two, so its size doesn't depend on the number of samples.
+// Percentiles are accurate to within 1/latencySubBuckets.
+type LatencyHistogram struct {
+	counts [64 * latencySubBuckets]int64
+	n      int64
+	max    time.Duration
+}
+
+// latencyBucket returns the index of the bucket of the latency d.
+func latencyBucket(d time.Duration) int {
+	ns := uint64(d)
+	if d < 0 {
+		ns = 0
+	}
+	if ns < latencySubBuckets {
+		return int(ns)
+	}
+	// Index by the power of two of ns and the bits following its highest one.
+	shift := bits.Len64(ns) - bits.Len64(latencySubBuckets)
+	return latencySubBuckets*(shift+1) + int(ns>>uint(shift)) - latencySubBuckets
+}
+
+// latencyBucketMax returns the highest latency counted in the bucket i.
+func latencyBucketMax(i int) time.Duration {
+	if i < latencySubBuckets {
+		return time.Duration(i)
+	}
+	shift := uint(i/latencySubBuckets - 1)
+	top := uint64(latencySubBuckets + i%latencySubBuckets)
+	return time.Duration((top+1)<<shift - 1)
+}
+
+// Record counts the latency d.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	h.counts[latencyBucket(d)]++
+	h.n++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds the latencies counted by other to h.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.n += other.n
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns the number of latencies counted.
+func (h *LatencyHistogram) Count() int {
+	return int(h.n)
+}
+
+// Percentile returns the latency under which fall p percent of the
+// latencies, rounded up to the end of its bucket.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := int64(float64(h.n)*p/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	} else if rank >= h.n {
+		return h.max
+	}
+	var n int64
+	for i, c := range h.counts {
+		if n += c; n >= rank {
+			if d := latencyBucketMax(i); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// printMixedResults prints the results of a mixed benchmark.
+func printMixedResults(w io.Writer, options *BenchOptions, results *BenchResults) {
+	fmt.Fprintf(w, "# Mixed\t%v\t(%d readers, %d writers, batch=%v)\n", results.ReadDuration, options.Readers, options.Writers, options.WriteBatch)
+	for _, l := range []struct {
+		name      string
+		ops       int
+		latencies *LatencyHistogram
+	}{
+		{"Read", results.ReadOps, &results.ReadLatencies},
+		{"Commit", results.WriteOps, &results.CommitLatencies},
+	} {
+		var opsPerSecond int
+		if results.ReadDuration > 0 {
+			opsPerSecond = int(float64(l.ops) / results.ReadDuration.Seconds())
+		}
+		fmt.Fprintf(w, "# %s\t%d ops\t(%v op/sec)\tp50=%v\tp95=%v\tp99=%v\tmax=%v\n", l.name, l.ops, opsPerSecond,
+			l.latencies.Percentile(50), l.latencies.Percentile(95), l.latencies.Percentile(99), l.latencies.Percentile(100))
+	}
+	fmt.Fprintln(w, "# Second\tReads\tWrites")
+	for i, s := range results.Series {
+		fmt.Fprintf(w, "%d\t%d\t%d\n", i+1, s.Reads, s.Writes)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrDifferencesFound is returned when the diff command finds differences.
+// The process exits with status 1 without printing it.
+var ErrDifferencesFound = errors.New("differences found")
+
+// DiffError wraps the errors of the diff command, other than
+// ErrDifferencesFound and ErrUsage. The process exits with status 2 on
+// them, so that scripts can tell differences from failures.
+type DiffError struct {
+	Err error
+}
+
+// Error returns the message of the wrapped error.
+func (e *DiffError) Error() string {
+	return e.Err.Error()
+}
+
+// DiffCommand represents the "diff" command execution.
+type DiffCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newDiffCommand returns a DiffCommand.
+func newDiffCommand(m *Main) *DiffCommand {
+	return &DiffCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// diffRecord describes a single difference. Bucket is the path of the bucket
+// containing the bucket or key named Key.
+type diffRecord struct {
+	Op          string   `json:"op"`
+	Type        string   `json:"type"`
+	Bucket      [][]byte `json:"bucket,omitempty"`
+	Key         []byte   `json:"key"`
+	Value       *[]byte  `json:"value,omitempty"`
+	OldValue    *[]byte  `json:"old_value,omitempty"`
+	NewValue    *[]byte  `json:"new_value,omitempty"`
+	OldSequence *uint64  `json:"old_sequence,omitempty"`
+	NewSequence *uint64  `json:"new_sequence,omitempty"`
+}
+
+// Run executes the command.
+func (cmd *DiffCommand) Run(args ...string) error {
+	err := cmd.run(args...)
+	if err != nil && err != ErrDifferencesFound && err != ErrUsage {
+		return &DiffError{Err: err}
+	}
+	return err
+}
+
+func (cmd *DiffCommand) run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	format := fs.String("format", "text", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if *format != "text" && *format != "json" {
+		return fmt.Errorf("unsupported format: %s", *format)
+	}
+
+	// Require both database paths.
+	pathA, pathB := fs.Arg(0), fs.Arg(1)
+	if pathA == "" || pathB == "" {
+		return ErrPathRequired
+	}
+	for _, path := range []string{pathA, pathB} {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return ErrFileNotFound
+		}
+	}
+
+	// Open databases.
+	dbA, err := bolt.Open(pathA, 0666, &bolt.Options{ReadOnly: true, RawKeyOrder: true})
+	if err != nil {
+		return err
+	}
+	defer dbA.Close()
+	dbB, err := bolt.Open(pathB, 0666, &bolt.Options{ReadOnly: true, RawKeyOrder: true})
+	if err != nil {
+		return err
+	}
+	defer dbB.Close()
+
+	var n int
+	report := func(r *diffRecord) error {
+		n++
+		if *format == "json" {
+			return json.NewEncoder(cmd.Stdout).Encode(r)
+		}
+		return cmd.printRecord(r)
+	}
+
+	// Compare both trees.
+	if err := dbA.View(func(txA *bolt.Tx) error {
+		return dbB.View(func(txB *bolt.Tx) error {
+			return diffBuckets(txA, txB, nil, report)
+		})
+	}); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return ErrDifferencesFound
+	}
+	return nil
+}
+
+// printRecord prints a difference in text form.
+func (cmd *DiffCommand) printRecord(r *diffRecord) error {
+	name := formatBucketPath(append(r.Bucket[:len(r.Bucket):len(r.Bucket)], r.Key))
+	var err error
+	switch {
+	case r.Type == "sequence":
+		_, err = fmt.Fprintf(cmd.Stdout, "* sequence %s: %d -> %d\n", name, *r.OldSequence, *r.NewSequence)
+	case r.Op == "changed":
+		_, err = fmt.Fprintf(cmd.Stdout, "* key %s: %q -> %q\n", name, *r.OldValue, *r.NewValue)
+	case r.Type == "bucket":
+		_, err = fmt.Fprintf(cmd.Stdout, "%s bucket %s\n", diffOpSymbol(r.Op), name)
+	default:
+		_, err = fmt.Fprintf(cmd.Stdout, "%s key %s = %q\n", diffOpSymbol(r.Op), name, *r.Value)
+	}
+	return err
+}
+
+// Usage returns the help message.
+func (cmd *DiffCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt diff [options] A B
+
+Diff walks the buckets of the databases at paths A and B in key order and
+reports the buckets and keys which were added, removed or changed from A to B,
+as well as bucket sequence changes. The content of an added or removed bucket
+is not listed.
+
+In text form, each difference is printed on its own line, prefixed with "+"
+for additions, "-" for removals and "*" for changes. Bucket paths are printed
+as quoted names separated by "/".
+
+The process exits with status 0 if no differences were found, with status 1 if
+differences were found, and with status 2 if an error occurred.
+
+Additional options include:
+
+	-format FORMAT
+		Output format. One of: text|json (default=text)
+		The json format prints one JSON object per line with the fields
+		"op" (added|removed|changed), "type" (bucket|key|sequence),
+		"bucket", "key", "value", "old_value", "new_value",
+		"old_sequence" and "new_sequence". Bytes are base64 encoded.
+`, "\n")
+}
+
+// bucketContainer is implemented by both *bolt.Tx and *bolt.Bucket.
+type bucketContainer interface {
+	Cursor() *bolt.Cursor
+	Bucket(name []byte) *bolt.Bucket
+}
+
+// entryValue returns the value of key k in c, whose cursor value is v.
+// Cursors return a nil value for both nested buckets and values set by
+// PutReader, so the latter are read with GetReader. It returns nil for
+// nested buckets.
+func entryValue(c bucketContainer, k, v []byte) ([]byte, error) {
+	b, ok := c.(*bolt.Bucket)
+	if v != nil || k == nil || !ok {
+		return v, nil
+	}
+	if r := b.GetReader(k); r != nil {
+		return io.ReadAll(r)
+	}
+	return nil, nil
+}
+
+// diffBuckets compares the content of a and b at path, calling fn for each
+// difference in key order.
+func diffBuckets(a, b bucketContainer, path [][]byte, fn func(*diffRecord) error) error {
+	ca, cb := a.Cursor(), b.Cursor()
+	ka, va := ca.First()
+	kb, vb := cb.First()
+	for ka != nil || kb != nil {
+		var err error
+		if va, err = entryValue(a, ka, va); err != nil {
+			return err
+		} else if vb, err = entryValue(b, kb, vb); err != nil {
+			return err
+		}
+
+		cmp := 0
+		switch {
+		case ka == nil:
+			cmp = 1
+		case kb == nil:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(ka, kb)
+		}
+
+		if cmp < 0 {
+			if err := fn(diffEntry("removed", path, ka, va)); err != nil {
+				return err
+			}
+			ka, va = ca.Next()
+			continue
+		} else if cmp > 0 {
+			if err := fn(diffEntry("added", path, kb, vb)); err != nil {
+				return err
+			}
+			kb, vb = cb.Next()
+			continue
+		}
+
+		switch {
+		case va == nil && vb == nil:
+			err = diffBucket(a.Bucket(ka), b.Bucket(kb), path, ka, fn)
+		case va == nil || vb == nil:
+			// A bucket replaced by a key, or the reverse.
+			if err = fn(diffEntry("removed", path, ka, va)); err == nil {
+				err = fn(diffEntry("added", path, kb, vb))
+			}
+		case !bytes.Equal(va, vb):
+			oldValue, newValue := cloneBytes(va), cloneBytes(vb)
+			err = fn(&diffRecord{Op: "changed", Type: "key", Bucket: path, Key: cloneBytes(ka), OldValue: &oldValue, NewValue: &newValue})
+		}
+		if err != nil {
+			return err
+		}
+		ka, va = ca.Next()
+		kb, vb = cb.Next()
+	}
+	return nil
+}
+
+// diffBucket compares the sequences and the content of two buckets with the
+// same name.
+func diffBucket(a, b *bolt.Bucket, path [][]byte, name []byte, fn func(*diffRecord) error) error {
+	if seqA, seqB := a.Sequence(), b.Sequence(); seqA != seqB {
+		if err := fn(&diffRecord{Op: "changed", Type: "sequence", Bucket: path, Key: cloneBytes(name), OldSequence: &seqA, NewSequence: &seqB}); err != nil {
+			return err
+		}
+	}
+	return diffBuckets(a, b, append(path[:len(path):len(path)], cloneBytes(name)), fn)
+}
+
+// diffEntry returns the record of an added or removed bucket or key.
+func diffEntry(op string, path [][]byte, k, v []byte) *diffRecord {
+	r := &diffRecord{Op: op, Type: "bucket", Bucket: path, Key: cloneBytes(k)}
+	if v != nil {
+		value := cloneBytes(v)
+		r.Type, r.Value = "key", &value
+	}
+	return r
+}
+
+func diffOpSymbol(op string) string {
+	if op == "added" {
+		return "+"
+	}
+	return "-"
+}
+
+// formatBucketPath formats a bucket path as quoted names separated by "/".
+func formatBucketPath(path [][]byte) string {
+	names := make([]string, len(path))
+	for i, name := range path {
+		names[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(names, "/")
+}
+
+func cloneBytes(v []byte) []byte {
+	var clone = make([]byte, len(v))
+	copy(clone, v)
+	return clone
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ExportCommand represents the "export" command execution.
+type ExportCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newExportCommand returns an ExportCommand.
+func newExportCommand(m *Main) *ExportCommand {
+	return &ExportCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ExportCommand) Run(args ...string) (err error) {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	encoding := fs.String("encoding", string(bolt.ExportBase64), "")
+	outPath := fs.String("o", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true, RawKeyOrder: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Write to the output file if given, or else to stdout.
+	w := cmd.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+
+	return bolt.Export(w, db, bolt.ExportEncoding(*encoding))
+}
+
+// Usage returns the help message.
+func (cmd *ExportCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt export [options] PATH
+
+Export writes every bucket and key of the database at PATH as newline-delimited
+JSON records. The first record is a header carrying the format version and
+encoding. It is followed by one record per bucket or key, in key order:
+
+	{"type":"bucket","bucket":[PARENT...],"key":NAME,"sequence":SEQ}
+	{"type":"key","bucket":[BUCKET...],"key":KEY,"value":VALUE}
+
+Bucket names, keys and values are encoded. The output can be imported with
+"bbolt import".
+
+Additional options include:
+
+	-encoding ENCODING
+		Specifies how bucket names, keys and values are encoded.
+		One of: base64|hex (default=base64)
+	-o PATH
+		Write to the file at PATH instead of stdout.
+`, "\n")
+}
+
+// ImportCommand represents the "import" command execution.
+type ImportCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newImportCommand returns an ImportCommand.
+func newImportCommand(m *Main) *ImportCommand {
+	return &ImportCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ImportCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	inPath := fs.String("i", "", "")
+	txMaxSize := fs.Int64("tx-max-size", 65536, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	}
+
+	// Read from the input file if given, or else from stdin.
+	r := cmd.Stdin
+	if *inPath != "" {
+		f, err := os.Open(*inPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// Open database, creating it if needed.
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return bolt.Import(db, r, *txMaxSize)
+}
+
+// Usage returns the help message.
+func (cmd *ImportCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt import [options] PATH
+
+Import reads records written by "bbolt export" and writes their buckets and
+keys into the database at PATH, which is created if it does not exist.
+Importing into a new database recreates the exported one.
+
+Additional options include:
+
+	-i PATH
+		Read from the file at PATH instead of stdin.
+	-tx-max-size NUM
+		Specifies the maximum size of individual transactions.
+		Defaults to 64KB.
+`, "\n")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"unsafe"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// This file holds the JSON schemas printed by commands when the global
+// -format option is json. Byte strings such as keys, values and bucket names
+// are base64 encoded.
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// infoJSON is printed by the info command.
+type infoJSON struct {
+	PageSize int `json:"page_size"`
+}
+
+// statsJSON is printed by the stats command.
+type statsJSON struct {
+	BucketCount int              `json:"bucket_count"`
+	Stats       bolt.BucketStats `json:"stats"`
+}
+
+// pageInfoJSON is printed for each page by the pages command.
+type pageInfoJSON struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Count    int    `json:"count"`
+	Overflow int    `json:"overflow"`
+}
+
+// pageJSON is printed for each page by the page command.
+type pageJSON struct {
+	ID       int            `json:"id"`
+	Type     string         `json:"type"`
+	Size     int            `json:"size"`
+	Overflow int            `json:"overflow"`
+	Meta     *metaJSON      `json:"meta,omitempty"`
+	Items    []pageItemJSON `json:"items,omitempty"`
+	Freelist []uint64       `json:"freelist,omitempty"`
+}
+
+// metaJSON describes a meta page.
+type metaJSON struct {
+	Magic    string `json:"magic"`
+	Version  uint32 `json:"version"`
+	PageSize uint32 `json:"page_size"`
+	Flags    uint32 `json:"flags"`
+	Root     uint64 `json:"root"`
+	Freelist uint64 `json:"freelist"`
+	HWM      uint64 `json:"hwm"`
+	TxID     uint64 `json:"txid"`
+	Checksum string `json:"checksum"`
+}
+
+// pageItemJSON describes an element of a leaf or branch page. Value and
+// Bucket are only set for leaf elements, PageID only for branch elements.
+type pageItemJSON struct {
+	Key    []byte            `json:"key"`
+	Value  []byte            `json:"value,omitempty"`
+	Bucket *bucketHeaderJSON `json:"bucket,omitempty"`
+	PageID uint64            `json:"pgid,omitempty"`
+}
+
+// bucketHeaderJSON describes a nested bucket header.
+type bucketHeaderJSON struct {
+	Root     uint64 `json:"root"`
+	Sequence uint64 `json:"sequence"`
+}
+
+// checkJSON is printed by the check command.
+type checkJSON struct {
+	OK     bool             `json:"ok"`
+	Errors []checkErrorJSON `json:"errors"`
+}
+
+// checkErrorJSON describes an error found by the check command. PageID and
+// Bucket are only set for structural errors found by a deep check.
+type checkErrorJSON struct {
+	Message string   `json:"message"`
+	PageID  *int     `json:"page_id,omitempty"`
+	Bucket  [][]byte `json:"bucket,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// newMetaJSON returns the description of a meta page held in buf.
+func newMetaJSON(buf []byte) *metaJSON {
+	m := (*meta)(unsafe.Pointer(&buf[PageHeaderSize]))
+	return &metaJSON{
+		Magic:    fmt.Sprintf("%08x", m.magic),
+		Version:  m.version,
+		PageSize: m.pageSize,
+		Flags:    m.flags,
+		Root:     uint64(m.root.root),
+		Freelist: uint64(m.freelist),
+		HWM:      uint64(m.pgid),
+		TxID:     uint64(m.txid),
+		Checksum: fmt.Sprintf("%016x", m.checksum),
+	}
+}
+
+// newPageJSON returns the description of a page held in buf.
+func newPageJSON(buf []byte) *pageJSON {
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	pj := &pageJSON{ID: int(p.id), Type: p.Type(), Size: len(buf), Overflow: int(p.overflow)}
+	switch p.Type() {
+	case "meta":
+		pj.Meta = newMetaJSON(buf)
+	case "leaf":
+		pj.Items = []pageItemJSON{}
+		for i := uint16(0); i < p.count; i++ {
+			e := p.leafPageElement(i)
+			item := pageItemJSON{Key: p.expandKey(e.key())}
+			// The value of a damaged bucket element can be too short
+			// for a bucket header, so it is printed as is.
+			if (e.flags&uint32(bucketLeafFlag)) != 0 && len(e.value()) >= int(unsafe.Sizeof(bucket{})) {
+				b := (*bucket)(unsafe.Pointer(&e.value()[0]))
+				item.Bucket = &bucketHeaderJSON{Root: uint64(b.root), Sequence: b.sequence}
+			} else {
+				item.Value = e.value()
+			}
+			pj.Items = append(pj.Items, item)
+		}
+	case "branch":
+		pj.Items = []pageItemJSON{}
+		for i := uint16(0); i < p.count; i++ {
+			e := p.branchPageElement(i)
+			pj.Items = append(pj.Items, pageItemJSON{Key: p.expandKey(e.key()), PageID: uint64(e.pgid)})
+		}
+	case "freelist":
+		idx, count := 0, int(p.count)
+		if p.count == 0xFFFF {
+			idx = 1
+			count = int(((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[0])
+		}
+		ids := (*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr))
+		pj.Freelist = []uint64{}
+		for i := idx; i < count; i++ {
+			pj.Freelist = append(pj.Freelist, uint64(ids[i]))
+		}
+	}
+	return pj
+}
+
+// newCheckErrorJSON returns the description of an error found by a check.
+func newCheckErrorJSON(err error) checkErrorJSON {
+	ej := checkErrorJSON{Message: err.Error()}
+	if cerr, ok := err.(*bolt.CheckError); ok {
+		ej.PageID, ej.Bucket, ej.Reason = &cerr.PageID, cerr.Bucket, cerr.Reason
+	}
+	return ej
+}
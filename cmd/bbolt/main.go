@@ -2,17 +2,19 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"math/rand"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -66,12 +68,26 @@ const PageHeaderSize = 16
 
 func main() {
 	m := NewMain()
-	if err := m.Run(os.Args[1:]...); err == ErrUsage {
-		os.Exit(2)
-	} else if err != nil {
+	err := m.Run(os.Args[1:]...)
+	if err != nil && err != ErrUsage && err != ErrDifferencesFound {
 		fmt.Println(err.Error())
-		os.Exit(1)
 	}
+	os.Exit(ExitStatus(err))
+}
+
+// ExitStatus returns the status the process exits with when Main.Run returns
+// err.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	} else if err == ErrUsage {
+		return 2
+	} else if err == ErrDifferencesFound {
+		return 1
+	} else if _, ok := err.(*DiffError); ok {
+		return 2
+	}
+	return 1
 }
 
 // Main represents the main program execution.
@@ -79,6 +95,12 @@ type Main struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format of the commands supporting it, set by the
+	// global -format option. One of: text, json. Global options come before
+	// the command name, so it doesn't clash with the -format option of the
+	// keys and get commands, which encodes bytes.
+	Format string
 }
 
 // NewMain returns a new instance of Main connect to the standard input/output.
@@ -92,8 +114,20 @@ func NewMain() *Main {
 
 // Run executes the program.
 func (m *Main) Run(args ...string) error {
+	// Parse global flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&m.Format, "format", "text", "")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintln(m.Stderr, m.Usage())
+		return ErrUsage
+	} else if m.Format != "text" && m.Format != "json" {
+		return fmt.Errorf("unsupported format: %s", m.Format)
+	}
+	args = fs.Args()
+
 	// Require a command at the beginning.
-	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+	if len(args) == 0 {
 		fmt.Fprintln(m.Stderr, m.Usage())
 		return ErrUsage
 	}
@@ -111,22 +145,48 @@ func (m *Main) Run(args ...string) error {
 		return newCheckCommand(m).Run(args[1:]...)
 	case "compact":
 		return newCompactCommand(m).Run(args[1:]...)
+	case "create-bucket":
+		return newCreateBucketCommand(m).Run(args[1:]...)
+	case "delete":
+		return newDeleteCommand(m).Run(args[1:]...)
+	case "delete-bucket":
+		return newDeleteBucketCommand(m).Run(args[1:]...)
+	case "diff":
+		return newDiffCommand(m).Run(args[1:]...)
 	case "dump":
 		return newDumpCommand(m).Run(args[1:]...)
 	case "page-item":
 		return newPageItemCommand(m).Run(args[1:]...)
+	case "export":
+		return newExportCommand(m).Run(args[1:]...)
 	case "get":
 		return newGetCommand(m).Run(args[1:]...)
+	case "import":
+		return newImportCommand(m).Run(args[1:]...)
 	case "info":
 		return newInfoCommand(m).Run(args[1:]...)
 	case "keys":
 		return newKeysCommand(m).Run(args[1:]...)
+	case "meta":
+		return newMetaCommand(m).Run(args[1:]...)
 	case "page":
 		return newPageCommand(m).Run(args[1:]...)
 	case "pages":
 		return newPagesCommand(m).Run(args[1:]...)
+	case "put":
+		return newPutCommand(m).Run(args[1:]...)
+	case "salvage":
+		return newSalvageCommand(m).Run(args[1:]...)
+	case "shell":
+		return newShellCommand(m).Run(args[1:]...)
+	case "serve":
+		return newServeCommand(m).Run(args[1:]...)
+	case "space-map":
+		return newSpaceMapCommand(m).Run(args[1:]...)
 	case "stats":
 		return newStatsCommand(m).Run(args[1:]...)
+	case "surgery":
+		return newSurgeryCommand(m).Run(args[1:]...)
 	default:
 		return ErrUnknownCommand
 	}
@@ -139,7 +199,15 @@ Bbolt is a tool for inspecting bbolt databases.
 
 Usage:
 
-	bbolt command [arguments]
+	bbolt [global options] command [arguments]
+
+The global options are:
+
+    -format FORMAT
+                output format of the info, stats, pages, page, buckets, keys
+                and check commands. One of: text|json (default=text)
+                It must precede the command, unlike the -format option of the
+                keys and get commands, e.g. "bbolt -format json info PATH".
 
 The commands are:
 
@@ -147,15 +215,30 @@ The commands are:
     buckets     print a list of buckets
     check       verifies integrity of bbolt database
     compact     copies a bbolt database, compacting it in the process
+    create-bucket
+                create a (sub)bucket
+    delete      delete a key from a bucket
+    delete-bucket
+                delete a (sub)bucket and all of its content
+    diff        print the differences between two bbolt databases
     dump        print a hexadecimal dump of a single page
+    export      write all buckets and keys as JSON records
     get         print the value of a key in a bucket
+    import      read buckets and keys written by export
     info        print basic info
     keys        print a list of keys in a bucket
+    meta        print both meta pages and their validation status
     help        print this screen
     page        print one or more pages in human readable format
     pages       print list of pages with their types
     page-item   print the key and value of a page item.
+    put         set the value of a key in a bucket
+    salvage     copies all readable keys of a corrupted bbolt database
+    shell       browse and edit a bbolt database interactively
+    serve       browse a database from a web browser
+    space-map   print a map of page usage and free space
     stats       iterate over all pages and generate usage stats
+    surgery     perform surgery on a copy of a damaged bbolt database
 
 Use "bbolt [command] -h" for more information about a command.
 `, "\n")
@@ -166,6 +249,9 @@ type CheckCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // NewCheckCommand returns a CheckCommand.
@@ -174,6 +260,7 @@ func newCheckCommand(m *Main) *CheckCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
@@ -182,6 +269,7 @@ func (cmd *CheckCommand) Run(args ...string) error {
 	// Parse flags.
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	deep := fs.Bool("deep", false, "")
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
@@ -207,7 +295,22 @@ func (cmd *CheckCommand) Run(args ...string) error {
 	// Perform consistency check.
 	return db.View(func(tx *bolt.Tx) error {
 		var count int
-		for err := range tx.Check() {
+		if cmd.Format == "json" {
+			out := checkJSON{Errors: []checkErrorJSON{}}
+			for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: *deep}) {
+				out.Errors = append(out.Errors, newCheckErrorJSON(err))
+				count++
+			}
+			out.OK = count == 0
+			if err := writeJSON(cmd.Stdout, &out); err != nil {
+				return err
+			} else if count > 0 {
+				return ErrCorrupt
+			}
+			return nil
+		}
+
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: *deep}) {
 			fmt.Fprintln(cmd.Stdout, err)
 			count++
 		}
@@ -227,12 +330,19 @@ func (cmd *CheckCommand) Run(args ...string) error {
 // Usage returns the help message.
 func (cmd *CheckCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt check PATH
+usage: bolt check [options] PATH
 
 Check opens a database at PATH and runs an exhaustive check to verify that
 all pages are accessible or are marked as freed. It also verifies that no
 pages are double referenced.
 
+Additional options include:
+
+	-deep
+		Also verify the structure of every bucket tree: key ordering,
+		branch keys, page types, overflow bounds and nested bucket
+		headers.
+
 Verification errors will stream out as they are found and the process will
 return after all pages have been checked.
 `, "\n")
@@ -243,6 +353,9 @@ type InfoCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // NewInfoCommand returns a InfoCommand.
@@ -251,6 +364,7 @@ func newInfoCommand(m *Main) *InfoCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
@@ -283,6 +397,9 @@ func (cmd *InfoCommand) Run(args ...string) error {
 
 	// Print basic database info.
 	info := db.Info()
+	if cmd.Format == "json" {
+		return writeJSON(cmd.Stdout, &infoJSON{PageSize: info.PageSize})
+	}
 	fmt.Fprintf(cmd.Stdout, "Page Size: %d\n", info.PageSize)
 
 	return nil
@@ -454,7 +571,7 @@ func (cmd *PageItemCommand) Run(args ...string) error {
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	fs.BoolVar(&options.keyOnly, "key-only", false, "Print only the key")
 	fs.BoolVar(&options.valueOnly, "value-only", false, "Print only the value")
-	fs.StringVar(&options.format, "format", "ascii-encoded", "Output format. One of: ascii-encoded|hex|bytes")
+	fs.StringVar(&options.format, "format", "ascii-encoded", "Output format. One of: ascii-encoded|hex|base64|bytes")
 	fs.BoolVar(&options.help, "h", false, "")
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -527,7 +644,7 @@ func (cmd *PageItemCommand) leafPageElement(pageBytes []byte, index uint16) (*le
 	return p.leafPageElement(index), nil
 }
 
-// writeBytes writes the byte to the writer. Supported formats: ascii-encoded, hex, bytes.
+// writeBytes writes the byte to the writer. Supported formats: ascii-encoded, hex, base64, bytes.
 func (cmd *PageItemCommand) writeBytes(w io.Writer, b []byte, format string) error {
 	switch format {
 	case "ascii-encoded":
@@ -544,6 +661,9 @@ func (cmd *PageItemCommand) writeBytes(w io.Writer, b []byte, format string) err
 		}
 		_, err = fmt.Fprintf(w, "\n")
 		return err
+	case "base64":
+		_, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(b))
+		return err
 	case "bytes":
 		_, err := w.Write(b)
 		return err
@@ -558,7 +678,8 @@ func (cmd *PageItemCommand) PrintLeafItemKey(w io.Writer, pageBytes []byte, inde
 	if err != nil {
 		return err
 	}
-	return cmd.writeBytes(w, e.key(), format)
+	p := (*page)(unsafe.Pointer(&pageBytes[0]))
+	return cmd.writeBytes(w, p.expandKey(e.key()), format)
 }
 
 // PrintLeafItemKey writes the bytes of a leaf element's value.
@@ -582,7 +703,7 @@ Additional options include:
 	--value-only
 		Print only the value
 	--format
-		Output format. One of: ascii-encoded|hex|bytes (default=ascii-encoded)
+		Output format. One of: ascii-encoded|hex|base64|bytes (default=ascii-encoded)
 
 page-item prints a page item key and value.
 `, "\n")
@@ -593,6 +714,9 @@ type PageCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // newPageCommand returns a PageCommand.
@@ -601,6 +725,7 @@ func newPageCommand(m *Main) *PageCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
@@ -639,6 +764,19 @@ func (cmd *PageCommand) Run(args ...string) error {
 	}
 	defer func() { _ = f.Close() }()
 
+	// Print each page listed as a JSON array.
+	if cmd.Format == "json" {
+		pages := make([]*pageJSON, 0, len(pageIDs))
+		for _, pageID := range pageIDs {
+			_, buf, err := ReadPage(path, pageID)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, newPageJSON(buf))
+		}
+		return writeJSON(cmd.Stdout, pages)
+	}
+
 	// Print each page listed.
 	for i, pageID := range pageIDs {
 		// Print a separator.
@@ -695,8 +833,11 @@ func (cmd *PageCommand) PrintMeta(w io.Writer, buf []byte) error {
 func (cmd *PageCommand) PrintLeaf(w io.Writer, buf []byte) error {
 	p := (*page)(unsafe.Pointer(&buf[0]))
 
-	// Print number of items.
+	// Print number of items, and the key prefix of compressed pages.
 	fmt.Fprintf(w, "Item Count: %d\n", p.count)
+	if prefix := p.prefix(); len(prefix) > 0 {
+		fmt.Fprintf(w, "Key Prefix: %x\n", prefix)
+	}
 	fmt.Fprintf(w, "\n")
 
 	// Print each key/value.
@@ -705,15 +846,15 @@ func (cmd *PageCommand) PrintLeaf(w io.Writer, buf []byte) error {
 
 		// Format key as string.
 		var k string
-		if isPrintable(string(e.key())) {
-			k = fmt.Sprintf("%q", string(e.key()))
+		if key := p.expandKey(e.key()); isPrintable(string(key)) {
+			k = fmt.Sprintf("%q", string(key))
 		} else {
-			k = fmt.Sprintf("%x", string(e.key()))
+			k = fmt.Sprintf("%x", string(key))
 		}
 
 		// Format value as string.
 		var v string
-		if (e.flags & uint32(bucketLeafFlag)) != 0 {
+		if (e.flags&uint32(bucketLeafFlag)) != 0 && len(e.value()) >= int(unsafe.Sizeof(bucket{})) {
 			b := (*bucket)(unsafe.Pointer(&e.value()[0]))
 			v = fmt.Sprintf("<pgid=%d,seq=%d>", b.root, b.sequence)
 		} else if isPrintable(string(e.value())) {
@@ -732,8 +873,11 @@ func (cmd *PageCommand) PrintLeaf(w io.Writer, buf []byte) error {
 func (cmd *PageCommand) PrintBranch(w io.Writer, buf []byte) error {
 	p := (*page)(unsafe.Pointer(&buf[0]))
 
-	// Print number of items.
+	// Print number of items, and the key prefix of compressed pages.
 	fmt.Fprintf(w, "Item Count: %d\n", p.count)
+	if prefix := p.prefix(); len(prefix) > 0 {
+		fmt.Fprintf(w, "Key Prefix: %x\n", prefix)
+	}
 	fmt.Fprintf(w, "\n")
 
 	// Print each key/value.
@@ -742,10 +886,10 @@ func (cmd *PageCommand) PrintBranch(w io.Writer, buf []byte) error {
 
 		// Format key as string.
 		var k string
-		if isPrintable(string(e.key())) {
-			k = fmt.Sprintf("%q", string(e.key()))
+		if key := p.expandKey(e.key()); isPrintable(string(key)) {
+			k = fmt.Sprintf("%q", string(key))
 		} else {
-			k = fmt.Sprintf("%x", string(e.key()))
+			k = fmt.Sprintf("%x", string(key))
 		}
 
 		fmt.Fprintf(w, "%s: <pgid=%d>\n", k, e.pgid)
@@ -839,6 +983,9 @@ type PagesCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // NewPagesCommand returns a PagesCommand.
@@ -847,6 +994,7 @@ func newPagesCommand(m *Main) *PagesCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
@@ -878,11 +1026,14 @@ func (cmd *PagesCommand) Run(args ...string) error {
 	defer func() { _ = db.Close() }()
 
 	// Write header.
-	fmt.Fprintln(cmd.Stdout, "ID       TYPE       ITEMS  OVRFLW")
-	fmt.Fprintln(cmd.Stdout, "======== ========== ====== ======")
+	if cmd.Format != "json" {
+		fmt.Fprintln(cmd.Stdout, "ID       TYPE       ITEMS  OVRFLW")
+		fmt.Fprintln(cmd.Stdout, "======== ========== ====== ======")
+	}
 
 	return db.View(func(tx *bolt.Tx) error {
 		var id int
+		pages := []pageInfoJSON{}
 		for {
 			p, err := tx.Page(id)
 			if err != nil {
@@ -891,28 +1042,40 @@ func (cmd *PagesCommand) Run(args ...string) error {
 				break
 			}
 
-			// Only display count and overflow if this is a non-free page.
-			var count, overflow string
-			if p.Type != "free" {
-				count = strconv.Itoa(p.Count)
-				if p.OverflowCount > 0 {
-					overflow = strconv.Itoa(p.OverflowCount)
-				}
+			if cmd.Format == "json" {
+				pages = append(pages, pageInfoJSON{ID: p.ID, Type: p.Type, Count: p.Count, Overflow: p.OverflowCount})
+			} else {
+				cmd.printPageRow(p)
 			}
 
-			// Print table row.
-			fmt.Fprintf(cmd.Stdout, "%-8d %-10s %-6s %-6s\n", p.ID, p.Type, count, overflow)
-
 			// Move to the next non-overflow page.
 			id += 1
 			if p.Type != "free" {
 				id += p.OverflowCount
 			}
 		}
+		if cmd.Format == "json" {
+			return writeJSON(cmd.Stdout, pages)
+		}
 		return nil
 	})
 }
 
+// printPageRow prints a row of the pages table.
+func (cmd *PagesCommand) printPageRow(p *bolt.PageInfo) {
+	// Only display count and overflow if this is a non-free page.
+	var count, overflow string
+	if p.Type != "free" {
+		count = strconv.Itoa(p.Count)
+		if p.OverflowCount > 0 {
+			overflow = strconv.Itoa(p.OverflowCount)
+		}
+	}
+
+	// Print table row.
+	fmt.Fprintf(cmd.Stdout, "%-8d %-10s %-6s %-6s\n", p.ID, p.Type, count, overflow)
+}
+
 // Usage returns the help message.
 func (cmd *PagesCommand) Usage() string {
 	return strings.TrimLeft(`
@@ -933,6 +1096,9 @@ type StatsCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // NewStatsCommand returns a StatsCommand.
@@ -941,6 +1107,7 @@ func newStatsCommand(m *Main) *StatsCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
@@ -949,11 +1116,16 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	// Parse flags.
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	buckets := fs.Bool("buckets", false, "")
+	sortBy := fs.String("sort", "name", "")
+	top := fs.Int("top", 0, "")
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
 		fmt.Fprintln(cmd.Stderr, cmd.Usage())
 		return ErrUsage
+	} else if *sortBy != "name" && *sortBy != "size" {
+		return fmt.Errorf("unsupported sort order: %s", *sortBy)
 	}
 
 	// Require database path.
@@ -965,13 +1137,17 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	}
 
 	// Open database.
-	db, err := bolt.Open(path, 0666, nil)
+	db, err := bolt.Open(path, 0666, &bolt.Options{RawKeyOrder: true})
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
 	return db.View(func(tx *bolt.Tx) error {
+		if *buckets || *top > 0 {
+			return cmd.printBucketReports(tx, []byte(prefix), *sortBy == "size", *top)
+		}
+
 		var s bolt.BucketStats
 		var count int
 		if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
@@ -984,6 +1160,10 @@ func (cmd *StatsCommand) Run(args ...string) error {
 			return err
 		}
 
+		if cmd.Format == "json" {
+			return writeJSON(cmd.Stdout, &statsJSON{BucketCount: count, Stats: s})
+		}
+
 		fmt.Fprintf(cmd.Stdout, "Aggregate statistics for %d buckets\n\n", count)
 
 		fmt.Fprintln(cmd.Stdout, "Page count statistics")
@@ -1009,6 +1189,10 @@ func (cmd *StatsCommand) Run(args ...string) error {
 			percentage = int(float32(s.LeafInuse) * 100.0 / float32(s.LeafAlloc))
 		}
 		fmt.Fprintf(cmd.Stdout, "\tBytes actually used for leaf data: %d (%d%%)\n", s.LeafInuse, percentage)
+		if s.BranchPrefixSaved != 0 || s.LeafPrefixSaved != 0 {
+			fmt.Fprintf(cmd.Stdout, "\tBytes saved by prefix compression on branch pages: %d\n", s.BranchPrefixSaved)
+			fmt.Fprintf(cmd.Stdout, "\tBytes saved by prefix compression on leaf pages: %d\n", s.LeafPrefixSaved)
+		}
 
 		fmt.Fprintln(cmd.Stdout, "Bucket statistics")
 		fmt.Fprintf(cmd.Stdout, "\tTotal number of buckets: %d\n", s.BucketN)
@@ -1030,11 +1214,32 @@ func (cmd *StatsCommand) Run(args ...string) error {
 // Usage returns the help message.
 func (cmd *StatsCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt stats PATH
+usage: bolt stats [options] PATH [PREFIX]
 
 Stats performs an extensive search of the database to track every page
 reference. It starts at the current meta page and recursively iterates
-through every accessible bucket.
+through every accessible bucket. Only top-level buckets whose name starts
+with PREFIX are included.
+
+By default the statistics of all buckets are added up. The -buckets and -top
+options print a report per bucket instead, with the usage of the bucket's own
+pages and its total usage including all nested buckets: the number of pages,
+the bytes allocated and in use, the number of keys (nested buckets included),
+the depth of the B+tree and whether the bucket is inlined. The bytes in use of
+an inline bucket are part of the leaf page of its parent.
+
+Additional options include:
+
+	-buckets
+		Print the report of every bucket as a tree, nested buckets
+		being indented below their parent.
+	-sort ORDER
+		Order of the buckets sharing a parent. One of: name|size
+		(default=name). The size order lists the buckets with the
+		largest total allocation first.
+	-top N
+		Print only the N buckets with the largest own allocation,
+		largest first, with their full path.
 
 The following errors can be reported:
 
@@ -1063,11 +1268,178 @@ experience corruption, please submit a ticket to the Bolt project page:
 `, "\n")
 }
 
+// bucketReport holds the stats of a bucket and of its nested buckets.
+type bucketReport struct {
+	path     [][]byte
+	own      bolt.BucketStats
+	total    bolt.BucketStats
+	children []*bucketReport
+}
+
+// newBucketReport returns the report of bucket b at path.
+func newBucketReport(b *bolt.Bucket, path [][]byte) (*bucketReport, error) {
+	r := &bucketReport{path: path, own: b.OwnStats()}
+	r.total = r.own
+
+	// Collect nested buckets. The total depth adds the depth of the deepest
+	// nested bucket, like Bucket.Stats does.
+	var depth int
+	if err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+
+		// Values set by PutReader are returned as nil, like buckets.
+		nested := b.Bucket(k)
+		if nested == nil {
+			return nil
+		}
+		child, err := newBucketReport(nested, append(path[:len(path):len(path)], k))
+		if err != nil {
+			return err
+		}
+		r.children = append(r.children, child)
+		r.total.Add(child.total)
+		if child.total.Depth > depth {
+			depth = child.total.Depth
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	r.total.Depth = r.own.Depth + depth
+	return r, nil
+}
+
+// walk calls fn for r and its nested buckets, parents first.
+func (r *bucketReport) walk(fn func(*bucketReport)) {
+	fn(r)
+	for _, child := range r.children {
+		child.walk(fn)
+	}
+}
+
+// inline returns true if the bucket is inlined in the leaf page of its parent.
+func (r *bucketReport) inline() bool {
+	return r.own.InlineBucketN > 0
+}
+
+// sortBySize sorts the nested buckets by decreasing total allocation.
+func (r *bucketReport) sortBySize() {
+	sort.SliceStable(r.children, func(i, j int) bool {
+		return statsAlloc(r.children[i].total) > statsAlloc(r.children[j].total)
+	})
+	for _, child := range r.children {
+		child.sortBySize()
+	}
+}
+
+// statsPages returns the number of pages, overflow pages included.
+func statsPages(s bolt.BucketStats) int {
+	return s.BranchPageN + s.BranchOverflowN + s.LeafPageN + s.LeafOverflowN
+}
+
+// statsAlloc returns the number of bytes allocated for pages.
+func statsAlloc(s bolt.BucketStats) int {
+	return s.BranchAlloc + s.LeafAlloc
+}
+
+// statsInuse returns the number of bytes in use, which are the bytes of the
+// inline page for an inline bucket.
+func statsInuse(s bolt.BucketStats) int {
+	if statsPages(s) == 0 {
+		return s.InlineBucketInuse
+	}
+	return s.BranchInuse + s.LeafInuse
+}
+
+// bucketReportJSON is printed for each bucket by the stats command.
+type bucketReportJSON struct {
+	Bucket [][]byte         `json:"bucket"`
+	Inline bool             `json:"inline"`
+	Own    bolt.BucketStats `json:"own"`
+	Total  bolt.BucketStats `json:"total"`
+}
+
+// printBucketReports prints the report of each top-level bucket starting
+// with prefix and of their nested buckets. If top is positive, only the top
+// buckets with the largest own allocation are printed.
+func (cmd *StatsCommand) printBucketReports(tx *bolt.Tx, prefix []byte, bySize bool, top int) error {
+	var reports []*bucketReport
+	if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if !bytes.HasPrefix(name, prefix) {
+			return nil
+		}
+		r, err := newBucketReport(b, [][]byte{name})
+		if err != nil {
+			return err
+		}
+		if bySize {
+			r.sortBySize()
+		}
+		reports = append(reports, r)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if bySize {
+		sort.SliceStable(reports, func(i, j int) bool {
+			return statsAlloc(reports[i].total) > statsAlloc(reports[j].total)
+		})
+	}
+
+	// Flatten the reports, parents first.
+	var all []*bucketReport
+	for _, r := range reports {
+		r.walk(func(r *bucketReport) { all = append(all, r) })
+	}
+	if top > 0 {
+		sort.SliceStable(all, func(i, j int) bool {
+			if a, b := statsAlloc(all[i].own), statsAlloc(all[j].own); a != b {
+				return a > b
+			}
+			return statsInuse(all[i].own) > statsInuse(all[j].own)
+		})
+		if len(all) > top {
+			all = all[:top]
+		}
+	}
+
+	if cmd.Format == "json" {
+		out := make([]bucketReportJSON, 0, len(all))
+		for _, r := range all {
+			out = append(out, bucketReportJSON{Bucket: r.path, Inline: r.inline(), Own: r.own, Total: r.total})
+		}
+		return writeJSON(cmd.Stdout, out)
+	}
+
+	w := tabwriter.NewWriter(cmd.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BUCKET\tPAGES\tALLOC\tINUSE\tKEYS\tTOTAL PAGES\tTOTAL ALLOC\tTOTAL INUSE\tTOTAL KEYS\tDEPTH\tINLINE")
+	for _, r := range all {
+		name := formatBucketPath(r.path)
+		if top <= 0 {
+			name = strings.Repeat("  ", len(r.path)-1) + fmt.Sprintf("%q", r.path[len(r.path)-1])
+		}
+		var inline string
+		if r.inline() {
+			inline = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", name,
+			statsPages(r.own), statsAlloc(r.own), statsInuse(r.own), r.own.KeyN,
+			statsPages(r.total), statsAlloc(r.total), statsInuse(r.total), r.total.KeyN,
+			r.total.Depth, inline)
+	}
+	return w.Flush()
+}
+
 // BucketsCommand represents the "buckets" command execution.
 type BucketsCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // NewBucketsCommand returns a BucketsCommand.
@@ -1076,6 +1448,7 @@ func newBucketsCommand(m *Main) *BucketsCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
@@ -1100,7 +1473,7 @@ func (cmd *BucketsCommand) Run(args ...string) error {
 	}
 
 	// Open database.
-	db, err := bolt.Open(path, 0666, nil)
+	db, err := bolt.Open(path, 0666, &bolt.Options{RawKeyOrder: true})
 	if err != nil {
 		return err
 	}
@@ -1108,6 +1481,16 @@ func (cmd *BucketsCommand) Run(args ...string) error {
 
 	// Print buckets.
 	return db.View(func(tx *bolt.Tx) error {
+		if cmd.Format == "json" {
+			names := [][]byte{}
+			if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				names = append(names, name)
+				return nil
+			}); err != nil {
+				return err
+			}
+			return writeJSON(cmd.Stdout, names)
+		}
 		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
 			fmt.Fprintln(cmd.Stdout, string(name))
 			return nil
@@ -1129,6 +1512,9 @@ type KeysCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
 }
 
 // NewKeysCommand returns a KeysCommand.
@@ -1137,14 +1523,24 @@ func newKeysCommand(m *Main) *KeysCommand {
 		Stdin:  m.Stdin,
 		Stdout: m.Stdout,
 		Stderr: m.Stderr,
+		Format: m.Format,
 	}
 }
 
 // Run executes the command.
 func (cmd *KeysCommand) Run(args ...string) error {
 	// Parse flags.
+	var r keyRange
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	prefix := fs.String("prefix", "", "")
+	start := fs.String("start", "", "")
+	end := fs.String("end", "", "")
+	keyFormat := fs.String("key-format", "bytes", "")
+	format := fs.String("format", "bytes", "")
+	count := fs.Bool("count", false, "")
+	fs.IntVar(&r.limit, "limit", 0, "")
+	fs.BoolVar(&r.reverse, "reverse", false, "")
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
@@ -1153,18 +1549,34 @@ func (cmd *KeysCommand) Run(args ...string) error {
 	}
 
 	// Require database path and bucket.
-	relevantArgs := fs.Args()
-	path, buckets := relevantArgs[0], relevantArgs[1:]
+	path, buckets := fs.Arg(0), fs.Args()
 	if path == "" {
 		return ErrPathRequired
 	} else if _, err := os.Stat(path); os.IsNotExist(err) {
 		return ErrFileNotFound
-	} else if len(buckets) == 0 {
+	} else if buckets = buckets[1:]; len(buckets) == 0 {
 		return ErrBucketRequired
 	}
 
+	// Decode range bounds.
+	for _, bound := range []struct {
+		s string
+		b *[]byte
+	}{{*prefix, &r.prefix}, {*start, &r.start}, {*end, &r.end}} {
+		if bound.s == "" {
+			continue
+		}
+		var err error
+		if *bound.b, err = parseBytes(bound.s, *keyFormat); err != nil {
+			return err
+		}
+	}
+	if _, err := formatBytes(nil, *format); err != nil {
+		return err
+	}
+
 	// Open database.
-	db, err := bolt.Open(path, 0666, nil)
+	db, err := bolt.Open(path, 0666, &bolt.Options{RawKeyOrder: true})
 	if err != nil {
 		return err
 	}
@@ -1173,21 +1585,45 @@ func (cmd *KeysCommand) Run(args ...string) error {
 	// Print keys.
 	return db.View(func(tx *bolt.Tx) error {
 		// Find bucket.
-		var lastbucket *bolt.Bucket = tx.Bucket([]byte(buckets[0]))
-		if lastbucket == nil {
-			return ErrBucketNotFound
+		b, err := findBucket(tx, buckets, false)
+		if err != nil {
+			return err
 		}
-		for _, bucket := range buckets[1:] {
-			lastbucket = lastbucket.Bucket([]byte(bucket))
-			if lastbucket == nil {
-				return ErrBucketNotFound
+
+		// Only count keys in range.
+		if *count {
+			var n int
+			if err := r.forEach(b, func(_, _ []byte) error {
+				n++
+				return nil
+			}); err != nil {
+				return err
+			}
+			if cmd.Format == "json" {
+				return writeJSON(cmd.Stdout, map[string]int{"count": n})
 			}
+			_, err := fmt.Fprintln(cmd.Stdout, n)
+			return err
 		}
 
-		// Iterate over each key.
-		return lastbucket.ForEach(func(key, _ []byte) error {
-			fmt.Fprintln(cmd.Stdout, string(key))
-			return nil
+		// Iterate over each key in range.
+		if cmd.Format == "json" {
+			keys := [][]byte{}
+			if err := r.forEach(b, func(key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}); err != nil {
+				return err
+			}
+			return writeJSON(cmd.Stdout, keys)
+		}
+		return r.forEach(b, func(key, _ []byte) error {
+			s, err := formatBytes(key, *format)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.Stdout, s)
+			return err
 		})
 	})
 }
@@ -1195,12 +1631,108 @@ func (cmd *KeysCommand) Run(args ...string) error {
 // Usage returns the help message.
 func (cmd *KeysCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt keys PATH [BUCKET...]
+usage: bolt keys [options] PATH [BUCKET...]
+
+Print a list of keys in the given (sub)bucket, in key order. The keys of
+nested buckets are listed too.
 
-Print a list of keys in the given (sub)bucket.
+Additional options include:
+
+	-prefix PREFIX
+		Only list the keys starting with PREFIX.
+	-start START
+		Only list the keys greater than or equal to START.
+	-end END
+		Only list the keys less than END.
+	-key-format FORMAT
+		Input format of PREFIX, START and END. One of:
+		bytes|ascii-encoded|hex|base64 (default=bytes)
+	-limit N
+		Stop after N keys.
+	-reverse
+		List the keys in reverse order, starting with the greatest
+		key in range.
+	-format FORMAT
+		Output format of the keys. One of: bytes|ascii-encoded|hex|base64
+		(default=bytes)
+	-count
+		Print the number of keys in range instead of the keys.
 `, "\n")
 }
 
+// keyRange selects the keys of a bucket. Start is inclusive and end is
+// exclusive; nil bounds are ignored.
+type keyRange struct {
+	prefix  []byte
+	start   []byte
+	end     []byte
+	limit   int
+	reverse bool
+}
+
+// forEach calls fn for up to r.limit keys of b in range, in key order or in
+// reverse key order. Values of nested buckets are nil.
+func (r *keyRange) forEach(b *bolt.Bucket, fn func(k, v []byte) error) error {
+	// Narrow the bounds to the prefix.
+	lower, upper := r.start, r.end
+	if r.prefix != nil {
+		if lower == nil || bytes.Compare(r.prefix, lower) > 0 {
+			lower = r.prefix
+		}
+		if next := prefixEnd(r.prefix); next != nil && (upper == nil || bytes.Compare(next, upper) < 0) {
+			upper = next
+		}
+	}
+	inRange := func(k []byte) bool {
+		return k != nil &&
+			(lower == nil || bytes.Compare(k, lower) >= 0) &&
+			(upper == nil || bytes.Compare(k, upper) < 0)
+	}
+
+	c := b.Cursor()
+	var k, v []byte
+	switch {
+	case !r.reverse && lower != nil:
+		k, v = c.Seek(lower)
+	case !r.reverse:
+		k, v = c.First()
+	case upper != nil:
+		// Step back from the first key after the range.
+		if k, v = c.Seek(upper); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	default:
+		k, v = c.Last()
+	}
+
+	for n := 0; inRange(k) && (r.limit <= 0 || n < r.limit); n++ {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+		if r.reverse {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Next()
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest key greater than all keys starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := cloneBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
 // GetCommand represents the "get" command execution.
 type GetCommand struct {
 	Stdin  io.Reader
@@ -1222,6 +1754,8 @@ func (cmd *GetCommand) Run(args ...string) error {
 	// Parse flags.
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	keyFormat := fs.String("key-format", "bytes", "")
+	format := fs.String("format", "bytes", "")
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
@@ -1230,20 +1764,23 @@ func (cmd *GetCommand) Run(args ...string) error {
 	}
 
 	// Require database path, bucket and key.
-	relevantArgs := fs.Args()
-	path, buckets, key := relevantArgs[0], relevantArgs[1:len(relevantArgs)-1], relevantArgs[len(relevantArgs)-1]
+	path, buckets := fs.Arg(0), fs.Args()
 	if path == "" {
 		return ErrPathRequired
 	} else if _, err := os.Stat(path); os.IsNotExist(err) {
 		return ErrFileNotFound
+	}
+	key, buckets, err := readKey(buckets[1:], "", *keyFormat, cmd.Stdin)
+	if err != nil {
+		return err
 	} else if len(buckets) == 0 {
 		return ErrBucketRequired
-	} else if key == "" {
-		return ErrKeyRequired
+	} else if _, err := formatBytes(nil, *format); err != nil {
+		return err
 	}
 
 	// Open database.
-	db, err := bolt.Open(path, 0666, nil)
+	db, err := bolt.Open(path, 0666, &bolt.Options{RawKeyOrder: true})
 	if err != nil {
 		return err
 	}
@@ -1252,24 +1789,26 @@ func (cmd *GetCommand) Run(args ...string) error {
 	// Print value.
 	return db.View(func(tx *bolt.Tx) error {
 		// Find bucket.
-		var lastbucket *bolt.Bucket = tx.Bucket([]byte(buckets[0]))
-		if lastbucket == nil {
-			return ErrBucketNotFound
-		}
-		for _, bucket := range buckets[1:] {
-			lastbucket = lastbucket.Bucket([]byte(bucket))
-			if lastbucket == nil {
-				return ErrBucketNotFound
-			}
+		b, err := findBucket(tx, buckets, false)
+		if err != nil {
+			return err
 		}
 
-		// Find value for given key.
-		val := lastbucket.Get([]byte(key))
-		if val == nil {
+		// Find value for given key, whether it was set by Put or PutReader.
+		r := b.GetReader(key)
+		if r == nil {
 			return ErrKeyNotFound
 		}
+		val, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
 
-		fmt.Fprintln(cmd.Stdout, string(val))
+		s, err := formatBytes(val, *format)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.Stdout, s)
 		return nil
 	})
 }
@@ -1277,9 +1816,18 @@ func (cmd *GetCommand) Run(args ...string) error {
 // Usage returns the help message.
 func (cmd *GetCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt get PATH [BUCKET..] KEY
+usage: bolt get [options] PATH [BUCKET..] KEY
 
 Print the value of the given key in the given (sub)bucket.
+
+Additional options include:
+
+	-key-format FORMAT
+		Input format of KEY. One of: bytes|ascii-encoded|hex|base64
+		(default=bytes)
+	-format FORMAT
+		Output format of the value. One of: bytes|ascii-encoded|hex|base64
+		(default=bytes)
 `, "\n")
 }
 
@@ -1309,8 +1857,11 @@ func (cmd *BenchCommand) Run(args ...string) error {
 		return err
 	}
 
-	// Remove path if "-work" is not set. Otherwise keep path.
-	if options.Work {
+	// Remove path if "-work" is not set. Otherwise keep path. Never
+	// remove an existing database.
+	if options.Existing {
+		fmt.Fprintf(cmd.Stdout, "existing: %s\n", options.Path)
+	} else if options.Work {
 		fmt.Fprintf(cmd.Stdout, "work: %s\n", options.Path)
 	} else {
 		defer os.Remove(options.Path)
@@ -1330,6 +1881,19 @@ func (cmd *BenchCommand) Run(args ...string) error {
 		return fmt.Errorf("write: %v", err)
 	}
 
+	// Run concurrent readers and writers instead of reads if a duration
+	// is set.
+	if options.Duration > 0 {
+		fmt.Fprintf(os.Stderr, "# Write\t%v\t(%v/op)\t(%v op/sec)\n", results.WriteDuration, results.WriteOpDuration(), results.WriteOpsPerSecond())
+		results = BenchResults{}
+		if err := cmd.runMixed(db, options, &results); err != nil {
+			return fmt.Errorf("bench: mixed: %s", err)
+		}
+		printMixedResults(os.Stderr, options, &results)
+		fmt.Fprintln(os.Stderr, "")
+		return nil
+	}
+
 	// Read from the database.
 	if err := cmd.runReads(db, options, &results); err != nil {
 		return fmt.Errorf("bench: read: %s", err)
@@ -1362,10 +1926,37 @@ func (cmd *BenchCommand) ParseFlags(args []string) (*BenchOptions, error) {
 	fs.BoolVar(&options.NoSync, "no-sync", false, "")
 	fs.BoolVar(&options.Work, "work", false, "")
 	fs.StringVar(&options.Path, "path", "", "")
+	fs.DurationVar(&options.Duration, "duration", 0, "")
+	fs.IntVar(&options.Readers, "readers", 1, "")
+	fs.IntVar(&options.Writers, "writers", 1, "")
+	fs.BoolVar(&options.WriteBatch, "write-batch", false, "")
+	fs.Float64Var(&options.ReadRatio, "read-ratio", 0, "")
+	fs.StringVar(&options.KeyDistribution, "key-dist", "", "")
+	fs.IntVar(&options.KeySpace, "key-space", 0, "")
+	fs.Float64Var(&options.ZipfS, "zipf-s", 1.1, "")
+	fs.Float64Var(&options.HotspotKeys, "hotspot-keys", 0.2, "")
+	fs.Float64Var(&options.HotspotOps, "hotspot-ops", 0.8, "")
+	fs.IntVar(&options.KeySizeMax, "key-size-max", 0, "")
+	fs.StringVar(&options.ValueDistribution, "value-dist", "fixed", "")
+	fs.IntVar(&options.ValueSizeMax, "value-size-max", 0, "")
+	fs.Float64Var(&options.ValueSigma, "value-sigma", 1, "")
+	fs.StringVar(&options.WriteOp, "write-op", "insert", "")
+	fs.IntVar(&options.ScanLength, "scan-length", 100, "")
+	fs.IntVar(&options.PrefixLength, "prefix-length", 4, "")
+	fs.StringVar(&options.BucketName, "bucket", string(benchBucketName), "")
+	fs.BoolVar(&options.Existing, "existing", false, "")
 	fs.SetOutput(cmd.Stderr)
 	if err := fs.Parse(args); err != nil {
 		return nil, err
 	}
+	if err := validateBenchOptions(&options); err != nil {
+		return nil, err
+	}
+	if options.Readers < 0 || options.Writers < 0 {
+		return nil, fmt.Errorf("readers and writers must not be negative")
+	} else if options.ReadRatio < 0 || options.ReadRatio >= 1 {
+		return nil, fmt.Errorf("read ratio must be in [0, 1)")
+	}
 
 	// Set batch size to iteration size if not set.
 	// Require that batch size can be evenly divided by the iteration count.
@@ -1375,8 +1966,15 @@ func (cmd *BenchCommand) ParseFlags(args []string) (*BenchOptions, error) {
 		return nil, ErrNonDivisibleBatchSize
 	}
 
-	// Generate temp path if one is not passed in.
-	if options.Path == "" {
+	// Require the path of an existing database. Otherwise generate temp
+	// path if one is not passed in.
+	if options.Existing {
+		if options.Path == "" {
+			return nil, ErrPathRequired
+		} else if _, err := os.Stat(options.Path); os.IsNotExist(err) {
+			return nil, ErrFileNotFound
+		}
+	} else if options.Path == "" {
 		f, err := os.CreateTemp("", "bolt-bench-")
 		if err != nil {
 			return nil, fmt.Errorf("temp file: %s", err)
@@ -1399,15 +1997,14 @@ func (cmd *BenchCommand) runWrites(db *bolt.DB, options *BenchOptions, results *
 	t := time.Now()
 
 	var err error
+	g := newBenchGenerator(options, 0)
+	var last uint32
+	keySource := func() uint32 { return g.number(&last) }
 	switch options.WriteMode {
-	case "seq":
-		err = cmd.runWritesSequential(db, options, results)
-	case "rnd":
-		err = cmd.runWritesRandom(db, options, results)
-	case "seq-nest":
-		err = cmd.runWritesSequentialNested(db, options, results)
-	case "rnd-nest":
-		err = cmd.runWritesRandomNested(db, options, results)
+	case "seq", "rnd":
+		err = cmd.runWritesWithSource(db, options, results, g, keySource)
+	case "seq-nest", "rnd-nest":
+		err = cmd.runWritesNestedWithSource(db, options, results, g, keySource)
 	default:
 		return fmt.Errorf("invalid write mode: %s", options.WriteMode)
 	}
@@ -1423,43 +2020,35 @@ func (cmd *BenchCommand) runWrites(db *bolt.DB, options *BenchOptions, results *
 	return err
 }
 
-func (cmd *BenchCommand) runWritesSequential(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
-	var i = uint32(0)
-	return cmd.runWritesWithSource(db, options, results, func() uint32 { i++; return i })
-}
-
-func (cmd *BenchCommand) runWritesRandom(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return cmd.runWritesWithSource(db, options, results, func() uint32 { return r.Uint32() })
-}
-
-func (cmd *BenchCommand) runWritesSequentialNested(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
-	var i = uint32(0)
-	return cmd.runWritesNestedWithSource(db, options, results, func() uint32 { i++; return i })
-}
-
-func (cmd *BenchCommand) runWritesRandomNested(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return cmd.runWritesNestedWithSource(db, options, results, func() uint32 { return r.Uint32() })
-}
-
-func (cmd *BenchCommand) runWritesWithSource(db *bolt.DB, options *BenchOptions, results *BenchResults, keySource func() uint32) error {
+// runWritesWithSource inserts the keys numbered by keySource or, for the
+// update and delete write ops, overwrites or deletes existing keys.
+func (cmd *BenchCommand) runWritesWithSource(db *bolt.DB, options *BenchOptions, results *BenchResults, g *benchGenerator, keySource func() uint32) error {
 	results.WriteOps = options.Iterations
 
+	if options.WriteOp != "insert" && options.Iterations > 0 {
+		if err := g.loadKeys(db); err != nil {
+			return err
+		}
+	}
+
 	for i := 0; i < options.Iterations; i += options.BatchSize {
 		if err := db.Update(func(tx *bolt.Tx) error {
-			b, _ := tx.CreateBucketIfNotExists(benchBucketName)
+			b, err := tx.CreateBucketIfNotExists([]byte(options.BucketName))
+			if err != nil {
+				return err
+			}
 			b.FillPercent = options.FillPercent
 
 			for j := 0; j < options.BatchSize; j++ {
-				key := make([]byte, options.KeySize)
-				value := make([]byte, options.ValueSize)
-
-				// Write key as uint32.
-				binary.BigEndian.PutUint32(key, keySource())
-
-				// Insert key/value.
-				if err := b.Put(key, value); err != nil {
+				switch options.WriteOp {
+				case "update":
+					err = b.Put(g.existingKey(), g.value())
+				case "delete":
+					err = b.Delete(g.existingKey())
+				default:
+					err = b.Put(g.key(keySource()), g.value())
+				}
+				if err != nil {
 					return err
 				}
 			}
@@ -1472,20 +2061,19 @@ func (cmd *BenchCommand) runWritesWithSource(db *bolt.DB, options *BenchOptions,
 	return nil
 }
 
-func (cmd *BenchCommand) runWritesNestedWithSource(db *bolt.DB, options *BenchOptions, results *BenchResults, keySource func() uint32) error {
+func (cmd *BenchCommand) runWritesNestedWithSource(db *bolt.DB, options *BenchOptions, results *BenchResults, g *benchGenerator, keySource func() uint32) error {
 	results.WriteOps = options.Iterations
 
 	for i := 0; i < options.Iterations; i += options.BatchSize {
 		if err := db.Update(func(tx *bolt.Tx) error {
-			top, err := tx.CreateBucketIfNotExists(benchBucketName)
+			top, err := tx.CreateBucketIfNotExists([]byte(options.BucketName))
 			if err != nil {
 				return err
 			}
 			top.FillPercent = options.FillPercent
 
 			// Create bucket key.
-			name := make([]byte, options.KeySize)
-			binary.BigEndian.PutUint32(name, keySource())
+			name := g.key(keySource())
 
 			// Create bucket.
 			b, err := top.CreateBucketIfNotExists(name)
@@ -1495,14 +2083,8 @@ func (cmd *BenchCommand) runWritesNestedWithSource(db *bolt.DB, options *BenchOp
 			b.FillPercent = options.FillPercent
 
 			for j := 0; j < options.BatchSize; j++ {
-				var key = make([]byte, options.KeySize)
-				var value = make([]byte, options.ValueSize)
-
-				// Generate key as uint32.
-				binary.BigEndian.PutUint32(key, keySource())
-
 				// Insert value into subbucket.
-				if err := b.Put(key, value); err != nil {
+				if err := b.Put(g.key(keySource()), g.value()); err != nil {
 					return err
 				}
 			}
@@ -1533,6 +2115,8 @@ func (cmd *BenchCommand) runReads(db *bolt.DB, options *BenchOptions, results *B
 		default:
 			err = cmd.runReadsSequential(db, options, results)
 		}
+	case "get", "range", "prefix":
+		err = cmd.runReadsKeys(db, options, results)
 	default:
 		return fmt.Errorf("invalid read mode: %s", options.ReadMode)
 	}
@@ -1555,7 +2139,11 @@ func (cmd *BenchCommand) runReadsSequential(db *bolt.DB, options *BenchOptions,
 		for {
 			var count int
 
-			c := tx.Bucket(benchBucketName).Cursor()
+			b := tx.Bucket([]byte(options.BucketName))
+			if b == nil {
+				return ErrBucketNotFound
+			}
+			c := b.Cursor()
 			for k, v := c.First(); k != nil; k, v = c.Next() {
 				if v == nil {
 					return errors.New("invalid value")
@@ -1563,7 +2151,7 @@ func (cmd *BenchCommand) runReadsSequential(db *bolt.DB, options *BenchOptions,
 				count++
 			}
 
-			if options.WriteMode == "seq" && count != options.Iterations {
+			if options.WriteMode == "seq" && options.uniqueInserts() && count != options.Iterations {
 				return fmt.Errorf("read seq: iter mismatch: expected %d, got %d", options.Iterations, count)
 			}
 
@@ -1585,7 +2173,10 @@ func (cmd *BenchCommand) runReadsSequentialNested(db *bolt.DB, options *BenchOpt
 
 		for {
 			var count int
-			var top = tx.Bucket(benchBucketName)
+			var top = tx.Bucket([]byte(options.BucketName))
+			if top == nil {
+				return ErrBucketNotFound
+			}
 			if err := top.ForEach(func(name, _ []byte) error {
 				if b := top.Bucket(name); b != nil {
 					c := b.Cursor()
@@ -1601,7 +2192,7 @@ func (cmd *BenchCommand) runReadsSequentialNested(db *bolt.DB, options *BenchOpt
 				return err
 			}
 
-			if options.WriteMode == "seq-nest" && count != options.Iterations {
+			if options.WriteMode == "seq-nest" && options.uniqueInserts() && count != options.Iterations {
 				return fmt.Errorf("read seq-nest: iter mismatch: expected %d, got %d", options.Iterations, count)
 			}
 
@@ -1694,6 +2285,57 @@ type BenchOptions struct {
 	NoSync        bool
 	Work          bool
 	Path          string
+
+	// Duration enables the mixed workload: Readers and Writers goroutines
+	// run concurrently for Duration after the writes. Writers commit with
+	// DB.Batch if WriteBatch is set. If ReadRatio is positive, writers
+	// pause to keep that fraction of operations for reads.
+	Duration   time.Duration
+	Readers    int
+	Writers    int
+	WriteBatch bool
+	ReadRatio  float64
+
+	// KeyDistribution draws the numbers of inserted keys from KeySpace
+	// keys, or Iterations if not set, instead of following WriteMode. It
+	// also picks the keys of updates, deletes and reads among the first
+	// KeySpace existing keys, or all of them if not set. One of: uniform,
+	// zipf (with exponent ZipfS) or hotspot (HotspotOps of the operations
+	// on HotspotKeys of the keys). Both zipf and hotspot favor the lowest
+	// keys. Inserted keys are between KeySize and KeySizeMax bytes long.
+	KeyDistribution string
+	KeySpace        int
+	ZipfS           float64
+	HotspotKeys     float64
+	HotspotOps      float64
+	KeySizeMax      int
+
+	// ValueDistribution draws value sizes. One of: fixed (ValueSize),
+	// uniform (between ValueSize and ValueSizeMax) or lognormal (median
+	// ValueSize, shape ValueSigma, capped at ValueSizeMax if set).
+	ValueDistribution string
+	ValueSizeMax      int
+	ValueSigma        float64
+
+	// WriteOp is the operation of the writes. One of: insert, update or
+	// delete. Updates and deletes apply to the existing keys.
+	WriteOp string
+
+	// ScanLength is the number of keys read by each range read and
+	// PrefixLength the length of the prefix of each prefix read.
+	ScanLength   int
+	PrefixLength int
+
+	// BucketName is the bucket to benchmark. Existing runs the benchmark
+	// against the existing database at Path, which is never removed.
+	BucketName string
+	Existing   bool
+}
+
+// uniqueInserts returns true if every write inserts a new key into an empty
+// database.
+func (o *BenchOptions) uniqueInserts() bool {
+	return !o.Existing && o.WriteOp == "insert" && o.KeyDistribution == ""
 }
 
 // BenchResults represents the performance results of the benchmark.
@@ -1702,6 +2344,12 @@ type BenchResults struct {
 	WriteDuration time.Duration
 	ReadOps       int
 	ReadDuration  time.Duration
+
+	// Latencies of reads and commits, and per-second throughput of the
+	// mixed workload.
+	ReadLatencies   LatencyHistogram
+	CommitLatencies LatencyHistogram
+	Series          []BenchSample
 }
 
 // Returns the duration for a single write operation.
@@ -1843,10 +2491,25 @@ const (
 	leafPageFlag     = 0x02
 	metaPageFlag     = 0x04
 	freelistPageFlag = 0x10
+	blobPageFlag     = 0x40
+	prefixPageFlag   = 0x80
+)
+
+// DO NOT EDIT. Copied from the "bolt" package.
+const (
+	bucketLeafFlag    = 0x01
+	blobLeafFlag      = 0x02
+	bucketExtLeafFlag = 0x04
+)
+
+// DO NOT EDIT. Copied from the "bolt" package.
+const (
+	magic   uint32 = 0xED0CDAED
+	version        = 2
 )
 
 // DO NOT EDIT. Copied from the "bolt" package.
-const bucketLeafFlag = 0x01
+const pgidNoFreelist pgid = 0xffffffffffffffff
 
 // DO NOT EDIT. Copied from the "bolt" package.
 type pgid uint64
@@ -1867,6 +2530,13 @@ type meta struct {
 	checksum uint64
 }
 
+// DO NOT EDIT. Copied from the "bolt" package.
+func (m *meta) sum64() uint64 {
+	var h = fnv.New64a()
+	_, _ = h.Write((*[unsafe.Offsetof(meta{}.checksum)]byte)(unsafe.Pointer(m))[:])
+	return h.Sum64()
+}
+
 // DO NOT EDIT. Copied from the "bolt" package.
 type bucket struct {
 	root     pgid
@@ -1892,6 +2562,8 @@ func (p *page) Type() string {
 		return "meta"
 	} else if (p.flags & freelistPageFlag) != 0 {
 		return "freelist"
+	} else if (p.flags & blobPageFlag) != 0 {
+		return "blob"
 	}
 	return fmt.Sprintf("unknown<%02x>", p.flags)
 }
@@ -1907,6 +2579,31 @@ func (p *page) branchPageElement(index uint16) *branchPageElement {
 	return &((*[0x7FFFFFF]branchPageElement)(unsafe.Pointer(&p.ptr)))[index]
 }
 
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) prefix() []byte {
+	if (p.flags & prefixPageFlag) == 0 {
+		return nil
+	}
+	off := unsafe.Sizeof(leafPageElement{}) * uintptr(p.count)
+	if (p.flags & branchPageFlag) != 0 {
+		off = unsafe.Sizeof(branchPageElement{}) * uintptr(p.count)
+	}
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(&p.ptr))
+	n := uintptr(*(*uint16)(unsafe.Pointer(&buf[off])))
+	return buf[off+2 : off+2+n]
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) expandKey(suffix []byte) []byte {
+	prefix := p.prefix()
+	if len(prefix) == 0 {
+		return suffix
+	}
+	key := make([]byte, len(prefix)+len(suffix))
+	copy(key[copy(key, prefix):], suffix)
+	return key
+}
+
 // DO NOT EDIT. Copied from the "bolt" package.
 type branchPageElement struct {
 	pos   uint32
@@ -1992,7 +2689,7 @@ func (cmd *CompactCommand) Run(args ...string) (err error) {
 	initialSize := fi.Size()
 
 	// Open source database.
-	src, err := bolt.Open(cmd.SrcPath, 0444, &bolt.Options{ReadOnly: true})
+	src, err := bolt.Open(cmd.SrcPath, 0444, &bolt.Options{ReadOnly: true, RawKeyOrder: true})
 	if err != nil {
 		return err
 	}
@@ -2039,3 +2736,103 @@ Additional options include:
 		Defaults to 64KB.
 `, "\n")
 }
+
+// SalvageCommand represents the "salvage" command execution.
+type SalvageCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	SrcPath   string
+	DstPath   string
+	TxMaxSize int64
+}
+
+// newSalvageCommand returns a SalvageCommand.
+func newSalvageCommand(m *Main) *SalvageCommand {
+	return &SalvageCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *SalvageCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Int64Var(&cmd.TxMaxSize, "tx-max-size", 65536, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	}
+
+	// Require database paths.
+	cmd.SrcPath, cmd.DstPath = fs.Arg(0), fs.Arg(1)
+	if cmd.SrcPath == "" || cmd.DstPath == "" {
+		return ErrPathRequired
+	}
+
+	// Ensure source file exists and destination does not.
+	fi, err := os.Stat(cmd.SrcPath)
+	if os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if err != nil {
+		return err
+	}
+	if _, err := os.Stat(cmd.DstPath); err == nil {
+		return fmt.Errorf("output file already exists: %s", cmd.DstPath)
+	}
+
+	// Open destination database.
+	dst, err := bolt.Open(cmd.DstPath, fi.Mode(), nil)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	// Run salvage.
+	report, err := bolt.Salvage(dst, cmd.SrcPath, cmd.TxMaxSize)
+	if err != nil {
+		return err
+	}
+
+	// Report pages which could not be read.
+	for _, err := range report.Errors {
+		fmt.Fprintln(cmd.Stdout, err)
+	}
+	fmt.Fprintf(cmd.Stdout, "Scanned %d pages: recovered %d buckets and %d keys, %d orphan pages, %d errors\n",
+		report.PageCount, report.BucketCount, report.KeyCount, report.OrphanPageCount, len(report.Errors))
+	if report.OrphansSkipped {
+		fmt.Fprintln(cmd.Stdout, "WARNING: orphan pages were not recovered because the source has no readable freelist.")
+	}
+
+	return nil
+}
+
+// Usage returns the help message.
+func (cmd *SalvageCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt salvage [options] SRC DST
+
+Salvage scans every page of the possibly corrupted database at SRC path and
+copies all key/value pairs it can read into a new database at DST path. The
+bucket hierarchy is rebuilt from the newest valid meta page and from nested
+bucket headers. Pages that are not reachable from any bucket are recovered
+into the "salvaged-orphans" bucket, in a child bucket per page. They are not
+recovered if the freelist of SRC is missing, e.g. when it was not synced,
+since pages freed by past transactions would bring back deleted keys.
+
+Pages which could not be read are reported, along with the bucket they
+belong to. The original database is left untouched.
+
+Additional options include:
+
+	-tx-max-size NUM
+		Specifies the maximum size of individual transactions.
+		Defaults to 64KB.
+`, "\n")
+}
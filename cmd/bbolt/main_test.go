@@ -0,0 +1,2138 @@
+package main_test
+
+import (
+	"bytes"
+	crypto "crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	main "go.etcd.io/bbolt/cmd/bbolt"
+)
+
+// Ensure the "info" command can print information about a database.
+func TestInfoCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	// Run the info command.
+	m := NewMain()
+	if err := m.Run("info", db.Path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the "stats" command executes correctly with an empty database.
+func TestStatsCommand_Run_EmptyDatabase(t *testing.T) {
+	// Ignore
+	if os.Getpagesize() != 4096 {
+		t.Skip("system does not use 4KB page size")
+	}
+
+	db := MustOpen(0666, nil)
+	defer db.Close()
+	db.DB.Close()
+
+	// Generate expected result.
+	exp := "Aggregate statistics for 0 buckets\n\n" +
+		"Page count statistics\n" +
+		"\tNumber of logical branch pages: 0\n" +
+		"\tNumber of physical branch overflow pages: 0\n" +
+		"\tNumber of logical leaf pages: 0\n" +
+		"\tNumber of physical leaf overflow pages: 0\n" +
+		"Tree statistics\n" +
+		"\tNumber of keys/value pairs: 0\n" +
+		"\tNumber of levels in B+tree: 0\n" +
+		"Page size utilization\n" +
+		"\tBytes allocated for physical branch pages: 0\n" +
+		"\tBytes actually used for branch data: 0 (0%)\n" +
+		"\tBytes allocated for physical leaf pages: 0\n" +
+		"\tBytes actually used for leaf data: 0 (0%)\n" +
+		"Bucket statistics\n" +
+		"\tTotal number of buckets: 0\n" +
+		"\tTotal number on inlined buckets: 0 (0%)\n" +
+		"\tBytes used for inlined buckets: 0 (0%)\n"
+
+	// Run the command.
+	m := NewMain()
+	if err := m.Run("stats", db.Path); err != nil {
+		t.Fatal(err)
+	} else if m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+}
+
+// Ensure the "stats" command can execute correctly.
+func TestStatsCommand_Run(t *testing.T) {
+	// Ignore
+	if os.Getpagesize() != 4096 {
+		t.Skip("system does not use 4KB page size")
+	}
+
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		// Create "foo" bucket.
+		b, err := tx.CreateBucket([]byte("foo"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			if err := b.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+
+		// Create "bar" bucket.
+		b, err = tx.CreateBucket([]byte("bar"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+
+		// Create "baz" bucket.
+		b, err = tx.CreateBucket([]byte("baz"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("key"), []byte("value")); err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	// Generate expected result.
+	exp := "Aggregate statistics for 3 buckets\n\n" +
+		"Page count statistics\n" +
+		"\tNumber of logical branch pages: 0\n" +
+		"\tNumber of physical branch overflow pages: 0\n" +
+		"\tNumber of logical leaf pages: 1\n" +
+		"\tNumber of physical leaf overflow pages: 0\n" +
+		"Tree statistics\n" +
+		"\tNumber of keys/value pairs: 111\n" +
+		"\tNumber of levels in B+tree: 1\n" +
+		"Page size utilization\n" +
+		"\tBytes allocated for physical branch pages: 0\n" +
+		"\tBytes actually used for branch data: 0 (0%)\n" +
+		"\tBytes allocated for physical leaf pages: 4096\n" +
+		"\tBytes actually used for leaf data: 1996 (48%)\n" +
+		"Bucket statistics\n" +
+		"\tTotal number of buckets: 3\n" +
+		"\tTotal number on inlined buckets: 2 (66%)\n" +
+		"\tBytes used for inlined buckets: 236 (11%)\n"
+
+	// Run the command.
+	m := NewMain()
+	if err := m.Run("stats", db.Path); err != nil {
+		t.Fatal(err)
+	} else if m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+}
+
+// Ensure the "stats" command reports the own and total usage of each bucket
+// with -buckets, ordered by name or size, and the largest ones with -top.
+func TestStatsCommand_Run_Buckets(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	var bigStats bolt.BucketStats
+	if err := db.Update(func(tx *bolt.Tx) error {
+		small, err := tx.CreateBucket([]byte("a-small"))
+		if err != nil {
+			return err
+		} else if err := small.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		child, err := small.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		} else if err := child.Put([]byte("baz"), []byte("bat")); err != nil {
+			return err
+		}
+
+		big, err := tx.CreateBucket([]byte("b-big"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := big.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		nested, err := big.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 200; i++ {
+			if err := nested.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		bigStats = tx.Bucket([]byte("b-big")).Stats()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	type report struct {
+		Bucket [][]byte         `json:"bucket"`
+		Inline bool             `json:"inline"`
+		Own    bolt.BucketStats `json:"own"`
+		Total  bolt.BucketStats `json:"total"`
+	}
+	run := func(args ...string) []report {
+		m := NewMain()
+		if err := m.Run(append([]string{"-format", "json", "stats"}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		var reports []report
+		if err := json.Unmarshal(m.Stdout.Bytes(), &reports); err != nil {
+			t.Fatal(err)
+		}
+		return reports
+	}
+	paths := func(reports []report) string {
+		var names []string
+		for _, r := range reports {
+			names = append(names, string(bytes.Join(r.Bucket, []byte("/"))))
+		}
+		return strings.Join(names, ",")
+	}
+
+	reports := run("-buckets", db.Path)
+	if s := paths(reports); s != "a-small,a-small/child,b-big,b-big/nested" {
+		t.Fatalf("unexpected buckets: %s", s)
+	}
+	// Buckets holding nested buckets are never inlined.
+	for i, inline := range []bool{false, true, false, false} {
+		if reports[i].Inline != inline {
+			t.Fatalf("unexpected inline of %q: %v", reports[i].Bucket, reports[i].Inline)
+		}
+	}
+	if big, nested := reports[2], reports[3]; big.Total.KeyN != bigStats.KeyN || big.Total.Depth != bigStats.Depth {
+		t.Fatalf("unexpected total of b-big: %+v, expected %+v", big.Total, bigStats)
+	} else if exp := big.Own.LeafAlloc + nested.Own.LeafAlloc; big.Total.LeafAlloc != exp {
+		t.Fatalf("unexpected total leaf alloc of b-big: %d, expected %d", big.Total.LeafAlloc, exp)
+	} else if big.Own.LeafPageN == 0 || nested.Own.LeafPageN == 0 || big.Own.LeafAlloc <= nested.Own.LeafAlloc {
+		t.Fatalf("unexpected own stats: %+v, %+v", big.Own, nested.Own)
+	}
+
+	if s := paths(run("-buckets", "-sort", "size", db.Path)); s != "b-big,b-big/nested,a-small,a-small/child" {
+		t.Fatalf("unexpected buckets by size: %s", s)
+	} else if s := paths(run("-top", "2", db.Path)); s != "b-big,b-big/nested" {
+		t.Fatalf("unexpected top buckets: %s", s)
+	} else if s := paths(run("-buckets", db.Path, "a")); s != "a-small,a-small/child" {
+		t.Fatalf("unexpected buckets with prefix: %s", s)
+	}
+
+	// The text report indents nested buckets, unless only the top ones are
+	// printed with their full path.
+	m := NewMain()
+	if err := m.Run("stats", "-buckets", db.Path, "b"); err != nil {
+		t.Fatal(err)
+	} else if lines := strings.Split(strings.TrimSpace(m.Stdout.String()), "\n"); len(lines) != 3 ||
+		!strings.HasPrefix(lines[0], "BUCKET") || !strings.HasPrefix(lines[1], `"b-big" `) || !strings.HasPrefix(lines[2], `  "nested" `) {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+	m = NewMain()
+	if err := m.Run("stats", "-top", "1", db.Path); err != nil {
+		t.Fatal(err)
+	} else if lines := strings.Split(strings.TrimSpace(m.Stdout.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], `"b-big" `) {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+	m = NewMain()
+	if err := m.Run("stats", "-buckets", "-sort", "age", db.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure the "buckets" command can print a list of buckets.
+func TestBucketsCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"foo", "bar", "baz"} {
+			_, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	expected := "bar\nbaz\nfoo\n"
+
+	// Run the command.
+	m := NewMain()
+	if err := m.Run("buckets", db.Path); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != expected {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+}
+
+// Ensure the "keys" command can print a list of keys for a bucket.
+func TestKeysCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"foo", "bar"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				key := fmt.Sprintf("%s-%d", name, i)
+				if err := b.Put([]byte(key), []byte{0}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	expected := "foo-0\nfoo-1\nfoo-2\n"
+
+	// Run the command.
+	m := NewMain()
+	if err := m.Run("keys", db.Path, "foo"); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != expected {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+}
+
+// Ensure the "get" command can print the value of a key in a bucket.
+func TestGetCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"foo", "bar"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				key := fmt.Sprintf("%s-%d", name, i)
+				val := fmt.Sprintf("val-%s-%d", name, i)
+				if err := b.Put([]byte(key), []byte(val)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	expected := "val-foo-1\n"
+
+	// Run the command.
+	m := NewMain()
+	if err := m.Run("get", db.Path, "foo", "foo-1"); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != expected {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+}
+
+// Ensure the "keys" and "get" commands select nested buckets, bound the
+// listed keys and encode their output in the requested format.
+func TestKeysGetCommands_Run_Nested(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		outer, err := tx.CreateBucket([]byte("outer"))
+		if err != nil {
+			return err
+		}
+		inner, err := outer.CreateBucket([]byte("inner"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a1", "a2", "b1", "b2", "c1", "\xff\xff"} {
+			if err := inner.Put([]byte(k), []byte("value-"+k)); err != nil {
+				return err
+			}
+		}
+		_, err = inner.CreateBucket([]byte("bucket"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"keys", "-format", "ascii-encoded", db.Path, "outer", "inner"}, "\"a1\"\n\"a2\"\n\"b1\"\n\"b2\"\n\"bucket\"\n\"c1\"\n\"\\xff\\xff\"\n"},
+		{[]string{"keys", "-prefix", "b", db.Path, "outer", "inner"}, "b1\nb2\nbucket\n"},
+		{[]string{"keys", "-start", "a2", "-end", "c1", db.Path, "outer", "inner"}, "a2\nb1\nb2\nbucket\n"},
+		{[]string{"keys", "-prefix", "b", "-start", "b2", db.Path, "outer", "inner"}, "b2\nbucket\n"},
+		{[]string{"keys", "-reverse", "-end", "b2", db.Path, "outer", "inner"}, "b1\na2\na1\n"},
+		{[]string{"keys", "-reverse", "-limit", "2", "-format", "hex", db.Path, "outer", "inner"}, "ffff\n6331\n"},
+		{[]string{"keys", "-key-format", "hex", "-prefix", "ff", "-format", "base64", db.Path, "outer", "inner"}, "//8=\n"},
+		{[]string{"keys", "-limit", "1", "-start", "b", db.Path, "outer", "inner"}, "b1\n"},
+		{[]string{"keys", "-count", "-prefix", "a", db.Path, "outer", "inner"}, "2\n"},
+		{[]string{"-format", "json", "keys", "-count", db.Path, "outer", "inner"}, "{\n  \"count\": 7\n}\n"},
+		{[]string{"-format", "json", "keys", "-end", "a2", db.Path, "outer", "inner"}, "[\n  \"YTE=\"\n]\n"},
+		{[]string{"keys", db.Path, "outer"}, "inner\n"},
+		{[]string{"get", db.Path, "outer", "inner", "b1"}, "value-b1\n"},
+		{[]string{"get", "-key-format", "hex", "-format", "hex", db.Path, "outer", "inner", "ffff"}, "76616c75652dffff\n"},
+		{[]string{"get", "-key-format", "ascii-encoded", "-format", "ascii-encoded", db.Path, "outer", "inner", `"\xff\xff"`}, "\"value-\\xff\\xff\"\n"},
+	} {
+		m := NewMain()
+		if err := m.Run(tt.args...); err != nil {
+			t.Fatalf("%v: %s", tt.args, err)
+		} else if actual := m.Stdout.String(); actual != tt.expected {
+			t.Fatalf("%v: unexpected stdout:\n\n%s", tt.args, actual)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"keys", db.Path, "outer", "missing"}, main.ErrBucketNotFound},
+		{[]string{"get", db.Path, "outer", "inner", "missing"}, main.ErrKeyNotFound},
+		{[]string{"get", db.Path, "outer", "inner", "bucket"}, main.ErrKeyNotFound},
+		{[]string{"get", db.Path, "missing", "b1"}, main.ErrBucketNotFound},
+		{[]string{"get", db.Path, "b1"}, main.ErrBucketRequired},
+	} {
+		if err := NewMain().Run(tt.args...); err != tt.err {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+	}
+	if err := NewMain().Run("keys", "-format", "octal", db.Path, "outer"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure the commands walking a database handle values set by PutReader,
+// which cursors return as nil like nested buckets.
+func TestCommands_Run_Blob(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	blob := bytes.Repeat([]byte("x"), 3*os.Getpagesize())
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		if err := b.PutReader([]byte("blob"), bytes.NewReader(blob), int64(len(blob))); err != nil {
+			return err
+		}
+		sub, err := b.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		}
+		return sub.Put([]byte("baz"), []byte("bat"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	// Copy the database to diff against it.
+	other := MustOpen(0666, nil)
+	other.DB.Close()
+	defer other.Close()
+	data, err := os.ReadFile(db.Path)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(other.Path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	if err := m.Run("get", db.Path, "widgets", "blob"); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != string(blob)+"\n" {
+		t.Fatalf("unexpected value of %d bytes", len(actual))
+	}
+
+	m = NewMain()
+	if err := m.Run("stats", "-buckets", db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	m = NewMain()
+	if err := m.Run("diff", db.Path, other.Path); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != "" {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+
+	// Change the blob in the copy.
+	odb, err := bolt.Open(other.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := odb.Update(func(tx *bolt.Tx) error {
+		changed := bytes.Repeat([]byte("y"), len(blob))
+		return tx.Bucket([]byte("widgets")).PutReader([]byte("blob"), bytes.NewReader(changed), int64(len(changed)))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := odb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m = NewMain()
+	if err := m.Run("diff", "-format", "json", db.Path, other.Path); err != main.ErrDifferencesFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if actual := m.Stdout.String(); !strings.Contains(actual, `"op":"changed","type":"key"`) || strings.Count(actual, "\n") != 1 {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+}
+
+// Ensure the "diff" command reports added, removed and changed keys and
+// buckets and sequence changes, and exits with status 1 on differences.
+func TestDiffCommand_Run(t *testing.T) {
+	a, b := MustOpen(0666, nil), MustOpen(0666, nil)
+	defer a.Close()
+	defer b.Close()
+
+	put := func(b *bolt.Bucket, kv ...string) error {
+		for i := 0; i < len(kv); i += 2 {
+			if err := b.Put([]byte(kv[i]), []byte(kv[i+1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := a.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("gone")); err != nil {
+			return err
+		}
+		w, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if err := put(w, "a", "1", "b", "2", "c", "3"); err != nil {
+			return err
+		} else if _, err := w.CreateBucket([]byte("replaced")); err != nil {
+			return err
+		}
+		sub, err := w.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		} else if err := sub.SetSequence(1); err != nil {
+			return err
+		}
+		return put(sub, "x", "1")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("new")); err != nil {
+			return err
+		}
+		w, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if err := put(w, "a", "1", "b", "20", "d", "4", "replaced", "v"); err != nil {
+			return err
+		}
+		sub, err := w.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		} else if err := sub.SetSequence(5); err != nil {
+			return err
+		}
+		return put(sub, "x", "1")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	a.DB.Close()
+	b.DB.Close()
+
+	m := NewMain()
+	err := m.Run("diff", a.Path, b.Path)
+	if err != main.ErrDifferencesFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if status := main.ExitStatus(err); status != 1 {
+		t.Fatalf("unexpected exit status: %d", status)
+	}
+	expected := strings.Join([]string{
+		`- bucket "gone"`,
+		`+ bucket "new"`,
+		`* key "widgets"/"b": "2" -> "20"`,
+		`- key "widgets"/"c" = "3"`,
+		`+ key "widgets"/"d" = "4"`,
+		`- bucket "widgets"/"replaced"`,
+		`+ key "widgets"/"replaced" = "v"`,
+		`* sequence "widgets"/"sub": 1 -> 5`,
+	}, "\n") + "\n"
+	if actual := m.Stdout.String(); actual != expected {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+
+	// The json format prints one record per difference.
+	m = NewMain()
+	if err := m.Run("diff", "-format", "json", a.Path, b.Path); err != main.ErrDifferencesFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&m.Stdout)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 8 {
+		t.Fatalf("unexpected records: %v", records)
+	} else if r := records[2]; r["op"] != "changed" || r["type"] != "key" || r["key"] != "Yg==" ||
+		r["old_value"] != "Mg==" || r["new_value"] != "MjA=" || fmt.Sprint(r["bucket"]) != "[d2lkZ2V0cw==]" {
+		t.Fatalf("unexpected changed key record: %v", r)
+	} else if r := records[7]; r["type"] != "sequence" || r["old_sequence"] != 1.0 || r["new_sequence"] != 5.0 {
+		t.Fatalf("unexpected sequence record: %v", r)
+	} else if r := records[0]; r["op"] != "removed" || r["type"] != "bucket" || r["bucket"] != nil {
+		t.Fatalf("unexpected removed bucket record: %v", r)
+	}
+
+	// Identical databases exit with status 0, errors with status 2.
+	if err := NewMain().Run("diff", a.Path, a.Path); err != nil || main.ExitStatus(err) != 0 {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := NewMain().Run("diff", a.Path, a.Path+".missing"); main.ExitStatus(err) != 2 {
+		t.Fatalf("unexpected exit status of %v", err)
+	}
+}
+
+// Ensure the "diff" command tells failures apart from differences.
+func TestDiffCommand_Run_Error(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+	db.DB.Close()
+
+	err := NewMain().Run("diff", db.Path, db.Path+".missing")
+	if e, ok := err.(*main.DiffError); !ok || e.Err != main.ErrFileNotFound {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if err := NewMain().Run("diff", db.Path, db.Path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func init() {
+	bolt.RegisterComparator("cli-reverse", func(a, b []byte) int { return bytes.Compare(b, a) })
+}
+
+// Ensure the read-only commands open buckets whose comparator is not
+// registered, and that compact reports it.
+func TestCommands_Run_UnknownComparator(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("r"), &bolt.BucketOptions{Comparator: "cli-reverse"})
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			if err := b.Put([]byte(k), []byte("v"+k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	// Rename the comparator stored in the bucket header.
+	buf, err := os.ReadFile(db.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(db.Path, bytes.Replace(buf, []byte("cli-reverse"), []byte("cli-unknown"), 1), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	if err := m.Run("keys", db.Path, "r"); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != "c\nb\na\n" {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+
+	m = NewMain()
+	if err := m.Run("get", db.Path, "r", "b"); err != nil {
+		t.Fatal(err)
+	} else if actual := m.Stdout.String(); actual != "vb\n" {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+
+	for _, args := range [][]string{{"buckets", db.Path}, {"stats", db.Path}} {
+		if err := NewMain().Run(args...); err != nil {
+			t.Fatalf("%s: %s", args[0], err)
+		}
+	}
+
+	dstPath := db.Path + ".compacted"
+	defer os.Remove(dstPath)
+	if err := NewMain().Run("compact", "-o", dstPath, db.Path); err == nil || !strings.Contains(err.Error(), `unknown comparator "cli-unknown"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the "surgery revert-meta-page" command rolls back the last
+// transaction.
+func TestSurgeryCommand_RevertMetaPage(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	for _, name := range []string{"widgets", "gadgets"} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte(name))
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.DB.Close()
+
+	out := MustOpen(0666, nil)
+	out.DB.Close()
+	defer out.Close()
+
+	m := NewMain()
+	if err := m.Run("surgery", "revert-meta-page", "-o", out.Path, db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	odb, err := bolt.Open(out.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer odb.Close()
+	if err := odb.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil || tx.Bucket([]byte("gadgets")) != nil {
+			t.Fatal("unexpected buckets after revert")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the "surgery copy-page" command copies a leaf page over another one
+// and refuses to overwrite the meta pages.
+func TestSurgeryCommand_CopyPage(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	var src, dst int
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"widgets", "gadgets"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			// Make the bucket big enough not to be inlined.
+			for i := 0; i < 10; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%s-%02d", name, i)), make([]byte, 100)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		src = int(tx.Bucket([]byte("widgets")).Root())
+		dst = int(tx.Bucket([]byte("gadgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	out := MustOpen(0666, nil)
+	out.DB.Close()
+	defer out.Close()
+
+	for _, meta := range []string{"0", "1"} {
+		m := NewMain()
+		if err := m.Run("surgery", "copy-page", "-o", out.Path, "-from-page", strconv.Itoa(src), "-to-page", meta, db.Path); err == nil || !strings.Contains(err.Error(), "meta page") {
+			t.Fatalf("unexpected error copying to page %s: %v", meta, err)
+		}
+	}
+
+	m := NewMain()
+	if err := m.Run("surgery", "copy-page", "-o", out.Path, "-from-page", strconv.Itoa(src), "-to-page", strconv.Itoa(dst), db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	odb, err := bolt.Open(out.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer odb.Close()
+	if err := odb.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket([]byte("gadgets")).Cursor().First()
+		if string(k) != "widgets-00" {
+			t.Fatalf("unexpected first key: %q", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the "surgery clear-page-elements" command removes a range of
+// elements and leaves a database that opens.
+func TestSurgeryCommand_ClearPageElements(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	var root int
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	out := MustOpen(0666, nil)
+	out.DB.Close()
+	defer out.Close()
+
+	m := NewMain()
+	if err := m.Run("surgery", "clear-page-elements", "-o", out.Path, "-page", strconv.Itoa(root), "-from-index", "5", "-to-index", "15", db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	odb, err := bolt.Open(out.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer odb.Close()
+	if err := odb.View(func(tx *bolt.Tx) error {
+		var keys []string
+		if err := tx.Bucket([]byte("widgets")).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(keys) != 10 || keys[4] != "0004" || keys[5] != "0015" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m = NewMain()
+	if err := m.Run("surgery", "clear-page-elements", "-o", out.Path, "-page", "1", "-from-index", "0", db.Path); err == nil {
+		t.Fatal("expected error clearing a meta page")
+	}
+}
+
+// Ensure the "surgery abandon-freelist" command leaves a database whose
+// freelist is rebuilt on open.
+func TestSurgeryCommand_AbandonFreelist(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Free some pages so that the rebuilt freelist isn't empty.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	out := MustOpen(0666, nil)
+	out.DB.Close()
+	defer out.Close()
+
+	m := NewMain()
+	if err := m.Run("surgery", "abandon-freelist", "-o", out.Path, db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	odb, err := bolt.Open(out.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer odb.Close()
+	if n := odb.Stats().FreePageN; n == 0 {
+		t.Fatal("expected a rebuilt freelist with free pages")
+	}
+	if err := odb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("gadgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := odb.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the "surgery clear-page-elements" command warns when it removes
+// values set by PutReader, directly or in an inline bucket.
+func TestSurgeryCommand_ClearPageElements_Blob(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	blob := make([]byte, 3*os.Getpagesize())
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.PutReader([]byte("0-blob"), bytes.NewReader(blob), int64(len(blob))); err != nil {
+			return err
+		}
+		child, err := b.CreateBucket([]byte("1-inline"))
+		if err != nil {
+			return err
+		}
+		if err := child.PutReader([]byte("blob"), bytes.NewReader(blob), int64(len(blob))); err != nil {
+			return err
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("2-key-%02d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var root int
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	out := MustOpen(0666, nil)
+	out.DB.Close()
+	defer out.Close()
+
+	for _, tt := range []struct {
+		index   int
+		warning bool
+	}{
+		{0, true},
+		{1, true},
+		{2, false},
+	} {
+		m := NewMain()
+		if err := m.Run("surgery", "clear-page-elements", "-o", out.Path, "-page", strconv.Itoa(root),
+			"-from-index", strconv.Itoa(tt.index), "-to-index", strconv.Itoa(tt.index+1), db.Path); err != nil {
+			t.Fatal(err)
+		} else if warning := strings.Contains(m.Stdout.String(), "WARNING"); warning != tt.warning {
+			t.Fatalf("element %d: unexpected output:\n\n%s", tt.index, m.Stdout.String())
+		}
+	}
+}
+
+// Ensure the "surgery clear-page-elements" command keeps the keys of a
+// prefix compressed page.
+func TestSurgeryCommand_ClearPageElements_Prefix(t *testing.T) {
+	db := MustOpen(0666, &bolt.Options{PrefixCompression: true})
+	defer db.Close()
+
+	var root int
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 50; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("tenant-0001/entity-0001/%04d", i)), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	out := MustOpen(0666, nil)
+	out.DB.Close()
+	defer out.Close()
+
+	m := NewMain()
+	if err := m.Run("surgery", "clear-page-elements", "-o", out.Path, "-page", strconv.Itoa(root), "-from-index", "30", db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	odb, err := bolt.Open(out.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer odb.Close()
+	if err := odb.View(func(tx *bolt.Tx) error {
+		var i int
+		if err := tx.Bucket([]byte("widgets")).ForEach(func(k, _ []byte) error {
+			if exp := fmt.Sprintf("tenant-0001/entity-0001/%04d", i); string(k) != exp {
+				t.Fatalf("unexpected key %q, expected %q", k, exp)
+			}
+			i++
+			return nil
+		}); err != nil {
+			return err
+		} else if i != 30 {
+			t.Fatalf("unexpected key count: %d", i)
+		}
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the "page" command prints whole keys of prefix compressed pages in
+// JSON.
+func TestPageCommand_Run_JSONPrefix(t *testing.T) {
+	db := MustOpen(0666, &bolt.Options{PrefixCompression: true})
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 50; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("tenant-0001/entity-0001/%04d", i)), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var root int
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	m := NewMain()
+	if err := m.Run("-format", "json", "page", db.Path, strconv.Itoa(root)); err != nil {
+		t.Fatal(err)
+	}
+	var pages []struct {
+		Items []struct {
+			Key []byte `json:"key"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(m.Stdout.Bytes(), &pages); err != nil {
+		t.Fatal(err)
+	} else if len(pages) != 1 || len(pages[0].Items) != 50 {
+		t.Fatalf("unexpected output:\n\n%s", m.Stdout.String())
+	}
+	for i, item := range pages[0].Items {
+		if exp := fmt.Sprintf("tenant-0001/entity-0001/%04d", i); string(item.Key) != exp {
+			t.Fatalf("unexpected key %q, expected %q", item.Key, exp)
+		}
+	}
+}
+
+// Ensure the "page" command prints a bucket element whose value is too short
+// for a bucket header instead of crashing.
+func TestPageCommand_Run_ShortBucketValue(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("a"), []byte("x")); err != nil {
+			return err
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("k%02d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var root int
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	db.DB.Close()
+
+	// Flag the first element, "a", as a bucket.
+	f, err := os.OpenFile(db.Path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0x01, 0, 0, 0}, int64(root*pageSize+main.PageHeaderSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	if err := m.Run("page", db.Path, strconv.Itoa(root)); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(m.Stdout.String(), `"a": "x"`) {
+		t.Fatalf("unexpected output:\n\n%s", m.Stdout.String())
+	}
+
+	m = NewMain()
+	if err := m.Run("-format", "json", "page", db.Path, strconv.Itoa(root)); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(m.Stdout.String(), `"value": "eA=="`) {
+		t.Fatalf("unexpected output:\n\n%s", m.Stdout.String())
+	}
+}
+
+// Ensure the "meta" command marks the active meta page, reports an invalid
+// checksum, and copies the database as of the older meta page with -o.
+func TestMetaCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	for _, value := range []string{"old", "new"} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), []byte(value))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pageSize := db.Info().PageSize
+	db.DB.Close()
+
+	type metaStatus struct {
+		PageID     int    `json:"page_id"`
+		Active     bool   `json:"active"`
+		Error      string `json:"error"`
+		ChecksumOK bool   `json:"checksum_ok"`
+		TxID       uint64 `json:"txid"`
+	}
+	metas := func() []metaStatus {
+		m := NewMain()
+		if err := m.Run("-format", "json", "meta", db.Path); err != nil {
+			t.Fatal(err)
+		}
+		var metas []metaStatus
+		if err := json.Unmarshal(m.Stdout.Bytes(), &metas); err != nil {
+			t.Fatal(err)
+		} else if len(metas) != 2 {
+			t.Fatalf("unexpected meta pages: %+v", metas)
+		}
+		return metas
+	}
+
+	// The meta page with the highest txid is active.
+	ms := metas()
+	active, older := ms[0], ms[1]
+	if older.TxID > active.TxID {
+		active, older = older, active
+	}
+	if !active.Active || older.Active || active.Error != "" || older.Error != "" || active.TxID != older.TxID+1 {
+		t.Fatalf("unexpected meta pages: %+v", ms)
+	}
+
+	// The copy as of the older meta page holds the previous value.
+	out := db.Path + ".older"
+	defer os.Remove(out)
+	m := NewMain()
+	if err := m.Run("meta", "-o", out, db.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := fmt.Sprintf("Copied database as of txid %d to %s\n", older.TxID, out); m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+	m = NewMain()
+	if err := m.Run("get", out, "widgets", "foo"); err != nil {
+		t.Fatal(err)
+	} else if m.Stdout.String() != "old\n" {
+		t.Fatalf("unexpected value in copy: %s", m.Stdout.String())
+	}
+	if err := NewMain().Run("meta", "-o", out, db.Path); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Corrupting the active meta page makes the other one active.
+	f, err := os.OpenFile(db.Path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, int64(active.PageID*pageSize+main.PageHeaderSize+40)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ms = metas()
+	if c := ms[active.PageID]; c.Active || c.ChecksumOK || c.Error != bolt.ErrChecksum.Error() {
+		t.Fatalf("unexpected corrupted meta page: %+v", c)
+	} else if o := ms[older.PageID]; !o.Active {
+		t.Fatalf("unexpected older meta page: %+v", o)
+	}
+	m = NewMain()
+	if err := m.Run("meta", db.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := fmt.Sprintf("Meta Page:  %d (invalid: %s)\n", active.PageID, bolt.ErrChecksum); !strings.Contains(m.Stdout.String(), exp) {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+}
+
+// Ensure the "space-map" command classifies every page, fits the map in the
+// requested size and counts the runs of free pages in its histogram.
+func TestSpaceMapCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	// Free a large value, whose pages form a single run.
+	pageSize := db.Info().PageSize
+	for _, fn := range []func(b *bolt.Bucket) error{
+		func(b *bolt.Bucket) error { return b.Put([]byte("large"), make([]byte, 20*pageSize)) },
+		func(b *bolt.Bucket) error { return b.Put([]byte("small"), []byte("value")) },
+		func(b *bolt.Bucket) error { return b.Delete([]byte("large")) },
+		func(b *bolt.Bucket) error { return nil },
+	} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return fn(b)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.DB.Close()
+
+	var out struct {
+		PageCount    int            `json:"page_count"`
+		PagesPerCell int            `json:"pages_per_cell"`
+		Map          []string       `json:"map"`
+		Counts       map[string]int `json:"counts"`
+		FreeSpans    []struct {
+			Min, Max, Spans, Pages int
+		} `json:"free_spans"`
+	}
+	m := NewMain()
+	if err := m.Run("-format", "json", "space-map", "-width", "4", "-rows", "2", db.Path); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(m.Stdout.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for _, c := range out.Counts {
+		n += c
+	}
+	if n != out.PageCount || out.Counts["meta"] != 2 || out.Counts["free"] < 20 {
+		t.Fatalf("unexpected page counts: %d pages, %v", out.PageCount, out.Counts)
+	}
+	if len(out.Map) > 2 || out.PagesPerCell != (out.PageCount+7)/8 || out.Map[0][0] != 'M' {
+		t.Fatalf("unexpected map of %d pages per cell: %q", out.PagesPerCell, out.Map)
+	}
+	var free, largest int
+	for _, s := range out.FreeSpans {
+		if s.Spans == 0 || s.Max != 2*s.Min-1 || s.Pages < s.Spans*s.Min || s.Pages > s.Spans*s.Max {
+			t.Fatalf("unexpected free spans: %+v", out.FreeSpans)
+		}
+		free, largest = free+s.Pages, s.Max
+	}
+	if free != out.Counts["free"] || largest < 16 {
+		t.Fatalf("unexpected free spans of %d free pages: %+v", out.Counts["free"], out.FreeSpans)
+	}
+
+	// One character per page fits small databases.
+	m = NewMain()
+	if err := m.Run("space-map", db.Path); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); !strings.Contains(s, "\n0          MM") || !strings.Contains(s, "Each character shows the most frequent class of 1 page(s).") ||
+		!strings.Contains(s, fmt.Sprintf("\tfree:      %d (", out.Counts["free"])) {
+		t.Fatalf("unexpected stdout:\n\n%s", s)
+	}
+	if err := NewMain().Run("space-map", "-width", "0", db.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure the "bench" command draws keys from the key distribution over the
+// key space, with sizes in range, and value sizes from the value model.
+func TestBenchCommand_Run_Distributions(t *testing.T) {
+	type entry struct {
+		n         uint32
+		keySize   int
+		valueSize int
+	}
+	bench := func(args ...string) []entry {
+		path := filepath.Join(t.TempDir(), "bench.db")
+		m := NewMain()
+		if err := m.Run(append([]string{"bench", "-work", "-path", path, "-count", "500", "-key-space", "1000"}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var entries []entry
+		if err := db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("bench")).ForEach(func(k, v []byte) error {
+				entries = append(entries, entry{binary.BigEndian.Uint32(k), len(k), len(v)})
+				return nil
+			})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	// All operations on the hot keys only touch the first tenth of the key
+	// space.
+	sizes := map[int]bool{}
+	for _, e := range bench("-key-dist", "hotspot", "-hotspot-keys", "0.1", "-hotspot-ops", "1",
+		"-key-size", "6", "-key-size-max", "9", "-value-dist", "uniform", "-value-size", "10", "-value-size-max", "50") {
+		if e.n < 1 || e.n > 100 {
+			t.Fatalf("key out of the hot spot: %d", e.n)
+		} else if e.keySize != 6+int(e.n%4) {
+			t.Fatalf("unexpected size of key %d: %d", e.n, e.keySize)
+		} else if e.valueSize < 10 || e.valueSize > 50 {
+			t.Fatalf("unexpected value size: %d", e.valueSize)
+		}
+		sizes[e.valueSize] = true
+	}
+	if len(sizes) < 2 {
+		t.Fatalf("unexpected value sizes: %v", sizes)
+	}
+
+	// A steep zipf distribution favors the first keys.
+	var first bool
+	for _, e := range bench("-key-dist", "zipf", "-zipf-s", "5") {
+		if e.n >= 100 {
+			t.Fatalf("unexpected zipf key: %d", e.n)
+		} else if e.valueSize != 32 {
+			t.Fatalf("unexpected value size: %d", e.valueSize)
+		}
+		first = first || e.n == 1
+	}
+	if !first {
+		t.Fatal("first key not drawn")
+	}
+
+	// Uniform keys spread over the key space. Log-normal value sizes are
+	// capped.
+	var high bool
+	sizes = map[int]bool{}
+	for _, e := range bench("-key-dist", "uniform", "-value-dist", "lognormal", "-value-size", "20", "-value-size-max", "40") {
+		if e.n < 1 || e.n > 1000 {
+			t.Fatalf("key out of the key space: %d", e.n)
+		} else if e.valueSize > 40 {
+			t.Fatalf("unexpected value size: %d", e.valueSize)
+		}
+		high = high || e.n > 100
+		sizes[e.valueSize] = true
+	}
+	if !high || len(sizes) < 2 {
+		t.Fatalf("unexpected uniform keys or log-normal value sizes: %v", sizes)
+	}
+
+	for _, args := range [][]string{
+		{"-key-dist", "gaussian"},
+		{"-value-dist", "gaussian"},
+		{"-key-size", "3"},
+		{"-zipf-s", "1"},
+		{"-hotspot-keys", "0"},
+		{"-value-size", "10", "-value-size-max", "5"},
+	} {
+		if err := NewMain().Run(append([]string{"bench"}, args...)...); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+	}
+}
+
+// Ensure the latency histogram of the bench command bounds the error of its
+// percentiles.
+func TestLatencyHistogram(t *testing.T) {
+	var a, b main.LatencyHistogram
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			a.Record(time.Duration(i) * time.Microsecond)
+		} else {
+			b.Record(time.Duration(i) * time.Microsecond)
+		}
+	}
+	a.Merge(&b)
+
+	if n := a.Count(); n != 1000 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+	for _, p := range []float64{1, 50, 95, 99} {
+		exp := time.Duration(p*10) * time.Microsecond
+		if d := a.Percentile(p); d < exp || d > exp+exp/8 {
+			t.Fatalf("unexpected p%v: %v, expected %v", p, d, exp)
+		}
+	}
+	if d := a.Percentile(100); d != 1000*time.Microsecond {
+		t.Fatalf("unexpected max: %v", d)
+	}
+	if d := (&main.LatencyHistogram{}).Percentile(50); d != 0 {
+		t.Fatalf("unexpected percentile of an empty histogram: %v", d)
+	}
+}
+
+// Ensure the handler of the "serve" command browses buckets, pages through
+// keys with seek and encodes values in the requested format.
+func TestServeCommand_Handler(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 250; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+				return err
+			}
+		}
+		sub, err := b.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		}
+		return sub.Put([]byte{0x00, 0xff}, []byte{0x01, 0x02})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h := main.NewServeHandler(db.DB, db.Path)
+
+	b64 := func(s string) string { return url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(s))) }
+	widgets := "bucket=" + b64("widgets")
+
+	// The root lists the top-level buckets.
+	var keys struct {
+		Entries []struct {
+			Key     []byte `json:"key"`
+			Display string `json:"display"`
+			Bucket  bool   `json:"bucket"`
+		} `json:"entries"`
+		Next []byte `json:"next"`
+	}
+	mustServe(t, h, "/api/keys", http.StatusOK, &keys)
+	if len(keys.Entries) != 1 || string(keys.Entries[0].Key) != "widgets" || !keys.Entries[0].Bucket || keys.Next != nil {
+		t.Fatalf("unexpected root keys: %+v", keys)
+	}
+
+	// Pages of keys follow each other through seek.
+	var n int
+	query := "/api/keys?limit=100&" + widgets
+	for page := 0; ; page++ {
+		keys.Next = nil
+		mustServe(t, h, query, http.StatusOK, &keys)
+		for i, e := range keys.Entries {
+			if exp := fmt.Sprintf("%03d", n+i); n+i < 250 && string(e.Key) != exp {
+				t.Fatalf("unexpected key at %d: %q", n+i, e.Key)
+			}
+		}
+		n += len(keys.Entries)
+		if keys.Next == nil {
+			break
+		} else if page > 3 {
+			t.Fatal("too many pages")
+		}
+		query = "/api/keys?limit=100&" + widgets + "&seek=" + b64(string(keys.Next))
+	}
+	if n != 251 {
+		t.Fatalf("unexpected number of keys: %d", n)
+	} else if e := keys.Entries[len(keys.Entries)-1]; string(e.Key) != "sub" || !e.Bucket {
+		t.Fatalf("unexpected last entry: %+v", e)
+	}
+
+	// Nested buckets are selected by repeating the bucket parameter.
+	mustServe(t, h, "/api/keys?format=hex&"+widgets+"&bucket="+b64("sub"), http.StatusOK, &keys)
+	if len(keys.Entries) != 1 || keys.Entries[0].Display != "00ff" {
+		t.Fatalf("unexpected nested keys: %+v", keys)
+	}
+
+	// Values are displayed in the requested format.
+	for format, exp := range map[string]string{
+		"":              `"value-042"`,
+		"ascii-encoded": `"value-042"`,
+		"bytes":         "value-042",
+		"hex":           "76616c75652d303432",
+		"base64":        "dmFsdWUtMDQy",
+	} {
+		var value struct {
+			Value   []byte `json:"value"`
+			Display string `json:"display"`
+			Size    int    `json:"size"`
+		}
+		mustServe(t, h, "/api/value?"+widgets+"&key="+b64("042")+"&format="+format, http.StatusOK, &value)
+		if value.Display != exp || string(value.Value) != "value-042" || value.Size != 9 {
+			t.Fatalf("unexpected value in format %q: %+v", format, value)
+		}
+	}
+
+	// Errors are returned with a matching status.
+	mustServe(t, h, "/api/value?"+widgets+"&key="+b64("999"), http.StatusNotFound, nil)
+	mustServe(t, h, "/api/value?bucket="+b64("missing")+"&key="+b64("042"), http.StatusNotFound, nil)
+	mustServe(t, h, "/api/value?"+widgets+"&key="+b64("042")+"&format=octal", http.StatusBadRequest, nil)
+	mustServe(t, h, "/api/value?"+widgets+"&key=!", http.StatusBadRequest, nil)
+	mustServe(t, h, "/api/keys?limit=0", http.StatusBadRequest, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/keys", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status of POST: %d", w.Code)
+	}
+}
+
+// Ensure the handler of the "serve" command returns bucket statistics and
+// pages.
+func TestServeCommand_Handler_StatsAndPages(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"foo", "bar"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 100; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%s-%03d", name, i)), make([]byte, 50)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h := main.NewServeHandler(db.DB, db.Path)
+
+	var stats struct {
+		Stats bolt.BucketStats `json:"stats"`
+	}
+	mustServe(t, h, "/api/stats?bucket="+url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("foo"))), http.StatusOK, &stats)
+	if stats.Stats.KeyN != 100 {
+		t.Fatalf("unexpected bucket stats: %+v", stats.Stats)
+	}
+	mustServe(t, h, "/api/stats", http.StatusOK, &stats)
+	if stats.Stats.KeyN != 200 || stats.Stats.BucketN != 2 {
+		t.Fatalf("unexpected stats of all buckets: %+v", stats.Stats)
+	}
+
+	var page struct {
+		ID   int    `json:"id"`
+		Type string `json:"type"`
+	}
+	mustServe(t, h, "/api/page?id=0", http.StatusOK, &page)
+	if page.ID != 0 || page.Type != "meta" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	mustServe(t, h, "/api/page?id=abc", http.StatusBadRequest, nil)
+	mustServe(t, h, "/api/page?id=1000000", http.StatusBadRequest, nil)
+}
+
+// Ensure the "serve" command rejects options following the path, which
+// would otherwise be ignored.
+func TestServeCommand_Run_OptionAfterPath(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	if err := m.Run("serve", db.Path, "-addr", "localhost:0"); err == nil || !strings.Contains(err.Error(), "-addr") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// mustServe requests target from h, checks the status of the response and
+// decodes it into v, unless v is nil.
+func mustServe(t *testing.T, h http.Handler, target string, status int, v interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != status {
+		t.Fatalf("GET %s: unexpected status %d: %s", target, w.Code, w.Body.String())
+	} else if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %s", target, err)
+		}
+	}
+}
+
+// Ensure the "put", "delete", "create-bucket" and "delete-bucket" commands
+// write to nested buckets, read keys and values from files and stdin, and
+// fail on missing buckets and keys.
+func TestWriteCommands_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	keyFile := db.Path + ".key"
+	if err := os.WriteFile(keyFile, []byte("file-key"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile)
+
+	for _, tt := range []struct {
+		stdin string
+		args  []string
+		err   error
+	}{
+		{args: []string{"create-bucket", db.Path, "widgets"}},
+		{args: []string{"create-bucket", db.Path, "widgets", "sub"}},
+		{args: []string{"create-bucket", db.Path, "widgets", "sub"}, err: bolt.ErrBucketExists},
+		{args: []string{"create-bucket", db.Path, "missing", "sub"}, err: main.ErrBucketNotFound},
+		{args: []string{"create-bucket", "-parents", db.Path, "a", "b", "c"}},
+		{args: []string{"create-bucket", "-parents", db.Path, "a", "b", "c"}},
+		{args: []string{"put", db.Path, "widgets", "sub", "foo", "bar"}},
+		{args: []string{"put", "-key-format", "hex", "-value-format", "base64", db.Path, "widgets", "00ff", "AQI="}},
+		{args: []string{"put", "-key-file", keyFile, db.Path, "widgets", "from-arg"}},
+		{stdin: "from-stdin", args: []string{"put", "-value-file", "-", db.Path, "widgets", "stdin-key"}},
+		{args: []string{"put", "-key-file", "-", "-value-file", "-", db.Path, "widgets"}, err: main.ErrStdinUsedTwice},
+		{args: []string{"put", db.Path, "widgets", "missing", "foo", "bar"}, err: main.ErrBucketNotFound},
+		{args: []string{"put", db.Path, "widgets", "foo"}, err: main.ErrBucketRequired},
+		{args: []string{"delete", db.Path, "widgets", "sub", "foo"}},
+		{args: []string{"delete", db.Path, "widgets", "sub", "foo"}, err: main.ErrKeyNotFound},
+		{stdin: `"\x00\xff"` + "\n", args: []string{"delete", "-key-format", "ascii-encoded", "-key-file", "-", db.Path, "widgets"}},
+		{args: []string{"delete-bucket", db.Path, "a", "b"}},
+		{args: []string{"delete-bucket", db.Path, "a", "b"}, err: main.ErrBucketNotFound},
+		{args: []string{"delete-bucket", db.Path, "missing"}, err: main.ErrBucketNotFound},
+	} {
+		m := NewMain()
+		m.Stdin.WriteString(tt.stdin)
+		if err := m.Run(tt.args...); err != tt.err {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+	}
+
+	// Check the content of the database.
+	rdb, err := bolt.Open(db.Path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+	if err := rdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b == nil {
+			t.Fatal("widgets not found")
+		}
+		for k, v := range map[string]string{"file-key": "from-arg", "stdin-key": "from-stdin"} {
+			if got := b.Get([]byte(k)); string(got) != v {
+				t.Fatalf("unexpected value of %q: %q", k, got)
+			}
+		}
+		if v := b.Get([]byte{0x00, 0xff}); v != nil {
+			t.Fatalf("unexpected value of deleted key: %q", v)
+		} else if sub := b.Bucket([]byte("sub")); sub == nil {
+			t.Fatal("sub not found")
+		} else if k, _ := sub.Cursor().First(); k != nil {
+			t.Fatalf("unexpected key in sub: %q", k)
+		}
+		if a := tx.Bucket([]byte("a")); a == nil || a.Bucket([]byte("b")) != nil {
+			t.Fatal("unexpected content of a")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a database exported with the "export" command and imported with the
+// "import" command into a new file is identical to the original, in both
+// encodings and through files and stdin/stdout.
+func TestExportImportCommands_RoundTrip(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.SetSequence(42); err != nil {
+			return err
+		}
+		for i := 0; i < 300; i++ {
+			if err := b.Put([]byte{0x00, byte(i >> 8), byte(i), 0xff}, bytes.Repeat([]byte{byte(i)}, i%7)); err != nil {
+				return err
+			}
+		}
+		sub, err := b.CreateBucket([]byte{0xfe, 0x00})
+		if err != nil {
+			return err
+		}
+		if err := sub.SetSequence(7); err != nil {
+			return err
+		}
+		if err := sub.Put([]byte("\n\"key\""), []byte{}); err != nil {
+			return err
+		}
+		if _, err := sub.CreateBucket([]byte("empty")); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte("other"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	expected, err := chkdb(db.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []string{"base64", "hex"} {
+		exported := db.Path + ".export"
+		imported := db.Path + "." + encoding
+
+		// Export to a file, import it and export the copy to stdout.
+		m := NewMain()
+		if err := m.Run("export", "-encoding", encoding, "-o", exported, db.Path); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewMain().Run("import", "-i", exported, imported); err != nil {
+			t.Fatal(err)
+		}
+		m = NewMain()
+		if err := m.Run("export", "-encoding", encoding, imported); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(exported)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(m.Stdout.Bytes(), b) {
+			t.Fatalf("%s: exports differ:\n\n%s\n\n%s", encoding, b, m.Stdout.String())
+		}
+
+		// Importing from stdin gives the same database.
+		restored := imported + ".stdin"
+		m = NewMain()
+		m.Stdin.Write(b)
+		if err := m.Run("import", restored); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, path := range []string{imported, restored} {
+			if err := NewMain().Run("diff", db.Path, path); err != nil {
+				t.Fatalf("%s: unexpected diff: %v", encoding, err)
+			} else if actual, err := chkdb(path); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(actual, expected) {
+				t.Fatalf("%s: unexpected content:\n\n%s\n\nexpected:\n\n%s", encoding, actual, expected)
+			}
+			os.Remove(path)
+		}
+		os.Remove(exported)
+	}
+}
+
+// Ensure the "shell" command parses quoted arguments and runs commands
+// against the current bucket, in their own or an explicit transaction.
+func TestShellCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("sub"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	m := NewMain()
+	m.Stdin.WriteString(strings.Join([]string{
+		`get foo`,
+		`cd widgets`,
+		`put "a key\x00"   "some value"`,
+		`put plain v1`,
+		`get "a key\x00"`,
+		`ls`,
+		`seek a`,
+		`next`,
+		`next`,
+		`next`,
+		`prev`,
+		`put "unterminated`,
+		`cd missing`,
+		`cd sub`,
+		`cd .. sub`,
+		`begin`,
+		`put tx-key tx-value`,
+		`rollback`,
+		`get tx-key`,
+		`cd /`,
+		`bogus`,
+		`exit`,
+	}, "\n"))
+	if err := m.Run("shell", db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"bbolt:/> error: " + main.ErrShellNoBucket.Error(),
+		`bbolt:/> bbolt:/widgets> bbolt:/widgets> bbolt:/widgets> "some value"`,
+		`bbolt:/widgets> "a key\x00"`,
+		"plain",
+		"sub/",
+		`bbolt:/widgets> "a key\x00" = "some value"`,
+		"bbolt:/widgets> plain = v1",
+		"bbolt:/widgets> sub/",
+		"bbolt:/widgets> (end)",
+		"bbolt:/widgets> plain = v1",
+		`bbolt:/widgets> error: invalid quoted argument: "unterminated`,
+		"bbolt:/widgets> error: " + main.ErrBucketNotFound.Error(),
+		"bbolt:/widgets> bbolt:/widgets/sub> bbolt:/widgets/sub> bbolt:/widgets/sub (tx)> bbolt:/widgets/sub (tx)> " +
+			"bbolt:/widgets/sub> error: " + main.ErrKeyNotFound.Error(),
+		`bbolt:/widgets/sub> bbolt:/> error: unknown command "bogus", type "help" for the list of commands`,
+		"bbolt:/> ",
+	}, "\n")
+	if actual := m.Stdout.String(); actual != expected {
+		t.Fatalf("unexpected stdout:\n\n%s", actual)
+	}
+}
+
+// Ensure the "shell" command refuses to write in read-only mode.
+func TestShellCommand_Run_ReadOnly(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	m.Stdin.WriteString("cd widgets\nput foo bar\nbegin\ndel foo\n")
+	if err := m.Run("shell", "-readonly", db.Path); err != nil {
+		t.Fatal(err)
+	} else if n := strings.Count(m.Stdout.String(), "error: "+main.ErrShellReadOnly.Error()); n != 2 {
+		t.Fatalf("unexpected output:\n\n%s", m.Stdout.String())
+	}
+}
+
+// Main represents a test wrapper for main.Main that records output.
+type Main struct {
+	*main.Main
+	Stdin  bytes.Buffer
+	Stdout bytes.Buffer
+	Stderr bytes.Buffer
+}
+
+// NewMain returns a new instance of Main.
+func NewMain() *Main {
+	m := &Main{Main: main.NewMain()}
+	m.Main.Stdin = &m.Stdin
+	m.Main.Stdout = &m.Stdout
+	m.Main.Stderr = &m.Stderr
+	return m
+}
+
+// MustOpen creates a Bolt database in a temporary location.
+func MustOpen(mode os.FileMode, options *bolt.Options) *DB {
+	// Create temporary path.
+	f, _ := os.CreateTemp("", "bolt-")
+	f.Close()
+	os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), mode, options)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &DB{DB: db, Path: f.Name()}
+}
+
+// DB is a test wrapper for bolt.DB.
+type DB struct {
+	*bolt.DB
+	Path string
+}
+
+// Close closes and removes the database.
+func (db *DB) Close() error {
+	defer os.Remove(db.Path)
+	return db.DB.Close()
+}
+
+func TestCompactCommand_Run(t *testing.T) {
+	var s int64
+	if err := binary.Read(crypto.Reader, binary.BigEndian, &s); err != nil {
+		t.Fatal(err)
+	}
+	rand.Seed(s)
+
+	dstdb := MustOpen(0666, nil)
+	dstdb.Close()
+
+	// fill the db
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		n := 2 + rand.Intn(5)
+		for i := 0; i < n; i++ {
+			k := []byte(fmt.Sprintf("b%d", i))
+			b, err := tx.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			if err := b.SetSequence(uint64(i)); err != nil {
+				return err
+			}
+			if err := fillBucket(b, append(k, '.')); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	// make the db grow by adding large values, and delete them.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("large_vals"))
+		if err != nil {
+			return err
+		}
+		n := 5 + rand.Intn(5)
+		for i := 0; i < n; i++ {
+			v := make([]byte, 1000*1000*(1+rand.Intn(5)))
+			_, err := crypto.Read(v)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(fmt.Sprintf("l%d", i)), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("large_vals")).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteBucket([]byte("large_vals"))
+	}); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+	defer dstdb.Close()
+
+	dbChk, err := chkdb(db.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	if err := m.Run("compact", "-o", dstdb.Path, db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	dbChkAfterCompact, err := chkdb(db.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dstdbChk, err := chkdb(dstdb.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dbChk, dbChkAfterCompact) {
+		t.Error("the original db has been touched")
+	}
+	if !bytes.Equal(dbChk, dstdbChk) {
+		t.Error("the compacted db data isn't the same than the original db")
+	}
+}
+
+func fillBucket(b *bolt.Bucket, prefix []byte) error {
+	n := 10 + rand.Intn(50)
+	for i := 0; i < n; i++ {
+		v := make([]byte, 10*(1+rand.Intn(4)))
+		_, err := crypto.Read(v)
+		if err != nil {
+			return err
+		}
+		k := append(prefix, []byte(fmt.Sprintf("k%d", i))...)
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
+	}
+	// limit depth of subbuckets
+	s := 2 + rand.Intn(4)
+	if len(prefix) > (2*s + 1) {
+		return nil
+	}
+	n = 1 + rand.Intn(3)
+	for i := 0; i < n; i++ {
+		k := append(prefix, []byte(fmt.Sprintf("b%d", i))...)
+		sb, err := b.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		if err := fillBucket(sb, append(k, '.')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func chkdb(path string) ([]byte, error) {
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var buf bytes.Buffer
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walkBucket(b, name, nil, &buf)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func walkBucket(parent *bolt.Bucket, k []byte, v []byte, w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%d:%x=%x\n", parent.Sequence(), k, v); err != nil {
+		return err
+	}
+
+	// not a bucket, exit.
+	if v != nil {
+		return nil
+	}
+	return parent.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return walkBucket(parent.Bucket(k), k, nil, w)
+		}
+		return walkBucket(parent, k, v, w)
+	})
+}
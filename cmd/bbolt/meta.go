@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unsafe"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MetaCommand represents the "meta" command execution.
+type MetaCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
+}
+
+// newMetaCommand returns a MetaCommand.
+func newMetaCommand(m *Main) *MetaCommand {
+	return &MetaCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+		Format: m.Format,
+	}
+}
+
+// metaStatusJSON is printed for each meta page by the meta command.
+type metaStatusJSON struct {
+	PageID     int    `json:"page_id"`
+	Active     bool   `json:"active"`
+	Error      string `json:"error,omitempty"`
+	MagicOK    bool   `json:"magic_ok"`
+	VersionOK  bool   `json:"version_ok"`
+	ChecksumOK bool   `json:"checksum_ok"`
+	*metaJSON
+}
+
+// Run executes the command.
+func (cmd *MetaCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	dstPath := fs.String("o", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Copy the database as of the older meta page.
+	if *dstPath != "" {
+		return cmd.copyOlder(path, *dstPath)
+	}
+
+	// Read both meta pages.
+	var metas []*metaStatusJSON
+	for id := 0; id < 2; id++ {
+		p, buf, err := ReadPage(path, id)
+		if err != nil {
+			return err
+		}
+		s := newMetaStatusJSON(id, buf)
+		if p.Type() != "meta" {
+			s.Error = fmt.Sprintf("invalid page type: %s", p.Type())
+		}
+		metas = append(metas, s)
+	}
+
+	// The active meta page is the valid one with the highest txid.
+	if active, _, err := activeMeta(path); err == nil {
+		metas[active].Active = true
+	}
+
+	if cmd.Format == "json" {
+		return writeJSON(cmd.Stdout, metas)
+	}
+	for i, m := range metas {
+		if i > 0 {
+			fmt.Fprintln(cmd.Stdout)
+		}
+		cmd.printMeta(m)
+	}
+	return nil
+}
+
+// newMetaStatusJSON returns the description of meta page id held in buf along
+// with its validation status.
+func newMetaStatusJSON(id int, buf []byte) *metaStatusJSON {
+	m := (*meta)(unsafe.Pointer(&buf[PageHeaderSize]))
+	s := &metaStatusJSON{
+		PageID:     id,
+		MagicOK:    m.magic == magic,
+		VersionOK:  m.version == version,
+		ChecksumOK: m.checksum == 0 || m.checksum == m.sum64(),
+		metaJSON:   newMetaJSON(buf),
+	}
+	switch {
+	case !s.MagicOK:
+		s.Error = bolt.ErrInvalid.Error()
+	case !s.VersionOK:
+		s.Error = bolt.ErrVersionMismatch.Error()
+	case !s.ChecksumOK:
+		s.Error = bolt.ErrChecksum.Error()
+	}
+	return s
+}
+
+// printMeta prints a meta page and its validation status.
+func (cmd *MetaCommand) printMeta(m *metaStatusJSON) {
+	status := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+		return "invalid"
+	}
+
+	var state string
+	switch {
+	case m.Error != "":
+		state = " (invalid: " + m.Error + ")"
+	case m.Active:
+		state = " (active)"
+	}
+	fmt.Fprintf(cmd.Stdout, "Meta Page:  %d%s\n", m.PageID, state)
+	fmt.Fprintf(cmd.Stdout, "Magic:      %s (%s)\n", m.Magic, status(m.MagicOK))
+	fmt.Fprintf(cmd.Stdout, "Version:    %d (%s)\n", m.Version, status(m.VersionOK))
+	fmt.Fprintf(cmd.Stdout, "Page Size:  %d bytes\n", m.PageSize)
+	fmt.Fprintf(cmd.Stdout, "Flags:      %08x\n", m.Flags)
+	fmt.Fprintf(cmd.Stdout, "Root:       <pgid=%d>\n", m.Root)
+	fmt.Fprintf(cmd.Stdout, "Freelist:   <pgid=%d>\n", m.Freelist)
+	fmt.Fprintf(cmd.Stdout, "HWM:        <pgid=%d>\n", m.HWM)
+	fmt.Fprintf(cmd.Stdout, "Txn ID:     %d\n", m.TxID)
+	fmt.Fprintf(cmd.Stdout, "Checksum:   %s (%s)\n", m.Checksum, status(m.ChecksumOK))
+}
+
+// copyOlder writes a copy of the database at path as of its older meta page
+// to dstPath.
+func (cmd *MetaCommand) copyOlder(path, dstPath string) error {
+	if _, err := os.Stat(dstPath); err == nil {
+		return fmt.Errorf("destination file already exists: %s", dstPath)
+	}
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true, OlderMeta: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if err := tx.CopyFile(dstPath, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Stdout, "Copied database as of txid %d to %s\n", tx.ID(), dstPath)
+		return nil
+	})
+}
+
+// Usage returns the help message.
+func (cmd *MetaCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt meta [options] PATH
+
+Meta prints both meta pages of the database at PATH: their magic, version and
+checksum along with their validation status, their page size, flags, root
+bucket page, freelist page, high water mark and transaction id.
+
+The database opens with the valid meta page with the highest transaction id,
+which is marked as active. The other meta page describes the database before
+the last committed transaction.
+
+Additional options include:
+
+	-o DST
+		Write a copy of the database as of the older meta page to DST,
+		which rolls back the last committed transaction. The database
+		at PATH is not modified.
+`, "\n")
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ServeCommand represents the "serve" command execution.
+type ServeCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newServeCommand returns a ServeCommand.
+func newServeCommand(m *Main) *ServeCommand {
+	return &ServeCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ServeCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	addr := fs.String("addr", "localhost:8080", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path. Options following it would be silently ignored.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("unexpected argument after path: %s (options must precede it)", fs.Arg(1))
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true, RawKeyOrder: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Fprintf(cmd.Stdout, "Serving %s on http://%s/\n", path, *addr)
+	return http.ListenAndServe(*addr, NewServeHandler(db, path))
+}
+
+// Usage returns the help message.
+func (cmd *ServeCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt serve [options] PATH
+
+Serve opens the database at PATH in read-only mode and serves a web page to
+browse its buckets, keys, values, statistics and pages, along with the JSON
+endpoints used by the page:
+
+	GET /api/info
+		Page size, transaction id and database statistics.
+	GET /api/keys?bucket=B&seek=K&limit=N&format=F
+		Up to N keys (default=100, max=1000) of the (sub)bucket B,
+		starting at K, and the key to seek to for the next page.
+	GET /api/value?bucket=B&key=K&format=F
+		The value of key K in the (sub)bucket B.
+	GET /api/stats?bucket=B
+		Statistics of the (sub)bucket B, or of all buckets.
+	GET /api/page?id=N
+		The type and content of page N.
+
+Bucket names and keys in parameters and responses are base64 encoded. The
+bucket parameter is repeated for each level of nested bucket. F is the format
+of the "display" fields: ascii-encoded|hex|base64|bytes (default=ascii-encoded).
+
+Additional options include:
+
+	-addr ADDR
+		Address to listen on. (default=localhost:8080)
+
+The database file stays locked against writers until the server stops.
+`, "\n")
+}
+
+// Maximum and default number of keys returned by /api/keys.
+const (
+	serveMaxLimit     = 1000
+	serveDefaultLimit = 100
+)
+
+// httpError is an error with an HTTP status code.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+// badRequest returns an httpError caused by invalid request parameters.
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// server serves a read-only database over HTTP.
+type server struct {
+	db   *bolt.DB
+	path string
+	mux  *http.ServeMux
+}
+
+// NewServeHandler returns the handler of the serve command for db, opened
+// from the file at path.
+func NewServeHandler(db *bolt.DB, path string) http.Handler {
+	s := &server{db: db, path: path, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/api/info", s.handleInfo)
+	s.mux.HandleFunc("/api/keys", s.handleKeys)
+	s.mux.HandleFunc("/api/value", s.handleValue)
+	s.mux.HandleFunc("/api/stats", s.handleStats)
+	s.mux.HandleFunc("/api/page", s.handlePage)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = io.WriteString(w, serveIndexHTML)
+}
+
+func (s *server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	s.view(w, func(tx *bolt.Tx) (interface{}, error) {
+		return map[string]interface{}{
+			"path":      s.path,
+			"page_size": s.db.Info().PageSize,
+			"txid":      tx.ID(),
+			"size":      tx.Size(),
+			"stats":     s.db.Stats(),
+		}, nil
+	})
+}
+
+// serveEntryJSON is a key of a bucket returned by /api/keys.
+type serveEntryJSON struct {
+	Key       []byte `json:"key"`
+	Display   string `json:"display"`
+	Bucket    bool   `json:"bucket"`
+	ValueSize int    `json:"value_size"`
+}
+
+func (s *server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := serveDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeHTTPError(w, badRequest("invalid limit: %s", v))
+			return
+		} else if n < serveMaxLimit {
+			limit = n
+		} else {
+			limit = serveMaxLimit
+		}
+	}
+
+	s.view(w, func(tx *bolt.Tx) (interface{}, error) {
+		path, b, err := requestBucket(tx, r)
+		if err != nil {
+			return nil, err
+		}
+		seek, err := requestBytes(r, "seek")
+		if err != nil {
+			return nil, err
+		}
+		format := requestFormat(r)
+
+		c := b.Cursor()
+		k, v := c.First()
+		if seek != nil {
+			k, v = c.Seek(seek)
+		}
+		entries := []serveEntryJSON{}
+		for ; k != nil && len(entries) < limit; k, v = c.Next() {
+			display, err := formatBytes(k, format)
+			if err != nil {
+				return nil, badRequest("%s", err)
+			}
+			e := serveEntryJSON{Key: k, Display: display, Bucket: v == nil, ValueSize: len(v)}
+			if vb, ok := b.(*bolt.Bucket); ok && v == nil {
+				// Values set by PutReader are returned as nil, like buckets.
+				if vr := vb.GetReader(k); vr != nil {
+					size, err := vr.Seek(0, io.SeekEnd)
+					if err != nil {
+						return nil, err
+					}
+					e.Bucket, e.ValueSize = false, int(size)
+				}
+			}
+			entries = append(entries, e)
+		}
+		return map[string]interface{}{
+			"bucket":  path,
+			"entries": entries,
+			"next":    k,
+		}, nil
+	})
+}
+
+func (s *server) handleValue(w http.ResponseWriter, r *http.Request) {
+	s.view(w, func(tx *bolt.Tx) (interface{}, error) {
+		_, b, err := requestBucket(tx, r)
+		if err != nil {
+			return nil, err
+		}
+		key, err := requestBytes(r, "key")
+		if err != nil {
+			return nil, err
+		} else if key == nil {
+			return nil, badRequest("key required")
+		}
+
+		k, v := b.Cursor().Seek(key)
+		if !bytes.Equal(k, key) {
+			return nil, ErrKeyNotFound
+		} else if v, err = entryValue(b, k, v); err != nil {
+			return nil, err
+		} else if v == nil {
+			return nil, badRequest("%s", bolt.ErrIncompatibleValue)
+		}
+		display, err := formatBytes(v, requestFormat(r))
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+		return map[string]interface{}{
+			"key":     k,
+			"value":   v,
+			"display": display,
+			"size":    len(v),
+		}, nil
+	})
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.view(w, func(tx *bolt.Tx) (interface{}, error) {
+		path, b, err := requestBucket(tx, r)
+		if err != nil {
+			return nil, err
+		}
+
+		// Without a bucket, add up the stats of all buckets.
+		var stats bolt.BucketStats
+		if bucket, ok := b.(*bolt.Bucket); ok {
+			stats = bucket.Stats()
+		} else if err := tx.ForEach(func(_ []byte, b *bolt.Bucket) error {
+			stats.Add(b.Stats())
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"bucket": path, "stats": stats}, nil
+	})
+}
+
+func (s *server) handlePage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id < 0 {
+		writeHTTPError(w, badRequest("invalid page id: %s", r.URL.Query().Get("id")))
+		return
+	}
+
+	s.view(w, func(tx *bolt.Tx) (interface{}, error) {
+		info, err := tx.Page(id)
+		if err != nil {
+			return nil, err
+		} else if info == nil {
+			return nil, badRequest("page %d is above the high water mark", id)
+		}
+		out := map[string]interface{}{
+			"id":       info.ID,
+			"type":     info.Type,
+			"count":    info.Count,
+			"overflow": info.OverflowCount,
+			"pending":  info.Pending,
+		}
+
+		// Free pages hold stale content.
+		if info.Type != "free" {
+			_, buf, err := ReadPage(s.path, id)
+			if err != nil {
+				return nil, err
+			}
+			out["page"] = newPageJSON(buf)
+		}
+		return out, nil
+	})
+}
+
+// view runs fn in a read-only transaction and writes its result as JSON.
+func (s *server) view(w http.ResponseWriter, fn func(tx *bolt.Tx) (interface{}, error)) {
+	// Encode within the transaction since keys and values point to its pages.
+	var buf bytes.Buffer
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := fn(tx)
+		if err != nil {
+			return err
+		}
+		return writeJSON(&buf, v)
+	}); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = buf.WriteTo(w)
+}
+
+// writeHTTPError writes err as a JSON object with a status code matching it.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*httpError); ok {
+		status = e.status
+	} else if err == ErrBucketNotFound || err == ErrKeyNotFound {
+		status = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = writeJSON(w, map[string]string{"error": err.Error()})
+}
+
+// requestBytes returns the base64 decoded query parameter name, or nil if it
+// is missing.
+func requestBytes(r *http.Request, name string) ([]byte, error) {
+	v, ok := r.URL.Query()[name]
+	if !ok {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v[0])
+	if err != nil {
+		return nil, badRequest("invalid %s: %s", name, err)
+	}
+	return b, nil
+}
+
+// requestFormat returns the display format of the request.
+func requestFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	return "ascii-encoded"
+}
+
+// requestBucket returns the path given by the bucket query parameters and
+// the bucket at that path, or tx if the path is empty.
+func requestBucket(tx *bolt.Tx, r *http.Request) ([][]byte, bucketContainer, error) {
+	path := [][]byte{}
+	var names []string
+	for _, v := range r.URL.Query()["bucket"] {
+		name, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, nil, badRequest("invalid bucket: %s", err)
+		}
+		path = append(path, name)
+		names = append(names, string(name))
+	}
+	if len(names) == 0 {
+		return path, tx, nil
+	}
+	b, err := findBucket(tx, names, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return path, b, nil
+}
+
+// serveIndexHTML is the web page served by the serve command.
+const serveIndexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bbolt</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+pre, td.key { font-family: monospace; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+a { cursor: pointer; color: #06c; }
+#value { white-space: pre-wrap; word-break: break-all; background: #f4f4f4; padding: 8px; }
+</style>
+</head>
+<body>
+<h1 id="title">bbolt</h1>
+<p>
+Format: <select id="format" onchange="reload()">
+<option>ascii-encoded</option><option>hex</option><option>base64</option><option>bytes</option>
+</select>
+<a onclick="showStats()">Bucket stats</a> |
+Page: <input id="pageid" size="8"> <a onclick="showPage()">show</a>
+</p>
+<p id="path"></p>
+<table id="keys"><thead><tr><th>Key</th><th>Value size</th></tr></thead><tbody></tbody></table>
+<p><a id="more" onclick="loadKeys(next, false)">Next page</a></p>
+<pre id="value"></pre>
+<script>
+var path = [], next = null;
+
+function query(extra) {
+	var q = path.map(function(b) { return "bucket=" + encodeURIComponent(b); });
+	q.push("format=" + document.getElementById("format").value);
+	return q.concat(extra || []).join("&");
+}
+
+function get(url, fn) {
+	fetch(url).then(function(r) { return r.json(); }).then(function(v) {
+		if (v.error) { show(v.error); } else { fn(v); }
+	});
+}
+
+function show(v) {
+	document.getElementById("value").textContent = typeof v === "string" ? v : JSON.stringify(v, null, 2);
+}
+
+function cell(row, text, fn) {
+	var td = row.insertCell();
+	if (fn) { var a = document.createElement("a"); a.textContent = text; a.onclick = fn; td.appendChild(a); }
+	else { td.textContent = text; }
+	return td;
+}
+
+function renderPath() {
+	var p = document.getElementById("path");
+	p.textContent = "";
+	var names = [{name: "(root)", depth: 0}];
+	path.forEach(function(b, i) { names.push({name: atob(b), depth: i + 1}); });
+	names.forEach(function(n, i) {
+		if (i > 0) { p.appendChild(document.createTextNode(" / ")); }
+		var a = document.createElement("a");
+		a.textContent = n.name;
+		a.onclick = function() { path = path.slice(0, n.depth); reload(); };
+		p.appendChild(a);
+	});
+}
+
+function loadKeys(seek, clear) {
+	var extra = seek ? ["seek=" + encodeURIComponent(seek)] : [];
+	get("/api/keys?" + query(extra), function(v) {
+		var body = document.querySelector("#keys tbody");
+		if (clear) { body.textContent = ""; }
+		v.entries.forEach(function(e) {
+			var row = body.insertRow();
+			if (e.bucket) {
+				cell(row, e.display + "/", function() { path.push(e.key); reload(); }).className = "key";
+				cell(row, "bucket");
+			} else {
+				cell(row, e.display, function() { showValue(e.key); }).className = "key";
+				cell(row, e.value_size);
+			}
+		});
+		next = v.next;
+		document.getElementById("more").style.display = next ? "" : "none";
+	});
+}
+
+function showValue(key) {
+	get("/api/value?" + query(["key=" + encodeURIComponent(key)]), function(v) { show(v.display); });
+}
+
+function showStats() {
+	get("/api/stats?" + query(), show);
+}
+
+function showPage() {
+	get("/api/page?id=" + encodeURIComponent(document.getElementById("pageid").value), show);
+}
+
+function reload() {
+	renderPath();
+	show("");
+	loadKeys(null, true);
+}
+
+get("/api/info", function(v) {
+	document.getElementById("title").textContent = v.path;
+	show(v);
+});
+renderPath();
+loadKeys(null, true);
+</script>
+</body>
+</html>
+`
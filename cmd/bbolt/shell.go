@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrShellReadOnly is returned when a shell command would modify a
+	// database opened in read-only mode, or within a read-only transaction.
+	ErrShellReadOnly = errors.New("read-only mode")
+
+	// ErrShellNoBucket is returned when a shell command requires a bucket
+	// but the current bucket is the root.
+	ErrShellNoBucket = errors.New("no bucket selected, cd into a bucket first")
+
+	// ErrShellNoCursor is returned by next and prev before a seek.
+	ErrShellNoCursor = errors.New("no cursor position, seek first")
+)
+
+// ShellCommand represents the "shell" command execution.
+type ShellCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newShellCommand returns a ShellCommand.
+func newShellCommand(m *Main) *ShellCommand {
+	return &ShellCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ShellCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	readOnly := fs.Bool("readonly", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: *readOnly, RawKeyOrder: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s := &shell{cmd: cmd, db: db, path: path}
+	return s.run()
+}
+
+// Usage returns the help message.
+func (cmd *ShellCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt shell [options] PATH
+
+Shell opens the database at PATH and reads commands from stdin to browse and
+edit it. Type "help" for the list of commands.
+
+Arguments are separated by spaces. An argument may be written as a double
+quoted Go string, e.g. "a key\x00", to include spaces or arbitrary bytes.
+
+Unless a transaction is started with "begin", each command runs in its own
+transaction, which is committed right away.
+
+Additional options include:
+
+	-readonly
+		Open the database in read-only mode.
+`, "\n")
+}
+
+// shell holds the state of an interactive shell session.
+type shell struct {
+	cmd  *ShellCommand
+	db   *bolt.DB
+	path string
+
+	// tx is the explicit transaction started with "begin", if any.
+	tx *bolt.Tx
+
+	// buckets is the path of the current bucket.
+	buckets [][]byte
+
+	// cursor is the key the cursor is positioned on.
+	cursor []byte
+}
+
+// shellCommands lists the commands with their arguments and description.
+var shellCommands = []struct{ name, args, desc string }{
+	{"help", "", "print this list"},
+	{"cd", "[NAME...|..|/]", "change the current bucket"},
+	{"ls", "", "list the buckets and keys of the current bucket"},
+	{"get", "KEY", "print the value of a key"},
+	{"put", "KEY VALUE", "set the value of a key"},
+	{"del", "KEY", "delete a key"},
+	{"seek", "KEY", "move the cursor to the first key at or after KEY"},
+	{"next", "", "move the cursor to the next key"},
+	{"prev", "", "move the cursor to the previous key"},
+	{"stats", "", "print statistics of the current bucket"},
+	{"page", "ID...", "print pages as stored in the file"},
+	{"begin", "", "start a transaction"},
+	{"commit", "", "commit the current transaction"},
+	{"rollback", "", "roll back the current transaction"},
+	{"exit", "", "leave the shell, rolling back any open transaction"},
+}
+
+// run reads and executes commands until stdin is closed or exit is typed.
+func (s *shell) run() error {
+	defer func() {
+		if s.tx != nil {
+			_ = s.tx.Rollback()
+		}
+	}()
+
+	scanner := bufio.NewScanner(s.cmd.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), maxAllocSize)
+	for {
+		fmt.Fprint(s.cmd.Stdout, s.prompt())
+		if !scanner.Scan() {
+			fmt.Fprintln(s.cmd.Stdout)
+			return scanner.Err()
+		}
+
+		args, err := splitShellArgs(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(s.cmd.Stdout, "error: %s\n", err)
+			continue
+		} else if len(args) == 0 {
+			continue
+		}
+
+		if name := string(args[0]); name == "exit" || name == "quit" {
+			if s.tx != nil {
+				fmt.Fprintln(s.cmd.Stdout, "rolling back open transaction")
+			}
+			return nil
+		}
+		if err := s.exec(string(args[0]), args[1:]); err != nil {
+			fmt.Fprintf(s.cmd.Stdout, "error: %s\n", err)
+		}
+	}
+}
+
+// prompt returns the prompt showing the current bucket.
+func (s *shell) prompt() string {
+	names := make([]string, len(s.buckets))
+	for i, name := range s.buckets {
+		names[i] = formatShellBytes(name)
+	}
+	var tx string
+	if s.tx != nil {
+		tx = " (tx)"
+	}
+	return fmt.Sprintf("bbolt:/%s%s> ", strings.Join(names, "/"), tx)
+}
+
+// exec executes a single command.
+func (s *shell) exec(name string, args [][]byte) error {
+	switch name {
+	case "help":
+		for _, c := range shellCommands {
+			fmt.Fprintf(s.cmd.Stdout, "  %-30s %s\n", strings.TrimSpace(c.name+" "+c.args), c.desc)
+		}
+		return nil
+	case "cd":
+		return s.cd(args)
+	case "ls":
+		return s.ls()
+	case "get":
+		return s.get(args)
+	case "put":
+		return s.put(args)
+	case "del":
+		return s.del(args)
+	case "seek", "next", "prev":
+		return s.move(name, args)
+	case "stats":
+		return s.stats()
+	case "page":
+		pageArgs := []string{s.path}
+		for _, arg := range args {
+			pageArgs = append(pageArgs, string(arg))
+		}
+		return (&PageCommand{Stdin: s.cmd.Stdin, Stdout: s.cmd.Stdout, Stderr: s.cmd.Stderr}).Run(pageArgs...)
+	case "begin":
+		if s.tx != nil {
+			return errors.New("transaction already started")
+		}
+		tx, err := s.db.Begin(!s.db.IsReadOnly())
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+		return nil
+	case "commit", "rollback":
+		if s.tx == nil {
+			return errors.New("no transaction started")
+		}
+		tx := s.tx
+		s.tx = nil
+		if name == "commit" && tx.Writable() {
+			return tx.Commit()
+		}
+		return tx.Rollback()
+	default:
+		return fmt.Errorf("unknown command %q, type \"help\" for the list of commands", name)
+	}
+}
+
+// view runs fn in the explicit transaction if any, or else in a new
+// read-only transaction.
+func (s *shell) view(fn func(*bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+// update runs fn in the explicit transaction if any, or else in a new
+// read-write transaction.
+func (s *shell) update(fn func(*bolt.Tx) error) error {
+	if s.db.IsReadOnly() {
+		return ErrShellReadOnly
+	} else if s.tx != nil {
+		if !s.tx.Writable() {
+			return ErrShellReadOnly
+		}
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+// container returns the current bucket, or the transaction at the root.
+func (s *shell) container(tx *bolt.Tx) (bucketContainer, error) {
+	if len(s.buckets) == 0 {
+		return tx, nil
+	}
+	return s.bucket(tx)
+}
+
+// bucket returns the current bucket. It fails at the root.
+func (s *shell) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	if len(s.buckets) == 0 {
+		return nil, ErrShellNoBucket
+	}
+	b := tx.Bucket(s.buckets[0])
+	for _, name := range s.buckets[1:] {
+		if b == nil {
+			break
+		}
+		b = b.Bucket(name)
+	}
+	if b == nil {
+		return nil, ErrBucketNotFound
+	}
+	return b, nil
+}
+
+func (s *shell) cd(args [][]byte) error {
+	buckets := s.buckets
+	if len(args) == 0 {
+		buckets = nil
+	}
+	for _, arg := range args {
+		switch string(arg) {
+		case "/":
+			buckets = nil
+		case "..":
+			if len(buckets) > 0 {
+				buckets = buckets[:len(buckets)-1]
+			}
+		default:
+			buckets = append(buckets[:len(buckets):len(buckets)], arg)
+		}
+	}
+
+	// Ensure the new bucket exists before switching to it.
+	prev := s.buckets
+	s.buckets = buckets
+	if err := s.view(func(tx *bolt.Tx) error {
+		_, err := s.container(tx)
+		return err
+	}); err != nil {
+		s.buckets = prev
+		return err
+	}
+	s.cursor = nil
+	return nil
+}
+
+func (s *shell) ls() error {
+	return s.view(func(tx *bolt.Tx) error {
+		c, err := s.container(tx)
+		if err != nil {
+			return err
+		}
+		cur := c.Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			if v, err = entryValue(c, k, v); err != nil {
+				return err
+			} else if v == nil {
+				fmt.Fprintf(s.cmd.Stdout, "%s/\n", formatShellBytes(k))
+			} else {
+				fmt.Fprintln(s.cmd.Stdout, formatShellBytes(k))
+			}
+		}
+		return nil
+	})
+}
+
+func (s *shell) get(args [][]byte) error {
+	if len(args) != 1 {
+		return errors.New("usage: get KEY")
+	}
+	return s.view(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+		k, v := b.Cursor().Seek(args[0])
+		if !bytes.Equal(k, args[0]) {
+			return ErrKeyNotFound
+		} else if v, err = entryValue(b, k, v); err != nil {
+			return err
+		} else if v == nil {
+			if b.Bucket(args[0]) != nil {
+				return fmt.Errorf("%s is a bucket", formatShellBytes(args[0]))
+			}
+			return ErrKeyNotFound
+		}
+		fmt.Fprintln(s.cmd.Stdout, formatShellBytes(v))
+		return nil
+	})
+}
+
+func (s *shell) put(args [][]byte) error {
+	if len(args) != 2 {
+		return errors.New("usage: put KEY VALUE")
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+		return b.Put(args[0], args[1])
+	})
+}
+
+func (s *shell) del(args [][]byte) error {
+	if len(args) != 1 {
+		return errors.New("usage: del KEY")
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().Seek(args[0]); !bytes.Equal(k, args[0]) {
+			return ErrKeyNotFound
+		}
+		return b.Delete(args[0])
+	})
+}
+
+// move positions the cursor and prints the key and value it lands on. The
+// position is kept as a key, so that it survives across transactions.
+func (s *shell) move(name string, args [][]byte) error {
+	if name == "seek" && len(args) != 1 {
+		return errors.New("usage: seek KEY")
+	} else if name != "seek" && s.cursor == nil {
+		return ErrShellNoCursor
+	}
+	return s.view(func(tx *bolt.Tx) error {
+		c, err := s.container(tx)
+		if err != nil {
+			return err
+		}
+		cur := c.Cursor()
+
+		var k, v []byte
+		switch name {
+		case "seek":
+			k, v = cur.Seek(args[0])
+		case "next":
+			if k, v = cur.Seek(s.cursor); bytes.Equal(k, s.cursor) {
+				k, v = cur.Next()
+			}
+		case "prev":
+			if k, _ = cur.Seek(s.cursor); k == nil {
+				k, v = cur.Last()
+			} else {
+				k, v = cur.Prev()
+			}
+		}
+		if k == nil {
+			fmt.Fprintln(s.cmd.Stdout, "(end)")
+			return nil
+		}
+
+		s.cursor = append([]byte(nil), k...)
+		if v, err = entryValue(c, k, v); err != nil {
+			return err
+		} else if v == nil {
+			fmt.Fprintf(s.cmd.Stdout, "%s/\n", formatShellBytes(k))
+		} else {
+			fmt.Fprintf(s.cmd.Stdout, "%s = %s\n", formatShellBytes(k), formatShellBytes(v))
+		}
+		return nil
+	})
+}
+
+func (s *shell) stats() error {
+	return s.view(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+		st := b.Stats()
+		w := s.cmd.Stdout
+		fmt.Fprintf(w, "Keys:             %d\n", st.KeyN)
+		fmt.Fprintf(w, "Depth:            %d\n", st.Depth)
+		fmt.Fprintf(w, "Branch pages:     %d (%d overflow)\n", st.BranchPageN, st.BranchOverflowN)
+		fmt.Fprintf(w, "Leaf pages:       %d (%d overflow)\n", st.LeafPageN, st.LeafOverflowN)
+		fmt.Fprintf(w, "Buckets:          %d (%d inline)\n", st.BucketN, st.InlineBucketN)
+		fmt.Fprintf(w, "Branch bytes:     %d in use of %d\n", st.BranchInuse, st.BranchAlloc)
+		fmt.Fprintf(w, "Leaf bytes:       %d in use of %d\n", st.LeafInuse, st.LeafAlloc)
+		return nil
+	})
+}
+
+// splitShellArgs splits a command line into arguments separated by spaces.
+// Arguments starting with a double quote are unquoted as Go strings.
+func splitShellArgs(line string) ([][]byte, error) {
+	var args [][]byte
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted argument: %s", line)
+			}
+			arg, _ := strconv.Unquote(quoted)
+			args = append(args, []byte(arg))
+			line = line[len(quoted):]
+			continue
+		}
+
+		end := strings.IndexFunc(line, unicode.IsSpace)
+		if end == -1 {
+			end = len(line)
+		}
+		args = append(args, []byte(line[:end]))
+		line = line[end:]
+	}
+}
+
+// formatShellBytes returns b as is if printable, or else as a quoted string.
+func formatShellBytes(b []byte) string {
+	if isPrintable(string(b)) && !strings.ContainsAny(string(b), " \"/") {
+		return string(b)
+	}
+	return strconv.Quote(string(b))
+}
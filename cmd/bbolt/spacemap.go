@@ -0,0 +1,330 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SpaceMapCommand represents the "space-map" command execution.
+type SpaceMapCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Format is the output format. One of: text, json.
+	Format string
+}
+
+// newSpaceMapCommand returns a SpaceMapCommand.
+func newSpaceMapCommand(m *Main) *SpaceMapCommand {
+	return &SpaceMapCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+		Format: m.Format,
+	}
+}
+
+// Page classes of the space map, in legend order.
+var spaceMapClasses = []struct {
+	char byte
+	name string
+}{
+	{'M', "meta"},
+	{'F', "freelist"},
+	{'B', "branch"},
+	{'L', "leaf"},
+	{'O', "overflow"},
+	{'.', "free"},
+	{'?', "unknown"},
+}
+
+// spaceMapJSON is printed by the space-map command.
+type spaceMapJSON struct {
+	PageSize           int            `json:"page_size"`
+	FileSize           int64          `json:"file_size"`
+	PageCount          int            `json:"page_count"`
+	PagesPerCell       int            `json:"pages_per_cell"`
+	Map                []string       `json:"map"`
+	Counts             map[string]int `json:"counts"`
+	FreeSpans          []freeSpanJSON `json:"free_spans"`
+	InuseSize          int64          `json:"inuse_size"`
+	EstimatedSize      int64          `json:"estimated_size"`
+	ReclaimableSize    int64          `json:"reclaimable_size"`
+	ReclaimablePercent int            `json:"reclaimable_percent"`
+}
+
+// freeSpanJSON is a bucket of the histogram of free span lengths. It counts
+// the spans of Min to Max consecutive free pages.
+type freeSpanJSON struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Spans int `json:"spans"`
+	Pages int `json:"pages"`
+}
+
+// Run executes the command.
+func (cmd *SpaceMapCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	width := fs.Int("width", 64, "")
+	rows := fs.Int("rows", 32, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if *width <= 0 || *rows <= 0 {
+		return fmt.Errorf("width and rows must be positive")
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{RawKeyOrder: true})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	return db.View(func(tx *bolt.Tx) error {
+		out := spaceMapJSON{
+			PageSize: db.Info().PageSize,
+			FileSize: fi.Size(),
+			Counts:   map[string]int{},
+		}
+
+		// Classify every page below the high water mark.
+		var classes []byte
+		for id := 0; ; {
+			p, err := tx.Page(id)
+			if err != nil {
+				return &PageError{ID: id, Err: err}
+			} else if p == nil {
+				break
+			}
+
+			switch p.Type {
+			case "free":
+				classes = append(classes, '.')
+				id++
+				continue
+			case "meta":
+				classes = append(classes, 'M')
+			case "freelist":
+				classes = append(classes, 'F')
+			case "branch":
+				classes = append(classes, 'B')
+			case "leaf":
+				classes = append(classes, 'L')
+			default:
+				classes = append(classes, '?')
+			}
+			for i := 0; i < p.OverflowCount; i++ {
+				classes = append(classes, 'O')
+			}
+			id += 1 + p.OverflowCount
+		}
+		out.PageCount = len(classes)
+		for _, c := range classes {
+			out.Counts[spaceMapClassName(c)]++
+		}
+
+		out.PagesPerCell = (len(classes) + *width**rows - 1) / (*width * *rows)
+		if out.PagesPerCell == 0 {
+			out.PagesPerCell = 1
+		}
+		out.Map = spaceMapRows(classes, out.PagesPerCell, *width)
+		out.FreeSpans = freeSpanHistogram(classes)
+
+		// Estimate the size of a compacted copy from the bytes in use by
+		// the buckets, plus the meta, freelist and root bucket pages.
+		if err := tx.ForEach(func(_ []byte, b *bolt.Bucket) error {
+			s := b.Stats()
+			out.InuseSize += int64(s.BranchInuse + s.LeafInuse)
+			return nil
+		}); err != nil {
+			return err
+		}
+		pageSize := int64(out.PageSize)
+		out.EstimatedSize = ((out.InuseSize+pageSize-1)/pageSize + 4) * pageSize
+		if out.ReclaimableSize = out.FileSize - out.EstimatedSize; out.ReclaimableSize < 0 {
+			out.ReclaimableSize = 0
+		}
+		if out.FileSize > 0 {
+			out.ReclaimablePercent = int(out.ReclaimableSize * 100 / out.FileSize)
+		}
+
+		if cmd.Format == "json" {
+			return writeJSON(cmd.Stdout, &out)
+		}
+		cmd.print(&out)
+		return nil
+	})
+}
+
+// print prints the space map in text form.
+func (cmd *SpaceMapCommand) print(out *spaceMapJSON) {
+	w := cmd.Stdout
+	fmt.Fprintf(w, "Pages:      %d (%d bytes each)\n", out.PageCount, out.PageSize)
+	fmt.Fprintf(w, "File Size:  %d bytes\n", out.FileSize)
+	fmt.Fprintln(w)
+
+	var legend []string
+	for _, c := range spaceMapClasses {
+		legend = append(legend, fmt.Sprintf("%c=%s", c.char, c.name))
+	}
+	fmt.Fprintf(w, "Legend: %s\n", strings.Join(legend, " "))
+	fmt.Fprintf(w, "Each character shows the most frequent class of %d page(s).\n", out.PagesPerCell)
+	fmt.Fprintln(w)
+	for i, row := range out.Map {
+		fmt.Fprintf(w, "%-10d %s\n", i*len(out.Map[0])*out.PagesPerCell, row)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Page classes")
+	for _, c := range spaceMapClasses {
+		if n := out.Counts[c.name]; n > 0 {
+			fmt.Fprintf(w, "\t%-10s %d (%d%%)\n", c.name+":", n, n*100/out.PageCount)
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Free span lengths")
+	if len(out.FreeSpans) == 0 {
+		fmt.Fprintln(w, "\tno free pages")
+	}
+	for _, s := range out.FreeSpans {
+		fmt.Fprintf(w, "\t%-12s %d spans, %d pages\n", fmt.Sprintf("%d-%d:", s.Min, s.Max), s.Spans, s.Pages)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Compaction estimate")
+	fmt.Fprintf(w, "\tBytes in use by buckets: %d\n", out.InuseSize)
+	fmt.Fprintf(w, "\tEstimated compacted size: %d bytes\n", out.EstimatedSize)
+	fmt.Fprintf(w, "\tEstimated reclaimable space: %d bytes (%d%%)\n", out.ReclaimableSize, out.ReclaimablePercent)
+}
+
+// spaceMapClassName returns the name of a page class character.
+func spaceMapClassName(c byte) string {
+	for _, class := range spaceMapClasses {
+		if class.char == c {
+			return class.name
+		}
+	}
+	return "unknown"
+}
+
+// spaceMapRows renders the page classes as rows of width cells, each cell
+// showing the most frequent class of its pagesPerCell pages.
+func spaceMapRows(classes []byte, pagesPerCell, width int) []string {
+	var cells []byte
+	for i := 0; i < len(classes); i += pagesPerCell {
+		end := i + pagesPerCell
+		if end > len(classes) {
+			end = len(classes)
+		}
+
+		var counts [256]int
+		var best byte
+		for _, c := range classes[i:end] {
+			if counts[c]++; counts[c] > counts[best] {
+				best = c
+			}
+		}
+		cells = append(cells, best)
+	}
+
+	var rows []string
+	for i := 0; i < len(cells); i += width {
+		end := i + width
+		if end > len(cells) {
+			end = len(cells)
+		}
+		rows = append(rows, string(cells[i:end]))
+	}
+	return rows
+}
+
+// freeSpanHistogram returns the histogram of the lengths of the runs of
+// consecutive free pages, in power of two buckets.
+func freeSpanHistogram(classes []byte) []freeSpanJSON {
+	var hist []freeSpanJSON
+	add := func(n int) {
+		i := 0
+		for 1<<uint(i+1) <= n {
+			i++
+		}
+		for len(hist) <= i {
+			min := 1 << uint(len(hist))
+			hist = append(hist, freeSpanJSON{Min: min, Max: 2*min - 1})
+		}
+		hist[i].Spans++
+		hist[i].Pages += n
+	}
+
+	var n int
+	for _, c := range classes {
+		if c == '.' {
+			n++
+			continue
+		}
+		if n > 0 {
+			add(n)
+		}
+		n = 0
+	}
+	if n > 0 {
+		add(n)
+	}
+
+	// Drop empty buckets.
+	out := []freeSpanJSON{}
+	for _, s := range hist {
+		if s.Spans > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Usage returns the help message.
+func (cmd *SpaceMapCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt space-map [options] PATH
+
+Space-map prints a map of the pages of the database at PATH, up to its high
+water mark. Each character of the map stands for one or more consecutive
+pages and shows their most frequent class: meta, freelist, branch, leaf,
+overflow or free. Each row starts with the id of its first page.
+
+The map is followed by the number of pages of each class, the histogram of
+the lengths of the runs of consecutive free pages, and an estimate of the
+size of a compacted copy of the database and of the space it would reclaim.
+The estimate assumes full pages, so compaction usually reclaims a bit less.
+
+Additional options include:
+
+	-width N
+		Number of characters per row. (default=64)
+	-rows N
+		Maximum number of rows. Each character stands for as many pages
+		as needed to fit the map. (default=32)
+`, "\n")
+}
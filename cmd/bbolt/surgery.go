@@ -0,0 +1,593 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+var (
+	// ErrSurgeryOutputRequired is returned when a surgery subcommand is not
+	// given the path of the copy to operate on.
+	ErrSurgeryOutputRequired = errors.New("output file required")
+
+	// ErrSurgeryOutputIsSource is returned when the output of a surgery
+	// subcommand is the source database itself.
+	ErrSurgeryOutputIsSource = errors.New("output file must differ from the source file")
+)
+
+// SurgeryCommand represents the "surgery" command execution.
+type SurgeryCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newSurgeryCommand returns a SurgeryCommand.
+func newSurgeryCommand(m *Main) *SurgeryCommand {
+	return &SurgeryCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *SurgeryCommand) Run(args ...string) error {
+	// Require a subcommand at the beginning.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Execute subcommand.
+	switch args[0] {
+	case "help":
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	case "revert-meta-page":
+		return cmd.runRevertMetaPage(args[1:]...)
+	case "copy-page":
+		return cmd.runCopyPage(args[1:]...)
+	case "clear-page":
+		return cmd.runClearPage(args[1:]...)
+	case "clear-page-elements":
+		return cmd.runClearPageElements(args[1:]...)
+	case "abandon-freelist":
+		return cmd.runAbandonFreelist(args[1:]...)
+	default:
+		return ErrUnknownCommand
+	}
+}
+
+// parse parses the arguments of a subcommand and copies the source database
+// to the output path, which is returned. Subcommand specific flags must be
+// defined on fs before calling parse.
+func (cmd *SurgeryCommand) parse(fs *flag.FlagSet, args []string, usage string) (string, error) {
+	fs.SetOutput(io.Discard)
+	dstPath := fs.String("o", "", "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, usage)
+		return "", ErrUsage
+	} else if err != nil {
+		return "", err
+	} else if *dstPath == "" {
+		return "", ErrSurgeryOutputRequired
+	}
+
+	// Require database path.
+	srcPath := fs.Arg(0)
+	if srcPath == "" {
+		return "", ErrPathRequired
+	} else if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+		return "", ErrFileNotFound
+	}
+
+	if err := copyFile(srcPath, *dstPath); err != nil {
+		return "", err
+	}
+	return *dstPath, nil
+}
+
+func (cmd *SurgeryCommand) runRevertMetaPage(args ...string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	path, err := cmd.parse(fs, args, cmd.revertMetaPageUsage())
+	if err != nil {
+		return err
+	}
+
+	if err := revertMetaPage(path); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.Stdout, "The meta page is reverted.")
+	return nil
+}
+
+func (cmd *SurgeryCommand) runCopyPage(args ...string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	from := fs.Int("from-page", -1, "")
+	to := fs.Int("to-page", -1, "")
+	path, err := cmd.parse(fs, args, cmd.copyPageUsage())
+	if err != nil {
+		return err
+	} else if *from < 0 || *to < 0 {
+		return ErrPageIDRequired
+	}
+
+	if err := copyPage(path, pgid(*from), pgid(*to)); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.Stdout, "The page %d was copied to page %d.\n", *from, *to)
+	return nil
+}
+
+func (cmd *SurgeryCommand) runClearPage(args ...string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	pageID := fs.Int("page", -1, "")
+	path, err := cmd.parse(fs, args, cmd.clearPageUsage())
+	if err != nil {
+		return err
+	} else if *pageID < 0 {
+		return ErrPageIDRequired
+	}
+
+	abandoned, err := clearPageElements(path, pgid(*pageID), 0, -1)
+	if err != nil {
+		return err
+	}
+	cmd.printAbandonedWarning(abandoned)
+	fmt.Fprintf(cmd.Stdout, "The page %d was cleared.\n", *pageID)
+	return nil
+}
+
+func (cmd *SurgeryCommand) runClearPageElements(args ...string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	pageID := fs.Int("page", -1, "")
+	start := fs.Int("from-index", 0, "")
+	end := fs.Int("to-index", -1, "")
+	path, err := cmd.parse(fs, args, cmd.clearPageElementsUsage())
+	if err != nil {
+		return err
+	} else if *pageID < 0 {
+		return ErrPageIDRequired
+	}
+
+	abandoned, err := clearPageElements(path, pgid(*pageID), *start, *end)
+	if err != nil {
+		return err
+	}
+	cmd.printAbandonedWarning(abandoned)
+	fmt.Fprintf(cmd.Stdout, "The elements of page %d were cleared.\n", *pageID)
+	return nil
+}
+
+func (cmd *SurgeryCommand) runAbandonFreelist(args ...string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	path, err := cmd.parse(fs, args, cmd.abandonFreelistUsage())
+	if err != nil {
+		return err
+	}
+
+	if err := abandonFreelist(path); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.Stdout, "The freelist was abandoned.")
+	return nil
+}
+
+// printAbandonedWarning warns that pages are no longer referenced by the
+// tree nor by the freelist.
+func (cmd *SurgeryCommand) printAbandonedWarning(abandoned bool) {
+	if abandoned {
+		fmt.Fprintln(cmd.Stdout, "WARNING: some pages are no longer referenced by any bucket nor by the freelist.")
+		fmt.Fprintln(cmd.Stdout, "Run \"bbolt surgery abandon-freelist\" on the output to reclaim them.")
+	}
+}
+
+// Usage returns the help message.
+func (cmd *SurgeryCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery command [options] -o DST SRC
+
+Surgery copies the database at SRC path to DST path and then modifies pages
+of the copy to repair the damage. The original database is left untouched.
+
+The commands are:
+
+    revert-meta-page      revert the active meta page to the previous one
+    copy-page             copy a page over another page
+    clear-page            remove all elements of a leaf or branch page
+    clear-page-elements   remove a range of elements of a leaf or branch page
+    abandon-freelist      force the freelist to be rebuilt on next open
+
+Use "bbolt surgery [command] -h" for more information about a command.
+`, "\n")
+}
+
+func (cmd *SurgeryCommand) revertMetaPageUsage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery revert-meta-page -o DST SRC
+
+RevertMetaPage copies the database at SRC path to DST path and overwrites the
+active meta page of the copy with the other meta page, so the copy opens as of
+the previous transaction.
+`, "\n")
+}
+
+func (cmd *SurgeryCommand) copyPageUsage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery copy-page -o DST -from-page ID -to-page ID SRC
+
+CopyPage copies the database at SRC path to DST path and overwrites a page of
+the copy with another page, including its overflow pages.
+
+Additional options include:
+
+	-from-page ID
+		Specifies the page to copy.
+
+	-to-page ID
+		Specifies the page to overwrite.
+`, "\n")
+}
+
+func (cmd *SurgeryCommand) clearPageUsage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery clear-page -o DST -page ID SRC
+
+ClearPage copies the database at SRC path to DST path and removes all elements
+of a leaf or branch page of the copy. A cleared branch page becomes an empty
+leaf page.
+
+Additional options include:
+
+	-page ID
+		Specifies the page to clear.
+`, "\n")
+}
+
+func (cmd *SurgeryCommand) clearPageElementsUsage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery clear-page-elements -o DST -page ID [options] SRC
+
+ClearPageElements copies the database at SRC path to DST path and removes the
+elements with an index in [from-index, to-index) from a leaf or branch page
+of the copy.
+
+Additional options include:
+
+	-page ID
+		Specifies the page to modify.
+
+	-from-index NUM
+		Specifies the index of the first element to remove.
+		Defaults to 0.
+
+	-to-index NUM
+		Specifies the index after the last element to remove. A value of
+		-1 removes all elements up to the end of the page.
+		Defaults to -1.
+`, "\n")
+}
+
+func (cmd *SurgeryCommand) abandonFreelistUsage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery abandon-freelist -o DST SRC
+
+AbandonFreelist copies the database at SRC path to DST path and marks the valid
+meta pages of the copy as having no freelist. The freelist is rebuilt by scanning
+the database the next time the copy is opened.
+`, "\n")
+}
+
+// copyFile copies the file at srcPath to dstPath, replacing any existing file.
+func copyFile(srcPath, dstPath string) error {
+	if a, err := filepath.Abs(srcPath); err != nil {
+		return err
+	} else if b, err := filepath.Abs(dstPath); err != nil {
+		return err
+	} else if a == b {
+		return ErrSurgeryOutputIsSource
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// writePage writes buf at the offset of the given page.
+// This is not transactionally safe.
+func writePage(path string, pageID pgid, buf []byte) error {
+	pageSize, err := ReadPageSize(path)
+	if err != nil {
+		return fmt.Errorf("read page size: %s", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, int64(pageID)*int64(pageSize)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readMeta reads the meta page with the given id and returns it along with
+// the page buffer. It returns an error if the meta page is invalid.
+func readMeta(path string, pageID pgid) (*meta, []byte, error) {
+	p, buf, err := ReadPage(path, int(pageID))
+	if err != nil {
+		return nil, nil, err
+	} else if p.Type() != "meta" {
+		return nil, nil, fmt.Errorf("page %d is not a meta page: %s", pageID, p.Type())
+	}
+
+	m := (*meta)(unsafe.Pointer(&buf[PageHeaderSize]))
+	if m.magic != magic {
+		return nil, nil, fmt.Errorf("meta page %d: invalid magic %x", pageID, m.magic)
+	} else if m.version != version {
+		return nil, nil, fmt.Errorf("meta page %d: version mismatch: %d", pageID, m.version)
+	} else if m.checksum != 0 && m.checksum != m.sum64() {
+		return nil, nil, fmt.Errorf("meta page %d: checksum mismatch", pageID)
+	}
+	return m, buf, nil
+}
+
+// activeMeta returns the id of the meta page the database opens with and
+// that meta page.
+func activeMeta(path string) (pgid, *meta, error) {
+	m0, _, err0 := readMeta(path, 0)
+	m1, _, err1 := readMeta(path, 1)
+	switch {
+	case err0 != nil && err1 != nil:
+		return 0, nil, fmt.Errorf("no valid meta page: %s; %s", err0, err1)
+	case err1 != nil || (err0 == nil && m0.txid > m1.txid):
+		return 0, m0, nil
+	default:
+		return 1, m1, nil
+	}
+}
+
+// revertMetaPage overwrites the active meta page with the other one.
+func revertMetaPage(path string) error {
+	active, _, err := activeMeta(path)
+	if err != nil {
+		return err
+	}
+	other := 1 - active
+	_, buf, err := readMeta(path, other)
+	if err != nil {
+		return fmt.Errorf("cannot revert to an invalid meta page: %s", err)
+	}
+	(*page)(unsafe.Pointer(&buf[0])).id = active
+	return writePage(path, active, buf)
+}
+
+// copyPage overwrites the page dst with the content of the page src,
+// including its overflow pages. The meta pages can't be overwritten.
+func copyPage(path string, src, dst pgid) error {
+	_, m, err := activeMeta(path)
+	if err != nil {
+		return err
+	} else if dst <= 1 {
+		return fmt.Errorf("page %d is a meta page, use revert-meta-page to replace it", dst)
+	}
+
+	p, buf, err := ReadPage(path, int(src))
+	if err != nil {
+		return err
+	}
+	if src >= m.pgid {
+		return fmt.Errorf("source page %d is above the high water mark %d", src, m.pgid)
+	} else if last := dst + pgid(p.overflow); last >= m.pgid {
+		return fmt.Errorf("page %d with %d overflow pages does not fit below the high water mark %d", dst, p.overflow, m.pgid)
+	}
+
+	p.id = dst
+	return writePage(path, dst, buf)
+}
+
+// clearPageElements removes the elements with an index in [start, end) from
+// a leaf or branch page. An end of -1 removes all elements up to the end of
+// the page. It returns true if pages referenced by the removed elements are
+// now abandoned, i.e. neither reachable nor free.
+func clearPageElements(path string, pageID pgid, start, end int) (bool, error) {
+	_, m, err := activeMeta(path)
+	if err != nil {
+		return false, err
+	} else if pageID <= 1 || pageID >= m.pgid {
+		return false, fmt.Errorf("page %d is out of bounds: high water mark is %d", pageID, m.pgid)
+	}
+
+	p, buf, err := ReadPage(path, int(pageID))
+	if err != nil {
+		return false, err
+	}
+	isLeaf := p.Type() == "leaf"
+	if !isLeaf && p.Type() != "branch" {
+		return false, fmt.Errorf("can't clear elements of a %s page", p.Type())
+	}
+
+	count := int(p.count)
+	if end == -1 {
+		end = count
+	}
+	if start < 0 || start > end || end > count {
+		return false, fmt.Errorf("invalid element range [%d, %d) for page %d with %d elements", start, end, pageID, count)
+	}
+
+	// Collect the remaining elements and detect abandoned pages.
+	type element struct {
+		flags uint32
+		key   []byte
+		value []byte
+		pgid  pgid
+	}
+	var elements []element
+	var abandoned bool
+	for i := 0; i < count; i++ {
+		if isLeaf {
+			e := p.leafPageElement(uint16(i))
+			if i >= start && i < end {
+				abandoned = abandoned || referencesPages(e.flags, e.value())
+				continue
+			}
+			elements = append(elements, element{flags: e.flags, key: e.key(), value: e.value()})
+		} else {
+			e := p.branchPageElement(uint16(i))
+			if i >= start && i < end {
+				abandoned = true
+				continue
+			}
+			elements = append(elements, element{key: e.key(), pgid: e.pgid})
+		}
+	}
+
+	// Rebuild the page in a new buffer of the same size. A branch page
+	// without elements is invalid, so it becomes an empty leaf page. The
+	// remaining keys of a prefix compressed page share its prefix, which is
+	// written again after the elements.
+	out := make([]byte, len(buf))
+	np := (*page)(unsafe.Pointer(&out[0]))
+	np.id = p.id
+	np.flags = p.flags
+	np.overflow = p.overflow
+	np.count = uint16(len(elements))
+	prefix := p.prefix()
+	if len(elements) == 0 {
+		np.flags &^= prefixPageFlag
+		prefix = nil
+	}
+	if !isLeaf && len(elements) == 0 {
+		np.flags = leafPageFlag
+	}
+
+	elemSize := int(unsafe.Sizeof(leafPageElement{}))
+	if !isLeaf {
+		elemSize = int(unsafe.Sizeof(branchPageElement{}))
+	}
+	off := PageHeaderSize + len(elements)*elemSize
+	if (np.flags & prefixPageFlag) != 0 {
+		*(*uint16)(unsafe.Pointer(&out[off])) = uint16(len(prefix))
+		off += 2 + copy(out[off+2:], prefix)
+	}
+	for i, e := range elements {
+		pos := uint32(off - (PageHeaderSize + i*elemSize))
+		if isLeaf {
+			le := np.leafPageElement(uint16(i))
+			le.flags, le.pos = e.flags, pos
+			le.ksize, le.vsize = uint32(len(e.key)), uint32(len(e.value))
+		} else {
+			be := np.branchPageElement(uint16(i))
+			be.pos, be.ksize, be.pgid = pos, uint32(len(e.key)), e.pgid
+		}
+		off += copy(out[off:], e.key)
+		off += copy(out[off:], e.value)
+	}
+
+	return abandoned, writePage(path, pageID, out)
+}
+
+// referencesPages returns true if the leaf element with the given flags and
+// value references pages: the root of a bucket, the runs of a value set by
+// PutReader, or such an element in the page of an inline bucket. Damaged
+// bucket values are assumed to reference pages.
+func referencesPages(flags uint32, value []byte) bool {
+	if flags&blobLeafFlag != 0 {
+		return true
+	} else if flags&bucketLeafFlag == 0 {
+		return false
+	}
+
+	hdrSize := int(unsafe.Sizeof(bucket{}))
+	if len(value) < hdrSize {
+		return true
+	} else if b := (*bucket)(unsafe.Pointer(&value[0])); b.root != 0 {
+		return true
+	}
+
+	// Skip the bucket options to reach the inline page.
+	off := hdrSize
+	if flags&bucketExtLeafFlag != 0 {
+		if len(value) < off+4 {
+			return true
+		}
+		off += int(*(*uint32)(unsafe.Pointer(&value[off])))
+	}
+	if off < hdrSize || len(value) < off+PageHeaderSize {
+		return true
+	}
+	p := (*page)(unsafe.Pointer(&value[off]))
+	if p.flags&leafPageFlag == 0 {
+		return false
+	}
+	elemSize := int(unsafe.Sizeof(leafPageElement{}))
+	for i := 0; i < int(p.count); i++ {
+		elemOff := off + PageHeaderSize + i*elemSize
+		if elemOff+elemSize > len(value) {
+			return true
+		}
+		e := p.leafPageElement(uint16(i))
+		if end := elemOff + int(e.pos) + int(e.ksize) + int(e.vsize); end > len(value) {
+			return true
+		} else if referencesPages(e.flags, e.value()) {
+			return true
+		}
+	}
+	return false
+}
+
+// abandonFreelist marks the valid meta pages as having no freelist, so that the
+// freelist is rebuilt by scanning the database on next open.
+func abandonFreelist(path string) error {
+	var errs []string
+	for _, id := range []pgid{0, 1} {
+		m, buf, err := readMeta(path, id)
+		if err != nil {
+			// Leave an invalid meta page alone, it is never used.
+			errs = append(errs, err.Error())
+			continue
+		}
+		m.freelist = pgidNoFreelist
+		m.checksum = m.sum64()
+		if err := writePage(path, id, buf); err != nil {
+			return err
+		}
+	}
+	if len(errs) == 2 {
+		return fmt.Errorf("no valid meta page: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrValueRequired is returned when a value is not specified.
+	ErrValueRequired = errors.New("value required")
+
+	// ErrStdinUsedTwice is returned when both the key and the value are
+	// read from stdin.
+	ErrStdinUsedTwice = errors.New("key and value cannot both be read from stdin")
+)
+
+// PutCommand represents the "put" command execution.
+type PutCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newPutCommand returns a PutCommand.
+func newPutCommand(m *Main) *PutCommand {
+	return &PutCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *PutCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	keyFormat := fs.String("key-format", "bytes", "")
+	valueFormat := fs.String("value-format", "bytes", "")
+	keyFile := fs.String("key-file", "", "")
+	valueFile := fs.String("value-file", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if *keyFile == "-" && *valueFile == "-" {
+		return ErrStdinUsedTwice
+	}
+
+	// Require database path.
+	path, rest := fs.Arg(0), fs.Args()
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+	rest = rest[1:]
+
+	// Read value, then key, from the end of the arguments unless they
+	// come from a file.
+	var value, key []byte
+	var err error
+	if *valueFile != "" {
+		value, err = readBytesFile(*valueFile, *valueFormat, cmd.Stdin)
+	} else if len(rest) == 0 {
+		return ErrValueRequired
+	} else {
+		value, err = parseBytes(rest[len(rest)-1], *valueFormat)
+		rest = rest[:len(rest)-1]
+	}
+	if err != nil {
+		return err
+	}
+	if key, rest, err = readKey(rest, *keyFile, *keyFormat, cmd.Stdin); err != nil {
+		return err
+	} else if len(rest) == 0 {
+		return ErrBucketRequired
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Write value.
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := findBucket(tx, rest, false)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+// Usage returns the help message.
+func (cmd *PutCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt put [options] PATH BUCKET [BUCKET...] KEY VALUE
+
+Put sets the value of the given key in the given (sub)bucket. The bucket must
+exist.
+
+Additional options include:
+
+	-key-format FORMAT
+		Specifies how KEY is encoded. One of: bytes|ascii-encoded|hex|base64
+		(default=bytes)
+	-value-format FORMAT
+		Specifies how VALUE is encoded. One of: bytes|ascii-encoded|hex|base64
+		(default=bytes)
+	-key-file PATH
+		Read the key from the file at PATH, or from stdin if PATH is "-",
+		instead of the KEY argument.
+	-value-file PATH
+		Read the value from the file at PATH, or from stdin if PATH is "-",
+		instead of the VALUE argument.
+`, "\n")
+}
+
+// DeleteCommand represents the "delete" command execution.
+type DeleteCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newDeleteCommand returns a DeleteCommand.
+func newDeleteCommand(m *Main) *DeleteCommand {
+	return &DeleteCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *DeleteCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	keyFormat := fs.String("key-format", "bytes", "")
+	keyFile := fs.String("key-file", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path, bucket and key.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+	key, buckets, err := readKey(fs.Args()[1:], *keyFile, *keyFormat, cmd.Stdin)
+	if err != nil {
+		return err
+	} else if len(buckets) == 0 {
+		return ErrBucketRequired
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Delete key.
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := findBucket(tx, buckets, false)
+		if err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().Seek(key); !bytes.Equal(k, key) {
+			return ErrKeyNotFound
+		}
+		return b.Delete(key)
+	})
+}
+
+// Usage returns the help message.
+func (cmd *DeleteCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt delete [options] PATH BUCKET [BUCKET...] KEY
+
+Delete removes the given key from the given (sub)bucket. Use delete-bucket to
+remove a nested bucket.
+
+Additional options include:
+
+	-key-format FORMAT
+		Specifies how KEY is encoded. One of: bytes|ascii-encoded|hex|base64
+		(default=bytes)
+	-key-file PATH
+		Read the key from the file at PATH, or from stdin if PATH is "-",
+		instead of the KEY argument.
+`, "\n")
+}
+
+// CreateBucketCommand represents the "create-bucket" command execution.
+type CreateBucketCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newCreateBucketCommand returns a CreateBucketCommand.
+func newCreateBucketCommand(m *Main) *CreateBucketCommand {
+	return &CreateBucketCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *CreateBucketCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	parents := fs.Bool("parents", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path and bucket.
+	path, buckets := fs.Arg(0), fs.Args()
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if buckets = buckets[1:]; len(buckets) == 0 {
+		return ErrBucketRequired
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Create bucket.
+	return db.Update(func(tx *bolt.Tx) error {
+		name := []byte(buckets[len(buckets)-1])
+		if len(buckets) == 1 {
+			if *parents {
+				_, err := tx.CreateBucketIfNotExists(name)
+				return err
+			}
+			_, err := tx.CreateBucket(name)
+			return err
+		}
+
+		b, err := findBucket(tx, buckets[:len(buckets)-1], *parents)
+		if err != nil {
+			return err
+		}
+		if *parents {
+			_, err = b.CreateBucketIfNotExists(name)
+			return err
+		}
+		_, err = b.CreateBucket(name)
+		return err
+	})
+}
+
+// Usage returns the help message.
+func (cmd *CreateBucketCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt create-bucket [options] PATH BUCKET [BUCKET...]
+
+Create-bucket creates the last given bucket inside the (sub)bucket given by
+the preceding names.
+
+Additional options include:
+
+	-parents
+		Create missing parent buckets, and do not fail if the bucket
+		already exists.
+`, "\n")
+}
+
+// DeleteBucketCommand represents the "delete-bucket" command execution.
+type DeleteBucketCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newDeleteBucketCommand returns a DeleteBucketCommand.
+func newDeleteBucketCommand(m *Main) *DeleteBucketCommand {
+	return &DeleteBucketCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *DeleteBucketCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path and bucket.
+	path, buckets := fs.Arg(0), fs.Args()
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if buckets = buckets[1:]; len(buckets) == 0 {
+		return ErrBucketRequired
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Delete bucket.
+	return db.Update(func(tx *bolt.Tx) error {
+		name := []byte(buckets[len(buckets)-1])
+		var err error
+		if len(buckets) == 1 {
+			err = tx.DeleteBucket(name)
+		} else {
+			var b *bolt.Bucket
+			if b, err = findBucket(tx, buckets[:len(buckets)-1], false); err != nil {
+				return err
+			}
+			err = b.DeleteBucket(name)
+		}
+		if err == bolt.ErrBucketNotFound {
+			return ErrBucketNotFound
+		}
+		return err
+	})
+}
+
+// Usage returns the help message.
+func (cmd *DeleteBucketCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt delete-bucket PATH BUCKET [BUCKET...]
+
+Delete-bucket deletes the last given bucket, and all of its content, from the
+(sub)bucket given by the preceding names.
+`, "\n")
+}
+
+// findBucket returns the bucket at the given path. Missing buckets are
+// created if create is true.
+func findBucket(tx *bolt.Tx, buckets []string, create bool) (*bolt.Bucket, error) {
+	var b *bolt.Bucket
+	for i, name := range buckets {
+		var next *bolt.Bucket
+		if i == 0 {
+			next = tx.Bucket([]byte(name))
+		} else {
+			next = b.Bucket([]byte(name))
+		}
+		if next == nil && create {
+			var err error
+			if i == 0 {
+				next, err = tx.CreateBucket([]byte(name))
+			} else {
+				next, err = b.CreateBucket([]byte(name))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if next == nil {
+			return nil, ErrBucketNotFound
+		}
+		b = next
+	}
+	return b, nil
+}
+
+// readKey returns the key read from keyFile if set, or else from the last of
+// args, along with the remaining args.
+func readKey(args []string, keyFile, format string, stdin io.Reader) ([]byte, []string, error) {
+	if keyFile != "" {
+		key, err := readBytesFile(keyFile, format, stdin)
+		return key, args, err
+	}
+	if len(args) == 0 {
+		return nil, nil, ErrKeyRequired
+	}
+	key, err := parseBytes(args[len(args)-1], format)
+	if err != nil {
+		return nil, nil, err
+	} else if len(key) == 0 {
+		return nil, nil, ErrKeyRequired
+	}
+	return key, args[:len(args)-1], nil
+}
+
+// readBytesFile reads the content of the file at path, or of stdin if path is
+// "-", and decodes it. Surrounding whitespace is ignored for text formats.
+func readBytesFile(path, format string, stdin io.Reader) ([]byte, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if format != "bytes" {
+		return parseBytes(strings.TrimSpace(string(b)), format)
+	}
+	return b, nil
+}
+
+// parseBytes decodes s. It is the counterpart of PageItemCommand.writeBytes.
+// Supported formats: bytes, ascii-encoded, hex, base64.
+func parseBytes(s string, format string) ([]byte, error) {
+	switch format {
+	case "bytes":
+		return []byte(s), nil
+	case "ascii-encoded":
+		u, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("parseBytes: invalid ascii-encoded string %s: %s", s, err)
+		}
+		return []byte(u), nil
+	case "hex":
+		return hex.DecodeString(s)
+	case "base64":
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("parseBytes: unsupported format: %s", format)
+	}
+}
+
+// formatBytes encodes b in one of the formats supported by parseBytes.
+func formatBytes(b []byte, format string) (string, error) {
+	switch format {
+	case "bytes":
+		return string(b), nil
+	case "ascii-encoded":
+		return fmt.Sprintf("%q", b), nil
+	case "hex":
+		return hex.EncodeToString(b), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b), nil
+	default:
+		return "", fmt.Errorf("formatBytes: unsupported format: %s", format)
+	}
+}
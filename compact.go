@@ -1,5 +1,10 @@
 package bbolt
 
+import (
+	"fmt"
+	"io"
+)
+
 // Compact will create a copy of the source DB and in the destination DB. This may
 // reclaim space that the source database no longer has use for. txMaxSize can be
 // used to limit the transactions size of this process and may trigger intermittent
@@ -14,9 +19,12 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 	}
 	defer tx.Rollback()
 
-	if err := walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
+	if err := walk(src, func(keys [][]byte, k, v []byte, blob io.ReadSeeker, seq uint64, opts BucketOptions) error {
 		// On each key/value, check if we have exceeded tx size.
 		sz := int64(len(k) + len(v))
+		if blob != nil {
+			sz = int64(len(k)) + blob.(*blobReader).size
+		}
 		if size+sz > txMaxSize && txMaxSize != 0 {
 			// Commit previous transaction.
 			if err := tx.Commit(); err != nil {
@@ -35,8 +43,10 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		// Create bucket on the root transaction if this is the first level.
 		nk := len(keys)
 		if nk == 0 {
-			bkt, err := tx.CreateBucket(k)
-			if err != nil {
+			bkt, err := tx.CreateBucketWithOptions(k, &opts)
+			if err == ErrUnknownComparator {
+				return fmt.Errorf("bucket %q: %s %q", k, err, opts.Comparator)
+			} else if err != nil {
 				return err
 			}
 			if err := bkt.SetSequence(seq); err != nil {
@@ -56,10 +66,17 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		// Fill the entire page for best compaction.
 		b.FillPercent = 1.0
 
+		// Copy the values set by PutReader without reading them in memory.
+		if blob != nil {
+			return b.PutReader(k, blob, blob.(*blobReader).size)
+		}
+
 		// If there is no value then this is a bucket call.
 		if v == nil {
-			bkt, err := b.CreateBucket(k)
-			if err != nil {
+			bkt, err := b.CreateBucketWithOptions(k, &opts)
+			if err == ErrUnknownComparator {
+				return fmt.Errorf("bucket %q: %s %q", k, err, opts.Comparator)
+			} else if err != nil {
 				return err
 			}
 			if err := bkt.SetSequence(seq); err != nil {
@@ -69,6 +86,9 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		}
 
 		// Otherwise treat it as a key/value pair.
+		if b.dupSort {
+			return b.PutDup(k, v)
+		}
 		return b.Put(k, v)
 	}); err != nil {
 		return err
@@ -79,21 +99,23 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 
 // walkFunc is the type of the function called for keys (buckets and "normal"
 // values) discovered by Walk. keys is the list of keys to descend to the bucket
-// owning the discovered key/value pair k/v.
-type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
+// owning the discovered key/value pair k/v. For values set by PutReader, v is
+// nil and blob reads the value. For buckets, seq and opts are the sequence and
+// the options of the bucket.
+type walkFunc func(keys [][]byte, k, v []byte, blob io.ReadSeeker, seq uint64, opts BucketOptions) error
 
 // walk walks recursively the bolt database db, calling walkFn for each key it finds.
 func walk(db *DB, walkFn walkFunc) error {
 	return db.View(func(tx *Tx) error {
 		return tx.ForEach(func(name []byte, b *Bucket) error {
-			return walkBucket(b, nil, name, nil, b.Sequence(), walkFn)
+			return walkBucket(b, nil, name, nil, walkFn)
 		})
 	})
 }
 
-func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
+func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, fn walkFunc) error {
 	// Execute callback.
-	if err := fn(keypath, k, v, seq); err != nil {
+	if err := fn(keypath, k, v, nil, b.Sequence(), b.options()); err != nil {
 		return err
 	}
 
@@ -104,11 +126,138 @@ func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFun
 
 	// Iterate over each child key/value.
 	keypath = append(keypath, k)
-	return b.ForEach(func(k, v []byte) error {
-		if v == nil {
-			bkt := b.Bucket(k)
-			return walkBucket(bkt, keypath, k, nil, bkt.Sequence(), fn)
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var err error
+		if _, _, flags := c.keyValue(); (flags & blobLeafFlag) != 0 {
+			err = fn(keypath, k, nil, b.GetReader(k), b.Sequence(), b.options())
+		} else if (flags & dupLeafFlag) != 0 {
+			for dk, dv := k, v; dk != nil && err == nil; dk, dv = c.NextDup() {
+				err = fn(keypath, dk, dv, nil, b.Sequence(), b.options())
+			}
+		} else if v == nil {
+			var bkt *Bucket
+			if bkt, err = b.lookupBucket(k); err == nil {
+				err = walkBucket(bkt, keypath, k, nil, fn)
+			}
+		} else {
+			err = walkBucket(b, keypath, k, v, fn)
 		}
-		return walkBucket(b, keypath, k, v, b.Sequence(), fn)
-	})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketWriter writes buckets and key/value pairs to a database, committing
+// regularly to bound the size of transactions.
+type bucketWriter struct {
+	db        *DB
+	tx        *Tx
+	txMaxSize int64
+	size      int64
+
+	// bucketN and keyN count the buckets created and the keys written.
+	bucketN int
+	keyN    int
+}
+
+func (w *bucketWriter) begin(sz int64) error {
+	if w.tx != nil && w.size+sz > w.txMaxSize && w.txMaxSize != 0 {
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+	if w.tx == nil {
+		tx, err := w.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		w.tx, w.size = tx, 0
+	}
+	w.size += sz
+	return nil
+}
+
+// bucket returns the bucket at path, creating missing buckets with the default
+// options.
+func (w *bucketWriter) bucket(path [][]byte) (*Bucket, error) {
+	b, err := w.tx.CreateBucketIfNotExists(path[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range path[1:] {
+		if b, err = b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// createBucket creates the bucket at path with the given options, and its
+// missing parents, and sets its sequence. It does nothing if the bucket
+// already exists.
+func (w *bucketWriter) createBucket(path [][]byte, seq uint64, opts BucketOptions) error {
+	if err := w.begin(int64(len(path[len(path)-1]))); err != nil {
+		return err
+	}
+	var b, parent *Bucket
+	var err error
+	if len(path) > 1 {
+		if parent, err = w.bucket(path[:len(path)-1]); err != nil {
+			return err
+		} else if parent.Bucket(path[len(path)-1]) != nil {
+			return nil
+		}
+		b, err = parent.CreateBucketWithOptions(path[len(path)-1], &opts)
+	} else if w.tx.Bucket(path[0]) != nil {
+		return nil
+	} else {
+		b, err = w.tx.CreateBucketWithOptions(path[0], &opts)
+	}
+	if err != nil {
+		return err
+	}
+	w.bucketN++
+	return b.SetSequence(seq)
+}
+
+// put sets the value of a key in the bucket at path, creating the missing
+// buckets. The value is added to the values of the key in buckets created
+// with DupSort.
+func (w *bucketWriter) put(path [][]byte, k, v []byte) error {
+	if err := w.begin(int64(len(k) + len(v))); err != nil {
+		return err
+	}
+	b, err := w.bucket(path)
+	if err != nil {
+		return err
+	}
+	if b.dupSort {
+		err = b.PutDup(k, v)
+	} else {
+		err = b.Put(k, v)
+	}
+	if err != nil {
+		return err
+	}
+	w.keyN++
+	return nil
+}
+
+func (w *bucketWriter) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	tx := w.tx
+	w.tx = nil
+	return tx.Commit()
+}
+
+func (w *bucketWriter) rollback() {
+	if w.tx != nil {
+		_ = w.tx.Rollback()
+		w.tx = nil
+	}
 }
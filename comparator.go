@@ -0,0 +1,148 @@
+package bbolt
+
+import (
+	"bytes"
+	"sync"
+	"unsafe"
+)
+
+const bucketExtSize = int(unsafe.Sizeof(bucketExt{}))
+
+// bucketExtDupSort is set in the flags of the extension of buckets created
+// with DupSort.
+const bucketExtDupSort = 0x01
+
+// Comparator orders the keys of a bucket. It returns a negative number if a
+// sorts before b, zero if a and b are identical, and a positive number
+// otherwise. Keys are still matched with bytes.Equal, so a comparator must
+// only return zero for identical keys, and it must be a total order which
+// never changes once data has been written with it.
+type Comparator func(a, b []byte) int
+
+// comparators holds the comparators registered by name.
+var comparators = struct {
+	sync.RWMutex
+	m map[string]Comparator
+}{m: make(map[string]Comparator)}
+
+// RegisterComparator makes a comparator available to buckets under name. The
+// name is persisted in the header of the buckets created with it, so the
+// comparator must be registered under the same name before they are opened
+// again, typically from an init function. It panics if name is blank, if cmp
+// is nil, or if name is already registered.
+func RegisterComparator(name string, cmp Comparator) {
+	if name == "" {
+		panic("bbolt: comparator name required")
+	} else if cmp == nil {
+		panic("bbolt: nil comparator " + name)
+	}
+
+	comparators.Lock()
+	defer comparators.Unlock()
+	if _, ok := comparators.m[name]; ok {
+		panic("bbolt: comparator " + name + " already registered")
+	}
+	comparators.m[name] = cmp
+}
+
+// lookupComparator returns the comparator registered under name, or nil.
+func lookupComparator(name string) Comparator {
+	comparators.RLock()
+	defer comparators.RUnlock()
+	return comparators.m[name]
+}
+
+// BucketOptions configures a bucket created with CreateBucketWithOptions.
+// The options are persisted with the bucket. They are not inherited by nested
+// buckets.
+type BucketOptions struct {
+	// Comparator is the name of the registered comparator ordering the keys
+	// of the bucket. Keys are ordered by bytes.Compare when it is blank.
+	Comparator string
+
+	// DupSort allows several values per key, added with PutDup and sorted
+	// by bytes.Compare. Buckets created with DupSort cannot hold nested
+	// buckets, nor values set by PutReader.
+	DupSort bool
+}
+
+// bucketExt follows the bucket header in the value of a bucket flagged with
+// bucketExtLeafFlag. It is followed by the comparator name, padded so that
+// the inline page of the bucket, if any, stays aligned.
+type bucketExt struct {
+	size    uint32 // size of the extension, name and padding included
+	nameLen uint16 // length of the comparator name
+	flags   uint16 // options other than the comparator
+}
+
+// readBucketExt returns the options stored in the extension of a bucket
+// value flagged with bucketExtLeafFlag, and the size of the extension.
+// It returns false if the extension is malformed.
+func readBucketExt(value []byte) (BucketOptions, int, bool) {
+	if len(value) < bucketHeaderSize+bucketExtSize {
+		return BucketOptions{}, 0, false
+	}
+	var ext bucketExt
+	copy(unsafeByteSlice(unsafe.Pointer(&ext), 0, 0, bucketExtSize), value[bucketHeaderSize:])
+	name := bucketHeaderSize + bucketExtSize
+	if int(ext.size) < bucketExtSize+int(ext.nameLen) || bucketHeaderSize+int(ext.size) > len(value) || ext.size%8 != 0 {
+		return BucketOptions{}, 0, false
+	}
+	opts := BucketOptions{
+		Comparator: string(value[name : name+int(ext.nameLen)]),
+		DupSort:    (ext.flags & bucketExtDupSort) != 0,
+	}
+	return opts, int(ext.size), true
+}
+
+// ext returns the extension storing the options in a bucket value, or nil
+// if they are the defaults.
+func (o BucketOptions) ext() []byte {
+	if o == (BucketOptions{}) {
+		return nil
+	}
+	size := (bucketExtSize + len(o.Comparator) + 7) &^ 7
+	buf := make([]byte, size)
+	ext := bucketExt{size: uint32(size), nameLen: uint16(len(o.Comparator))}
+	if o.DupSort {
+		ext.flags |= bucketExtDupSort
+	}
+	copy(buf, unsafeByteSlice(unsafe.Pointer(&ext), 0, 0, bucketExtSize))
+	copy(buf[bucketExtSize:], o.Comparator)
+	return buf
+}
+
+// Comparator returns the name of the comparator ordering the keys of the
+// bucket, or a blank string if they are ordered by bytes.Compare.
+func (b *Bucket) Comparator() string {
+	return b.comparator
+}
+
+// options returns the persisted options of the bucket.
+func (b *Bucket) options() BucketOptions {
+	return BucketOptions{Comparator: b.comparator, DupSort: b.dupSort}
+}
+
+// leafFlags returns the flags of the leaf element holding the bucket value.
+func (b *Bucket) leafFlags() uint32 {
+	if b.dupTree {
+		return bucketLeafFlag | dupLeafFlag
+	} else if b.options() != (BucketOptions{}) {
+		return bucketLeafFlag | bucketExtLeafFlag
+	}
+	return bucketLeafFlag
+}
+
+// rawKeyOrder returns true if the bucket was created with a comparator which
+// is not registered, so its keys can't be searched.
+func (b *Bucket) rawKeyOrder() bool {
+	return b.comparator != "" && b.compare == nil
+}
+
+// compareKeys compares two keys in the order of the bucket.
+func (b *Bucket) compareKeys(a, c []byte) int {
+	if b.compare == nil {
+		return bytes.Compare(a, c)
+	}
+	return b.compare(a, c)
+}
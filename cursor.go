@@ -7,7 +7,9 @@ import (
 )
 
 // Cursor represents an iterator that can traverse over all key/value pairs in a bucket in sorted order.
-// Cursors see nested buckets with value == nil.
+// Cursors see nested buckets, and values set by Bucket.PutReader, with value == nil.
+// In buckets created with DupSort, cursors move between keys and see their first
+// value, and NextDup and PrevDup move between the values of the current key.
 // Cursors can be obtained from a transaction and are valid as long as the transaction is open.
 //
 // Keys and values returned from the cursor are only valid for the life of the transaction.
@@ -18,6 +20,7 @@ import (
 type Cursor struct {
 	bucket *Bucket
 	stack  []elemRef
+	dup    *dupCursor // position among the values of the current key, if moved
 }
 
 // Bucket returns the bucket that this cursor was created from.
@@ -41,11 +44,7 @@ func (c *Cursor) First() (key []byte, value []byte) {
 		c.next()
 	}
 
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	return c.item(c.keyValue())
 
 }
 
@@ -60,11 +59,7 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 	ref.index = ref.count() - 1
 	c.stack = append(c.stack, ref)
 	c.last()
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	return c.item(c.keyValue())
 }
 
 // Next moves the cursor to the next item in the bucket and returns its key and value.
@@ -72,11 +67,7 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Next() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
-	k, v, flags := c.next()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	return c.item(c.next())
 }
 
 // Prev moves the cursor to the previous item in the bucket and returns its key and value.
@@ -98,16 +89,13 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 
 	// If we've hit the end then return nil.
 	if len(c.stack) == 0 {
+		c.dup = nil
 		return nil, nil
 	}
 
 	// Move down the stack to find the last element of the last leaf under this branch.
 	c.last()
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	return c.item(c.keyValue())
 }
 
 // Seek moves the cursor to a given key and returns it.
@@ -122,15 +110,12 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 		k, v, flags = c.next()
 	}
 
-	if k == nil {
-		return nil, nil
-	} else if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	return c.item(k, v, flags)
 }
 
 // Delete removes the current key/value under the cursor from the bucket.
+// In a bucket created with DupSort, only the current value of the key is removed,
+// and the cursor moves to the next value, or to the next key after the last value.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
 func (c *Cursor) Delete() error {
 	if c.bucket.tx.db == nil {
@@ -139,21 +124,59 @@ func (c *Cursor) Delete() error {
 		return ErrTxNotWritable
 	}
 
-	key, _, flags := c.keyValue()
+	key, value, flags := c.keyValue()
+	if (flags & dupLeafFlag) != 0 {
+		if c.dup == nil {
+			c.dup = c.newDupCursor()
+		}
+		key, value = cloneBytes(key), cloneBytes(c.dup.value())
+		if err := c.bucket.DeleteDup(key, value); err != nil {
+			return err
+		}
+		c.seekDupAfter(key, value)
+		return nil
+	}
 	// Return an error if current value is a bucket.
 	if (flags & bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
+	// Release the value if it was set by PutReader.
+	if (flags & blobLeafFlag) != 0 {
+		if err := c.bucket.tx.freeBlob(value); err != nil {
+			return err
+		}
+	}
 	c.node().del(key)
 
 	return nil
 }
 
+// item returns the key and value of an element as seen by the public methods,
+// and resets the position among the values of the key.
+func (c *Cursor) item(key []byte, value []byte, flags uint32) ([]byte, []byte) {
+	c.dup = nil
+	if key == nil {
+		return nil, nil
+	} else if (flags & dupLeafFlag) != 0 {
+		c.dup = c.newDupCursor()
+		return key, c.dup.value()
+	} else if (flags & uint32(bucketLeafFlag|blobLeafFlag)) != 0 {
+		return key, nil
+	}
+	return key, value
+}
+
 // seek moves the cursor to a given key and returns it.
 // If the key does not exist then the next key is used.
 func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 
+	// The keys of a bucket without its comparator can't be searched, so
+	// they are scanned for an exact match.
+	if c.bucket.rawKeyOrder() {
+		return c.scan(seek)
+	}
+
 	// Start from root page/node and traverse to correct page.
 	c.stack = c.stack[:0]
 	c.search(seek, c.bucket.root)
@@ -162,6 +185,30 @@ func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
 	return c.keyValue()
 }
 
+// scan moves the cursor to the element with the key seek by iterating over
+// the whole bucket. The cursor is left after the last element if there is
+// no such key.
+func (c *Cursor) scan(seek []byte) (key []byte, value []byte, flags uint32) {
+	c.stack = c.stack[:0]
+	p, n := c.bucket.pageNode(c.bucket.root)
+	c.stack = append(c.stack, elemRef{page: p, node: n, index: 0})
+	c.first()
+
+	k, v, flags := c.keyValue()
+	if c.stack[len(c.stack)-1].count() == 0 {
+		k, v, flags = c.next()
+	}
+	for ; k != nil; k, v, flags = c.next() {
+		if bytes.Equal(k, seek) {
+			return k, v, flags
+		}
+	}
+
+	ref := &c.stack[len(c.stack)-1]
+	ref.index = ref.count()
+	return nil, nil, 0
+}
+
 // first moves the cursor to the first leaf element under the last page in the stack.
 func (c *Cursor) first() {
 	for {
@@ -270,7 +317,7 @@ func (c *Cursor) searchNode(key []byte, n *node) {
 	index := sort.Search(len(n.inodes), func(i int) bool {
 		// TODO(benbjohnson): Optimize this range search. It's a bit hacky right now.
 		// sort.Search() finds the lowest index where f() != -1 but we need the highest index.
-		ret := bytes.Compare(n.inodes[i].key, key)
+		ret := c.bucket.compareKeys(n.inodes[i].key, key)
 		if ret == 0 {
 			exact = true
 		}
@@ -287,25 +334,16 @@ func (c *Cursor) searchNode(key []byte, n *node) {
 
 func (c *Cursor) searchPage(key []byte, p *page) {
 	// Binary search for the correct range.
-	inodes := p.branchPageElements()
-
-	var exact bool
-	index := sort.Search(int(p.count), func(i int) bool {
-		// TODO(benbjohnson): Optimize this range search. It's a bit hacky right now.
-		// sort.Search() finds the lowest index where f() != -1 but we need the highest index.
-		ret := bytes.Compare(inodes[i].key(), key)
-		if ret == 0 {
-			exact = true
-		}
-		return ret != -1
-	})
+	// searchKey finds the lowest index where the key is not less than key
+	// but we need the highest index.
+	index, exact := p.searchKey(key, c.bucket.compare)
 	if !exact && index > 0 {
 		index--
 	}
 	c.stack[len(c.stack)-1].index = index
 
 	// Recursively search to the next page.
-	c.search(key, inodes[index].pgid)
+	c.search(key, p.branchPageElement(uint16(index)).pgid)
 }
 
 // nsearch searches the leaf node on the top of the stack for a key.
@@ -316,18 +354,14 @@ func (c *Cursor) nsearch(key []byte) {
 	// If we have a node then search its inodes.
 	if n != nil {
 		index := sort.Search(len(n.inodes), func(i int) bool {
-			return bytes.Compare(n.inodes[i].key, key) != -1
+			return c.bucket.compareKeys(n.inodes[i].key, key) != -1
 		})
 		e.index = index
 		return
 	}
 
 	// If we have a page then search its leaf elements.
-	inodes := p.leafPageElements()
-	index := sort.Search(int(p.count), func(i int) bool {
-		return bytes.Compare(inodes[i].key(), key) != -1
-	})
-	e.index = index
+	e.index, _ = p.searchKey(key, c.bucket.compare)
 }
 
 // keyValue returns the key and value of the current leaf element.
@@ -347,7 +381,7 @@ func (c *Cursor) keyValue() ([]byte, []byte, uint32) {
 
 	// Or retrieve value from page.
 	elem := ref.page.leafPageElement(uint16(ref.index))
-	return elem.key(), elem.value(), elem.flags
+	return ref.page.leafKey(uint16(ref.index)), elem.value(), elem.flags
 }
 
 // node returns the node that the cursor is currently positioned on.
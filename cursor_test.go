@@ -161,6 +161,148 @@ func TestCursor_Delete(t *testing.T) {
 	}
 }
 
+// Ensure that a cursor can seek to a value of a key with duplicates, whether
+// they are packed or in a tree, and delete the value under it.
+func TestCursor_SeekBoth(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{DupSort: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i += 2 {
+			if err := b.PutDup([]byte("large"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+			if i < 10 {
+				if err := b.PutDup([]byte("small"), u64tob(uint64(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		for _, key := range []string{"large", "small"} {
+			if k, v := c.SeekBoth([]byte(key), u64tob(4)); string(k) != key || !bytes.Equal(v, u64tob(4)) {
+				t.Fatalf("unexpected exact seek: %q=%x", k, v)
+			}
+			if k, v := c.SeekBoth([]byte(key), u64tob(5)); string(k) != key || !bytes.Equal(v, u64tob(6)) {
+				t.Fatalf("unexpected seek: %q=%x", k, v)
+			} else if k, v = c.NextDup(); !bytes.Equal(v, u64tob(8)) {
+				t.Fatalf("unexpected next value: %q=%x", k, v)
+			}
+			if k, v := c.SeekBoth([]byte(key), u64tob(1000)); k != nil || v != nil {
+				t.Fatalf("unexpected seek past the values: %q=%x", k, v)
+			}
+
+			// Delete the value under the cursor only.
+			c.SeekBoth([]byte(key), u64tob(2))
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+			if k, v := c.SeekBoth([]byte(key), u64tob(2)); !bytes.Equal(v, u64tob(4)) {
+				t.Fatalf("unexpected value after delete: %q=%x", k, v)
+			} else if n := c.CountDups(); (key == "small" && n != 4) || (key == "large" && n != 499) {
+				t.Fatalf("unexpected count of %q: %d", key, n)
+			}
+		}
+		if k, v := c.SeekBoth([]byte("missing"), u64tob(0)); k != nil || v != nil {
+			t.Fatalf("unexpected seek to a missing key: %q=%x", k, v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting the value under a cursor moves it to the next value,
+// so that repeated deletes remove consecutive values.
+func TestCursor_Delete_Dups(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{DupSort: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.PutDup([]byte("large"), u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, v := range []string{"a", "b", "c"} {
+			if err := b.PutDup([]byte("small"), []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.PutDup([]byte("zzz"), []byte("x"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+
+		// Values of a tree.
+		c.SeekBoth([]byte("large"), u64tob(10))
+		for i := 0; i < 2; i++ {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, v := c.SeekBoth([]byte("large"), u64tob(10)); string(k) != "large" || !bytes.Equal(v, u64tob(12)) {
+			t.Fatalf("unexpected value after deletes: %q=%x", k, v)
+		} else if n := c.CountDups(); n != 998 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+
+		// Packed values, then the value of the next key once they are gone.
+		c.Seek([]byte("small"))
+		for i := 0; i < 2; i++ {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, v := c.Seek([]byte("small")); string(k) != "small" || string(v) != "c" {
+			t.Fatalf("unexpected value after deletes: %q=%q", k, v)
+		} else if n := c.CountDups(); n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		for i := 0; i < 2; i++ {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, v := c.Seek([]byte("small")); k != nil {
+			t.Fatalf("unexpected key after deletes: %q=%q", k, v)
+		}
+
+		// Deleting past the last key does nothing.
+		c.SeekBoth([]byte("large"), u64tob(999))
+		for i := 0; i < 2; i++ {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, v := c.First(); string(k) != "large" || !bytes.Equal(v, u64tob(0)) {
+			t.Fatalf("unexpected first value: %q=%x", k, v)
+		} else if n := c.CountDups(); n != 997 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a Tx cursor can seek to the appropriate keys when there are a
 // large number of keys. This test also checks that seek will always move
 // forward to the next key.
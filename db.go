@@ -9,13 +9,19 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 )
 
-// The largest step that can be taken when remapping the mmap.
+// The largest step that can be taken when growing the mmap.
 const maxMmapStep = 1 << 30 // 1GB
 
+// mmapAlign is the alignment of the file offsets of the mmap segments. It is
+// the allocation granularity on Windows and a multiple of the OS page size.
+const mmapAlign = 64 * 1024 // 64KB
+
 // The data file format version.
 const version = 2
 
@@ -87,6 +93,12 @@ type DB struct {
 	// The default type is array
 	FreelistType FreelistType
 
+	// When true, the leaf and branch pages written are prefix compressed:
+	// the prefix shared by the keys of a page is stored once, and only the
+	// key suffixes are stored in the elements. Compressed pages are read
+	// regardless of this flag, but not by versions which predate it.
+	PrefixCompression bool
+
 	// When true, skips the truncate call when growing the database.
 	// Setting this to true is only safe on non-ext3/ext4 systems.
 	// Skipping truncation avoids preallocation of hard drive space and
@@ -95,6 +107,12 @@ type DB struct {
 	// https://github.com/boltdb/bolt/issues/284
 	NoGrowSync bool
 
+	// When true, growing the database reserves the new blocks of the data
+	// file on disk with fallocate(), so a full disk fails the growth rather
+	// than a later write. It falls back to truncate() where fallocate() is
+	// not supported, and is ignored with NoGrowSync.
+	Preallocate bool
+
 	// If you want to read the entire database fast, you can set MmapFlag to
 	// syscall.MAP_POPULATE on Linux 2.6.23+ for sequential read-ahead.
 	MmapFlags int
@@ -120,6 +138,14 @@ type DB struct {
 	// of truncate() and fsync() when growing the data file.
 	AllocSize int
 
+	// MaxSize is the size in bytes the data file never grows beyond. Writes
+	// allocating pages past it fail with ErrDatabaseFull, and the last 1/32
+	// of it, at least 16 pages, is kept for transactions releasing at least
+	// as many pages as they allocate, such as deletes, so they can still
+	// commit. A database already larger is not truncated. If <=0, the data
+	// file grows without limit.
+	MaxSize int
+
 	// Mlock locks database file in memory when set to true.
 	// It prevents major page faults, however used memory can't be reclaimed.
 	//
@@ -129,10 +155,9 @@ type DB struct {
 	path     string
 	openFile func(string, int, os.FileMode) (*os.File, error)
 	file     *os.File
-	dataref  []byte // mmap'ed readonly, write throws SEGV
-	data     *[maxMapSize]byte
-	datasz   int
-	filesz   int // current on disk file size
+	segments atomic.Value // []*segment, mmap'ed readonly, write throws SEGV
+	datasz   int64        // total size of the segments
+	filesz   int64        // current on disk file size
 	meta0    *meta
 	meta1    *meta
 	pageSize int
@@ -146,21 +171,44 @@ type DB struct {
 
 	pagePool sync.Pool
 
+	// pageCache holds the pages read in PRead access mode, which doesn't
+	// map the file. The meta pages are then read into metaBuf.
+	pageCache *pageCache
+	metaBuf   []byte
+
+	// spans maps the pages whose overflow crosses a segment boundary, by
+	// page id. Replaced spans are kept in oldSpans since readers may still
+	// point into them. Both are unmapped once no open transaction used them.
+	spans    map[pgid]*span
+	oldSpans []*span
+
 	batchMu sync.Mutex
 	batch   *batch
 
 	rwlock   sync.Mutex   // Allows only one writer at a time.
 	metalock sync.Mutex   // Protects meta page access.
-	mmaplock sync.RWMutex // Protects mmap access during remapping.
+	mmaplock sync.RWMutex // Protects mmap access while closing.
+	spanlock sync.Mutex   // Protects spans access.
 	statlock sync.RWMutex // Protects stats access.
 
 	ops struct {
 		writeAt func(b []byte, off int64) (n int, err error)
+		resize  func(size int64) error
 	}
 
 	// Read only mode.
 	// When true, Update() and Begin(true) return ErrDatabaseReadOnly immediately.
 	readOnly bool
+
+	// When true, the meta page with the lower txid is used.
+	olderMeta bool
+
+	// When true, read-only transactions open buckets whose comparator is
+	// not registered.
+	rawKeyOrder bool
+
+	logger Logger
+	tracer Tracer
 }
 
 // Path returns the path to currently open database file.
@@ -191,10 +239,30 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	}
 	db.NoSync = options.NoSync
 	db.NoGrowSync = options.NoGrowSync
+	db.Preallocate = options.Preallocate
 	db.MmapFlags = options.MmapFlags
 	db.NoFreelistSync = options.NoFreelistSync
 	db.FreelistType = options.FreelistType
+	db.PrefixCompression = options.PrefixCompression
 	db.Mlock = options.Mlock
+	db.MaxSize = options.MaxSize
+	db.logger = options.Logger
+	db.tracer = options.Tracer
+
+	switch options.AccessMode {
+	case "", Mmap:
+	case PRead:
+		size := options.PageCacheSize
+		if size <= 0 {
+			size = DefaultPageCacheSize
+		}
+		db.pageCache = newPageCache(size)
+	default:
+		return nil, ErrUnknownAccessMode
+	}
+
+	lg := db.Logger()
+	lg.Infof("opening db file (%s) with mode %s", path, mode)
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = DefaultMaxBatchSize
@@ -206,6 +274,13 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		flag = os.O_RDONLY
 		db.readOnly = true
 	}
+	if options.OlderMeta {
+		if !options.ReadOnly {
+			return nil, ErrOlderMetaNotReadOnly
+		}
+		db.olderMeta = true
+	}
+	db.rawKeyOrder = options.RawKeyOrder
 
 	db.openFile = options.OpenFile
 	if db.openFile == nil {
@@ -216,6 +291,7 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	var err error
 	if db.file, err = db.openFile(path, flag|os.O_CREATE, mode); err != nil {
 		_ = db.close()
+		lg.Errorf("failed to open db file (%s): %v", path, err)
 		return nil, err
 	}
 	db.path = db.file.Name()
@@ -229,11 +305,13 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	// hold a lock at the same time) otherwise (options.ReadOnly is set).
 	if err := flock(db, !db.readOnly, options.Timeout); err != nil {
 		_ = db.close()
+		lg.Errorf("failed to lock db file (%s): %v", path, err)
 		return nil, err
 	}
 
 	// Default values for test hooks
 	db.ops.writeAt = db.file.WriteAt
+	db.ops.resize = db.resize
 
 	if db.pageSize = options.PageSize; db.pageSize == 0 {
 		// Set the default page size to the OS page size.
@@ -249,6 +327,7 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		if err := db.init(); err != nil {
 			// clean up file descriptor on initialization fail
 			_ = db.close()
+			lg.Errorf("failed to initialize db file (%s): %v", path, err)
 			return nil, err
 		}
 	} else {
@@ -266,9 +345,12 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		if bw, err := db.file.ReadAt(buf[:], 0); err == nil && bw == len(buf) {
 			if m := db.pageInBuffer(buf[:], 0).meta(); m.validate() == nil {
 				db.pageSize = int(m.pageSize)
+			} else {
+				lg.Warningf("meta page 0 is invalid, assuming page size %d", db.pageSize)
 			}
 		} else {
 			_ = db.close()
+			lg.Errorf("failed to read first meta page of db file (%s): %v", path, ErrInvalid)
 			return nil, ErrInvalid
 		}
 	}
@@ -281,12 +363,15 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	}
 
 	// Memory map the data file.
-	if err := db.mmap(options.InitialMmapSize); err != nil {
+	if err := db.mmap(int64(options.InitialMmapSize)); err != nil {
 		_ = db.close()
+		lg.Errorf("failed to map db file (%s): %v", path, err)
 		return nil, err
 	}
+	db.stats.Headroom = db.headroom(db.meta().pgid)
 
 	if db.readOnly {
+		lg.Infof("opened db file (%s) in read-only mode", path)
 		return db, nil
 	}
 
@@ -301,11 +386,13 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		}
 		if err != nil {
 			_ = db.close()
+			lg.Errorf("failed to persist freelist of db file (%s): %v", path, err)
 			return nil, err
 		}
 	}
 
 	// Mark the database as opened and return.
+	lg.Infof("opened db file (%s)", path)
 	return db, nil
 }
 
@@ -314,15 +401,20 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 // concurrent accesses being made to the freelist.
 func (db *DB) loadFreelist() {
 	db.freelistLoad.Do(func() {
+		lg := db.Logger()
 		db.freelist = newFreelist(db.FreelistType)
 		if !db.hasSyncedFreelist() {
 			// Reconstruct free list by scanning the DB.
+			lg.Infof("freelist is not synced, rebuilding it by scanning %d pages", db.meta().pgid)
+			start := time.Now()
 			db.freelist.readIDs(db.freepages())
+			lg.Infof("rebuilt freelist in %v", time.Since(start))
 		} else {
 			// Read free list from freelist page.
-			db.freelist.read(db.page(db.meta().freelist))
+			db.freelist.read(db.txPage(db.meta().freelist, db.meta().txid))
 		}
 		db.stats.FreePageN = db.freelist.free_count()
+		lg.Debugf("loaded freelist: %d free pages", db.stats.FreePageN)
 	})
 }
 
@@ -332,60 +424,109 @@ func (db *DB) hasSyncedFreelist() bool {
 
 // mmap opens the underlying memory-mapped file and initializes the meta references.
 // minsz is the minimum size that the new mmap can be.
-func (db *DB) mmap(minsz int) error {
-	db.mmaplock.Lock()
-	defer db.mmaplock.Unlock()
+//
+// The file is mapped as a list of segments. Growing the mmap maps one more
+// segment after the existing ones, which stay mapped until the database
+// closes, so growth doesn't wait for read transactions pointing into them.
+//
+// In PRead access mode, nothing is mapped: the meta pages are read into
+// memory and the size only sets how much the file grows.
+func (db *DB) mmap(minsz int64) (err error) {
+	endSpan := db.startSpan(TraceMmap, TraceAttrs{Size: int(minsz)})
+	defer func() { endSpan(TraceAttrs{Size: int(db.datasz)}, err) }()
 
 	info, err := db.file.Stat()
 	if err != nil {
 		return fmt.Errorf("mmap stat error: %s", err)
-	} else if int(info.Size()) < db.pageSize*2 {
+	} else if info.Size() < int64(db.pageSize*2) {
 		return fmt.Errorf("file size too small")
 	}
 
 	// Ensure the size is at least the minimum size.
-	fileSize := int(info.Size())
+	fileSize := info.Size()
 	var size = fileSize
 	if size < minsz {
 		size = minsz
 	}
-	size, err = db.mmapSize(size)
-	if err != nil {
-		return err
-	}
-
-	if db.Mlock {
-		// Unlock db memory
-		if err := db.munlock(fileSize); err != nil {
+	if db.pageCache != nil {
+		size = db.preadSize(size)
+	} else if size > maxMapSize {
+		return fmt.Errorf("mmap too large")
+	} else {
+		sz, err := db.mmapSize(int(size))
+		if err != nil {
 			return err
 		}
+		size = int64(sz)
 	}
 
-	// Dereference all mmap references before unmapping.
-	if db.rwtx != nil {
-		db.rwtx.root.dereference()
+	// Don't map past MaxSize, since the mmap truncates the file on Windows.
+	if limit := int64(db.MaxSize/db.pageSize) * int64(db.pageSize); limit > 0 && size > limit {
+		size = limit
+		if size < fileSize {
+			size = fileSize
+		}
+		if size < minsz {
+			size = minsz
+		}
 	}
-
-	// Unmap existing data before continuing.
-	if err := db.munmap(); err != nil {
-		return err
+	if size <= db.datasz {
+		return nil
 	}
 
-	// Memory-map the data file as a byte slice.
-	if err := mmap(db, size); err != nil {
-		return err
+	lg := db.Logger()
+	if db.datasz > 0 {
+		lg.Infof("remapping db file from %d to %d bytes (file size %d)", db.datasz, size, fileSize)
+	} else {
+		lg.Debugf("mapping %d bytes of db file (file size %d)", size, fileSize)
 	}
 
-	if db.Mlock {
-		// Don't allow swapping of data file
-		if err := db.mlock(fileSize); err != nil {
+	if db.pageCache != nil {
+		first := db.datasz == 0
+		db.datasz = size
+		if !first {
+			return nil
+		}
+		if err := db.readMetaPages(); err != nil {
 			return err
 		}
-	}
+	} else {
+		// Memory-map the rest of the data file as a new segment. It starts
+		// at an offset the OS can map, overlapping the previous segment if
+		// needed.
+		start := pgid(db.datasz / int64(db.pageSize))
+		off := int(start) * db.pageSize
+		off -= off % mmapAlign
+		b, err := mmap(db, off, int(size)-off)
+		if err != nil {
+			return err
+		}
+		segments := append(append([]*segment{}, db.loadSegments()...), &segment{
+			ref:   b,
+			off:   off,
+			data:  (*[maxMapSize]byte)(unsafe.Pointer(&b[int(start)*db.pageSize-off])),
+			start: start,
+			end:   pgid(size / int64(db.pageSize)),
+		})
+		db.segments.Store(segments)
+		db.datasz = size
+
+		if db.Mlock {
+			// Don't allow swapping of data file
+			if err := db.mlock(int(fileSize)); err != nil {
+				return err
+			}
+		}
 
-	// Save references to the meta pages.
-	db.meta0 = db.page(0).meta()
-	db.meta1 = db.page(1).meta()
+		// The meta pages are in the first segment.
+		if len(segments) > 1 {
+			return nil
+		}
+
+		// Save references to the meta pages.
+		db.meta0 = db.page(0).meta()
+		db.meta1 = db.page(1).meta()
+	}
 
 	// Validate the meta pages. We only return an error if both meta pages fail
 	// validation, since meta0 failing validation means that it wasn't saved
@@ -393,7 +534,24 @@ func (db *DB) mmap(minsz int) error {
 	err0 := db.meta0.validate()
 	err1 := db.meta1.validate()
 	if err0 != nil && err1 != nil {
+		lg.Errorf("both meta pages are invalid: meta0: %v, meta1: %v", err0, err1)
 		return err0
+	} else if err0 != nil {
+		lg.Warningf("meta page 0 is invalid (%v), falling back to meta page 1", err0)
+	} else if err1 != nil {
+		lg.Warningf("meta page 1 is invalid (%v), falling back to meta page 0", err1)
+	}
+
+	// The older meta page must be valid to be used.
+	if db.olderMeta {
+		older, err := db.meta0, err0
+		if db.meta0.txid > db.meta1.txid {
+			older, err = db.meta1, err1
+		}
+		if err != nil {
+			lg.Errorf("older meta page (txid %d) is invalid: %v", older.txid, err)
+			return err
+		}
 	}
 
 	return nil
@@ -401,8 +559,27 @@ func (db *DB) mmap(minsz int) error {
 
 // munmap unmaps the data file from memory.
 func (db *DB) munmap() error {
-	if err := munmap(db); err != nil {
-		return fmt.Errorf("unmap error: " + err.Error())
+	var merr error
+	unmap := func(b []byte) {
+		if err := munmap(b); err != nil && merr == nil {
+			merr = err
+		}
+	}
+	for _, s := range db.loadSegments() {
+		unmap(s.ref)
+	}
+	for _, s := range db.spans {
+		unmap(s.ref)
+	}
+	for _, s := range db.oldSpans {
+		unmap(s.ref)
+	}
+	db.segments.Store([]*segment(nil))
+	db.spans, db.oldSpans = nil, nil
+	db.datasz = 0
+
+	if merr != nil {
+		return fmt.Errorf("unmap error: " + merr.Error())
 	}
 	return nil
 }
@@ -444,6 +621,22 @@ func (db *DB) mmapSize(size int) (int, error) {
 	return int(sz), nil
 }
 
+// preadSize determines the size the file can grow to in PRead access mode.
+// It grows like the mmap size but without the limit of the address space.
+func (db *DB) preadSize(size int64) int64 {
+	if size <= maxMmapStep {
+		sz, _ := db.mmapSize(int(size))
+		return int64(sz)
+	}
+	if remainder := size % maxMmapStep; remainder > 0 {
+		size += maxMmapStep - remainder
+	}
+	if remainder := size % int64(db.pageSize); remainder > 0 {
+		size += int64(db.pageSize) - remainder
+	}
+	return size
+}
+
 func (db *DB) munlock(fileSize int) error {
 	if err := munlock(db, fileSize); err != nil {
 		return fmt.Errorf("munlock error: " + err.Error())
@@ -508,7 +701,7 @@ func (db *DB) init() error {
 	if err := fdatasync(db); err != nil {
 		return err
 	}
-	db.filesz = len(buf)
+	db.filesz = int64(len(buf))
 
 	return nil
 }
@@ -535,11 +728,14 @@ func (db *DB) close() error {
 	}
 
 	db.opened = false
+	lg := db.Logger()
+	lg.Infof("closing db file (%s)", db.path)
 
 	db.freelist = nil
 
 	// Clear ops.
 	db.ops.writeAt = nil
+	db.ops.resize = nil
 
 	// Close the mmap.
 	if err := db.munmap(); err != nil {
@@ -551,13 +747,18 @@ func (db *DB) close() error {
 		// No need to unlock read-only file.
 		if !db.readOnly {
 			// Unlock the file.
-			if err := funlock(db); err != nil {
+			// Without a configured logger, keep printing it with the
+			// standard logger as before loggers were configurable.
+			if err := funlock(db); err != nil && db.logger != nil {
+				lg.Errorf("bolt.Close(): funlock error: %s", err)
+			} else if err != nil {
 				log.Printf("bolt.Close(): funlock error: %s", err)
 			}
 		}
 
 		// Close the file descriptor.
 		if err := db.file.Close(); err != nil {
+			lg.Errorf("failed to close db file (%s): %v", db.path, err)
 			return fmt.Errorf("db file close: %s", err)
 		}
 		db.file = nil
@@ -573,14 +774,14 @@ func (db *DB) close() error {
 // will cause the calls to block and be serialized until the current write
 // transaction finishes.
 //
-// Transactions should not be dependent on one another. Opening a read
-// transaction and a write transaction in the same goroutine can cause the
-// writer to deadlock because the database periodically needs to re-mmap itself
-// as it grows and it cannot do that while a read transaction is open.
+// Transactions should not be dependent on one another. The database maps one
+// more segment of the file as it grows, without waiting for open read
+// transactions, but closing the database waits for all of them.
 //
-// If a long running read transaction (for example, a snapshot transaction) is
-// needed, you might want to set DB.InitialMmapSize to a large enough value
-// to avoid potential blocking of write transaction.
+// A page which can't be mapped or read makes the methods of the transaction,
+// its buckets and cursors panic with a *PageError. Unlike View and Update,
+// Begin leaves it to the caller to recover it; the transaction must then be
+// rolled back.
 //
 // IMPORTANT: You must close read-only transactions after you are finished or
 // else the database will not reclaim old pages.
@@ -597,9 +798,8 @@ func (db *DB) beginTx() (*Tx, error) {
 	// write transaction will obtain them.
 	db.metalock.Lock()
 
-	// Obtain a read-only lock on the mmap. When the mmap is remapped it will
-	// obtain a write lock so all transactions must finish before it can be
-	// remapped.
+	// Obtain a read-only lock on the mmap. Closing the database obtains a
+	// write lock so all transactions must finish before it is unmapped.
 	db.mmaplock.RLock()
 
 	// Exit if the database is not open yet.
@@ -669,6 +869,7 @@ func (db *DB) freePages() {
 	if minid > 0 {
 		db.freelist.release(minid - 1)
 	}
+	db.releaseSpans(minid)
 	// Release unused txid extents.
 	for _, t := range db.txs {
 		db.freelist.releaseRange(minid, t.meta.txid-1)
@@ -721,12 +922,15 @@ func (db *DB) removeTx(tx *Tx) {
 // returned from the Update() method.
 //
 // Attempting to manually commit or rollback within the function will cause a panic.
-func (db *DB) Update(fn func(*Tx) error) error {
+func (db *DB) Update(fn func(*Tx) error) (err error) {
 	t, err := db.Begin(true)
 	if err != nil {
 		return err
 	}
 
+	// Return an error if a page can't be read.
+	defer t.recoverPageError(&err)
+
 	// Make sure the transaction rolls back in the event of a panic.
 	defer func() {
 		if t.db != nil {
@@ -752,12 +956,15 @@ func (db *DB) Update(fn func(*Tx) error) error {
 // Any error that is returned from the function is returned from the View() method.
 //
 // Attempting to manually rollback within the function will cause a panic.
-func (db *DB) View(fn func(*Tx) error) error {
+func (db *DB) View(fn func(*Tx) error) (err error) {
 	t, err := db.Begin(false)
 	if err != nil {
 		return err
 	}
 
+	// Return an error if a page can't be read.
+	defer t.recoverPageError(&err)
+
 	// Make sure the transaction rolls back in the event of a panic.
 	defer func() {
 		if t.db != nil {
@@ -850,10 +1057,14 @@ func (b *batch) run() {
 	}
 	b.db.batchMu.Unlock()
 
+	var err error
+	endSpan := b.db.startSpan(TraceBatch, TraceAttrs{Calls: len(b.calls)})
+	defer func() { endSpan(TraceAttrs{Calls: len(b.calls)}, err) }()
+
 retry:
 	for len(b.calls) > 0 {
 		var failIdx = -1
-		err := b.db.Update(func(tx *Tx) error {
+		err = b.db.Update(func(tx *Tx) error {
 			for i, c := range b.calls {
 				if err := safelyCall(c.fn, tx); err != nil {
 					failIdx = i
@@ -871,6 +1082,7 @@ retry:
 			b.calls[failIdx], b.calls = b.calls[len(b.calls)-1], b.calls[:len(b.calls)-1]
 			// tell the submitter re-run it solo, continue with the rest of the batch
 			c.err <- trySolo
+			err = nil
 			continue retry
 		}
 
@@ -901,7 +1113,11 @@ func (p panicked) Error() string {
 func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
-			err = panicked{p}
+			if perr, ok := p.(*PageError); ok {
+				err = perr
+			} else {
+				err = panicked{p}
+			}
 		}
 	}()
 	return fn(tx)
@@ -918,19 +1134,160 @@ func (db *DB) Sync() error { return fdatasync(db) }
 func (db *DB) Stats() Stats {
 	db.statlock.RLock()
 	defer db.statlock.RUnlock()
-	return db.stats
+	s := db.stats
+	if db.pageCache != nil {
+		s.PageCacheHit, s.PageCacheMiss = db.pageCache.stats()
+	}
+	return s
 }
 
 // This is for internal access to the raw data bytes from the C cursor, use
 // carefully, or not at all.
+//
+// Data points to the first segment of the mmap only, and is zero in PRead
+// access mode.
 func (db *DB) Info() *Info {
-	return &Info{uintptr(unsafe.Pointer(&db.data[0])), db.pageSize}
+	if db.pageCache != nil {
+		return &Info{0, db.pageSize}
+	}
+	return &Info{uintptr(unsafe.Pointer(&db.loadSegments()[0].data[0])), db.pageSize}
 }
 
 // page retrieves a page reference from the mmap based on the current page size.
+// It is meant for the meta pages: a page crossing a segment boundary is not
+// pinned to any transaction, so it may be unmapped by the next write
+// transaction. Use txPage for the others.
 func (db *DB) page(id pgid) *page {
-	pos := id * pgid(db.pageSize)
-	return (*page)(unsafe.Pointer(&db.data[pos]))
+	return db.txPage(id, 0)
+}
+
+// txPage retrieves a page reference for the transaction txid. Pages whose
+// overflow crosses a segment boundary stay mapped until it closes.
+func (db *DB) txPage(id pgid, txid txid) *page {
+	if db.pageCache != nil {
+		return db.preadPage(id)
+	}
+
+	// Find the segment holding the page.
+	segments := db.loadSegments()
+	i, j := 0, len(segments)-1
+	for i < j {
+		if h := int(uint(i+j) >> 1); segments[h].end <= id {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	s := segments[i]
+	p := (*page)(unsafe.Pointer(&s.data[(id-s.start)*pgid(db.pageSize)]))
+
+	// Overflow pages running past the end of the segment are not contiguous
+	// in memory, so the whole run is mapped on its own.
+	if end := id + pgid(p.overflow) + 1; end > s.end && end <= segments[len(segments)-1].end {
+		return db.spanPage(id, int(p.overflow), txid)
+	}
+	return p
+}
+
+// span is the mapping of a page whose overflow crosses a segment boundary.
+type span struct {
+	ref  []byte
+	txid txid // latest transaction which used the span
+}
+
+// spanPage returns page id mapped along with its overflow pages, for the
+// transaction txid. It panics with a PageError if the page can't be mapped.
+func (db *DB) spanPage(id pgid, overflow int, txid txid) *page {
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+
+	// The mapping must start at an offset the OS can map.
+	off := int(id) * db.pageSize
+	start := off - off%mmapAlign
+	end := off + (overflow+1)*db.pageSize
+
+	if s, ok := db.spans[id]; ok {
+		if start+len(s.ref) == end {
+			if txid > s.txid {
+				s.txid = txid
+			}
+			return (*page)(unsafe.Pointer(&s.ref[off-start]))
+		}
+		db.oldSpans = append(db.oldSpans, s)
+	}
+
+	b, err := mmap(db, start, end-start)
+	if err != nil {
+		delete(db.spans, id)
+		panic(&PageError{PageID: int(id), Err: fmt.Errorf("mmap span error: %s", err)})
+	}
+	if db.spans == nil {
+		db.spans = make(map[pgid]*span)
+	}
+	db.spans[id] = &span{ref: b, txid: txid}
+	return (*page)(unsafe.Pointer(&b[off-start]))
+}
+
+// releaseSpans unmaps the spans which were last used by transactions before
+// minid, the oldest open transaction. Pages of the others may still be
+// referenced.
+func (db *DB) releaseSpans(minid txid) {
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+
+	lg := db.Logger()
+	unmap := func(s *span) bool {
+		if s.txid >= minid {
+			return false
+		}
+		if err := munmap(s.ref); err != nil {
+			lg.Warningf("failed to unmap span: %v", err)
+		}
+		return true
+	}
+	for id, s := range db.spans {
+		if unmap(s) {
+			delete(db.spans, id)
+		}
+	}
+	spans := db.oldSpans[:0]
+	for _, s := range db.oldSpans {
+		if !unmap(s) {
+			spans = append(spans, s)
+		}
+	}
+	for i := len(spans); i < len(db.oldSpans); i++ {
+		db.oldSpans[i] = nil
+	}
+	db.oldSpans = spans
+}
+
+// loadSegments returns the mapped segments of the data file.
+func (db *DB) loadSegments() []*segment {
+	segments, _ := db.segments.Load().([]*segment)
+	return segments
+}
+
+// segment is a memory-mapped region of the data file, mapped from offset off,
+// holding the pages from start up to end.
+type segment struct {
+	ref   []byte
+	off   int
+	data  *[maxMapSize]byte // points to page start
+	start pgid
+	end   pgid
+}
+
+// bytesBelow returns the mapped bytes of the segment below offset sz of the
+// data file, or nil if there are none.
+func (s *segment) bytesBelow(sz int) []byte {
+	n := sz - s.off
+	if n <= 0 {
+		return nil
+	} else if n > len(s.ref) {
+		n = len(s.ref)
+	}
+	return s.ref[:n]
 }
 
 // pageInBuffer retrieves a page reference from a given byte array based on the current page size.
@@ -950,6 +1307,11 @@ func (db *DB) meta() *meta {
 		metaB = db.meta0
 	}
 
+	// The older meta page was validated on mmap().
+	if db.olderMeta {
+		return metaB
+	}
+
 	// Use higher meta page if valid. Otherwise fallback to previous, if valid.
 	if err := metaA.validate(); err == nil {
 		return metaA
@@ -974,55 +1336,124 @@ func (db *DB) allocate(txid txid, count int) (*page, error) {
 	p := (*page)(unsafe.Pointer(&buf[0]))
 	p.overflow = uint32(count - 1)
 
+	var err error
+	if p.id, err = db.allocatePages(txid, count); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// allocatePages returns the id of the first page of count contiguous pages,
+// without a buffer for them.
+func (db *DB) allocatePages(txid txid, count int) (pgid, error) {
 	// Use pages from the freelist if they are available.
-	if p.id = db.freelist.allocate(txid, count); p.id != 0 {
-		return p, nil
+	if id := db.freelist.allocate(txid, count); id != 0 {
+		return id, nil
+	}
+
+	// Fail cleanly rather than growing the data file past MaxSize.
+	// Transactions which have released at least as many pages as they
+	// allocate, such as deletes, may use the reserved pages.
+	id := db.rwtx.meta.pgid
+	if db.MaxSize > 0 {
+		var freed int
+		if txp := db.freelist.pending[txid]; txp != nil {
+			freed = len(txp.ids)
+		}
+		if id+pgid(count)+1 > db.pageLimit(freed >= db.rwtx.stats.PageCount+count) {
+			return 0, ErrDatabaseFull
+		}
 	}
 
 	// Resize mmap() if we're at the end.
-	p.id = db.rwtx.meta.pgid
-	var minsz = int((p.id+pgid(count))+1) * db.pageSize
+	var minsz = int64((id+pgid(count))+1) * int64(db.pageSize)
 	if minsz >= db.datasz {
 		if err := db.mmap(minsz); err != nil {
-			return nil, fmt.Errorf("mmap allocate error: %s", err)
+			return 0, fmt.Errorf("mmap allocate error: %s", err)
 		}
 	}
 
 	// Move the page id high water mark.
 	db.rwtx.meta.pgid += pgid(count)
 
-	return p, nil
+	return id, nil
+}
+
+// pageLimit returns the number of pages the data file may hold under
+// MaxSize, leaving out the pages reserved at its end unless reserved is true.
+func (db *DB) pageLimit(reserved bool) pgid {
+	max := pgid(db.MaxSize / db.pageSize)
+	if reserved {
+		return max
+	}
+	reserve := max / 32
+	if reserve < 16 {
+		reserve = 16
+	}
+	if reserve >= max {
+		return 0
+	}
+	return max - reserve
+}
+
+// headroom returns the number of bytes the data file can still grow by
+// before writes fail with ErrDatabaseFull, or -1 without MaxSize.
+func (db *DB) headroom(hw pgid) int {
+	if db.MaxSize <= 0 {
+		return -1
+	}
+	limit := db.pageLimit(false)
+	if hw+1 >= limit {
+		return 0
+	}
+	return int(limit-hw-1) * db.pageSize
 }
 
 // grow grows the size of the database to the given sz.
-func (db *DB) grow(sz int) error {
+func (db *DB) grow(sz int64) (err error) {
 	// Ignore if the new size is less than available file size.
 	if sz <= db.filesz {
 		return nil
 	}
 
+	endSpan := db.startSpan(TraceGrow, TraceAttrs{Size: int(sz)})
+	defer func() { endSpan(TraceAttrs{Size: int(db.filesz)}, err) }()
+
 	// If the data is smaller than the alloc size then only allocate what's needed.
 	// Once it goes over the allocation size then allocate in chunks.
-	if db.datasz < db.AllocSize {
+	if db.datasz < int64(db.AllocSize) {
 		sz = db.datasz
 	} else {
-		sz += db.AllocSize
+		sz += int64(db.AllocSize)
 	}
+	if limit := int64(db.MaxSize); limit > 0 && sz > limit {
+		sz = limit
+	}
+
+	lg := db.Logger()
+	lg.Debugf("growing db file from %d to %d bytes", db.filesz, sz)
 
 	// Truncate and fsync to ensure file size metadata is flushed.
 	// https://github.com/boltdb/bolt/issues/284
 	if !db.NoGrowSync && !db.readOnly {
-		if runtime.GOOS != "windows" {
-			if err := db.file.Truncate(int64(sz)); err != nil {
+		// The mmap truncates the file on Windows.
+		if runtime.GOOS != "windows" || db.pageCache != nil {
+			if err := db.ops.resize(sz); err != nil {
+				lg.Errorf("failed to resize db file to %d bytes: %v", sz, err)
+				if isNoSpace(err) {
+					return ErrNoSpace
+				}
 				return fmt.Errorf("file resize error: %s", err)
 			}
 		}
 		if err := db.file.Sync(); err != nil {
+			lg.Errorf("failed to sync db file after resize: %v", err)
 			return fmt.Errorf("file sync error: %s", err)
 		}
 		if db.Mlock {
 			// unlock old file and lock new one
-			if err := db.mrelock(db.filesz, sz); err != nil {
+			if err := db.mrelock(int(db.filesz), int(sz)); err != nil {
+				lg.Errorf("failed to relock db file memory: %v", err)
 				return fmt.Errorf("mlock/munlock error: %s", err)
 			}
 		}
@@ -1032,10 +1463,33 @@ func (db *DB) grow(sz int) error {
 	return nil
 }
 
+// isNoSpace returns true if err reports that the disk is full.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// resize sets the size of the data file, reserving its blocks on disk if
+// Preallocate is set.
+func (db *DB) resize(sz int64) error {
+	if db.Preallocate {
+		return fallocate(db, sz)
+	}
+	return db.file.Truncate(sz)
+}
+
 func (db *DB) IsReadOnly() bool {
 	return db.readOnly
 }
 
+// Logger returns the logger used for internal events.
+// A no-op logger is returned if none was configured.
+func (db *DB) Logger() Logger {
+	if db.logger == nil {
+		return discardLogger
+	}
+	return db.logger
+}
+
 func (db *DB) freepages() []pgid {
 	tx, err := db.beginTx()
 	defer func() {
@@ -1056,7 +1510,7 @@ func (db *DB) freepages() []pgid {
 			panic(fmt.Sprintf("freepages: failed to get all reachable pages (%v)", e))
 		}
 	}()
-	tx.checkBucket(&tx.root, reachable, nofreed, ech)
+	tx.checkBucket(&tx.root, nil, reachable, nofreed, CheckOptions{}, ech)
 	close(ech)
 
 	var fids []pgid
@@ -1078,6 +1532,9 @@ type Options struct {
 	// Sets the DB.NoGrowSync flag before memory mapping the file.
 	NoGrowSync bool
 
+	// Sets the DB.Preallocate flag.
+	Preallocate bool
+
 	// Do not sync freelist to disk. This improves the database write performance
 	// under normal operation, but requires a full database re-sync during recovery.
 	NoFreelistSync bool
@@ -1089,17 +1546,42 @@ type Options struct {
 	// The default type is array
 	FreelistType FreelistType
 
+	// Sets the DB.PrefixCompression flag.
+	PrefixCompression bool
+
+	// Sets the DB.MaxSize limit.
+	MaxSize int
+
 	// Open database in read-only mode. Uses flock(..., LOCK_SH |LOCK_NB) to
 	// grab a shared lock (UNIX).
 	ReadOnly bool
 
+	// OlderMeta opens the database as of the meta page with the lower
+	// transaction id, which leaves out the last committed transaction.
+	// It requires ReadOnly. Open fails if that meta page is invalid.
+	OlderMeta bool
+
+	// RawKeyOrder opens the buckets created with a comparator which is not
+	// registered in read-only transactions, instead of failing with
+	// ErrUnknownComparator. Their keys are iterated in the order they are
+	// stored, but lookups scan the bucket and Seek only finds exact keys.
+	// It is meant for tools which can't register the comparators of the
+	// application owning the database.
+	RawKeyOrder bool
+
 	// Sets the DB.MmapFlags flag before memory mapping the file.
 	MmapFlags int
 
+	// AccessMode is the way pages are read from the data file. Mmap, the
+	// default, maps the file in memory. PRead reads pages with ReadAt into
+	// a page cache holding up to PageCacheSize pages, DefaultPageCacheSize
+	// if <=0, so the database can grow beyond the address space. Page
+	// cache hits and misses are counted in Stats.
+	AccessMode    AccessMode
+	PageCacheSize int
+
 	// InitialMmapSize is the initial mmap size of the database
-	// in bytes. Read transactions won't block write transaction
-	// if the InitialMmapSize is large enough to hold database mmap
-	// size. (See DB.Begin for more information)
+	// in bytes. Growing past it maps additional segments of the file.
 	//
 	// If <=0, the initial map size is 0.
 	// If initialMmapSize is smaller than the previous database size,
@@ -1122,6 +1604,15 @@ type Options struct {
 	// It prevents potential page faults, however
 	// used memory can't be reclaimed. (UNIX only)
 	Mlock bool
+
+	// Logger receives internal events such as remapping, file growth,
+	// freelist reloads and commit failures. If nil, events are discarded.
+	Logger Logger
+
+	// Tracer receives start and end callbacks around the phases of
+	// Tx.Commit, around remapping and growth of the data file, and around
+	// Batch runs. If nil, no tracing is performed.
+	Tracer Tracer
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -1144,6 +1635,13 @@ type Stats struct {
 	TxN     int // total number of started read transactions
 	OpenTxN int // number of currently open read transactions
 
+	// Page cache stats, in PRead access mode
+	PageCacheHit  int // total number of pages read from the page cache
+	PageCacheMiss int // total number of pages read from the data file
+
+	// Size stats, with Options.MaxSize
+	Headroom int // bytes the data file can still grow by before ErrDatabaseFull, -1 without MaxSize
+
 	TxStats TxStats // global, ongoing stats.
 }
 
@@ -1160,6 +1658,9 @@ func (s *Stats) Sub(other *Stats) Stats {
 	diff.FreeAlloc = s.FreeAlloc
 	diff.FreelistInuse = s.FreelistInuse
 	diff.TxN = s.TxN - other.TxN
+	diff.PageCacheHit = s.PageCacheHit - other.PageCacheHit
+	diff.PageCacheMiss = s.PageCacheMiss - other.PageCacheMiss
+	diff.Headroom = s.Headroom
 	diff.TxStats = s.TxStats.Sub(&other.TxStats)
 	return diff
 }
@@ -461,6 +461,234 @@ func TestDB_Open_InitialMmapSize(t *testing.T) {
 	}
 }
 
+// Ensure that a write transaction growing the mmap doesn't wait for an open
+// read transaction, which keeps reading its own snapshot.
+func TestDB_Grow_ReadTxOpen(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 64; i++ {
+				if err := b.Put(u64tob(uint64(i)), make([]byte, 1<<20)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("unexpected that the reader blocks writer")
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := rtx.Bucket([]byte("widgets"))
+	if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("unexpected value: %q", v)
+	} else if n := b.Stats().KeyN; n != 1 {
+		t.Fatalf("unexpected key count: %d", n)
+	}
+	if err := rtx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that values whose overflow pages cross the boundary between two mmap
+// segments can be read.
+func TestDB_Grow_ValueAcrossSegments(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	value := func(i int) []byte {
+		v := make([]byte, 100000+i*37)
+		for j := range v {
+			v[j] = byte(i + j)
+		}
+		return v
+	}
+
+	// Each update grows the mmap by one segment.
+	for i := 0; i < 8; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put(u64tob(uint64(i)), value(i))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		if err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 8; i++ {
+				if v := b.Get(u64tob(uint64(i))); !bytes.Equal(v, value(i)) {
+					t.Fatalf("unexpected value %d", i)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		db.MustCheck()
+	}
+	check()
+
+	// The whole file is a single segment once reopened.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+	check()
+}
+
+// Ensure a database growing with Preallocate keeps its data.
+func TestDB_Grow_Preallocate(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{Preallocate: true})
+	defer db.MustClose()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put(u64tob(uint64(i)), make([]byte, 100000))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fi, err := os.Stat(db.f); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() < 10*100000 {
+		t.Fatalf("unexpected file size: %d", fi.Size())
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 10 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+}
+
+// Ensure writes fail with ErrDatabaseFull once the file reaches MaxSize, while
+// deletes still commit.
+func TestDB_MaxSize(t *testing.T) {
+	const maxSize = 1 << 20
+	db := MustOpenWithOption(&bolt.Options{MaxSize: maxSize})
+	defer db.MustClose()
+
+	if h := db.Stats().Headroom; h <= 0 || h > maxSize {
+		t.Fatalf("unexpected headroom: %d", h)
+	}
+
+	// Fill the database until it is full.
+	var n int
+	for {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 10; i++ {
+				if err := b.Put(u64tob(uint64(n+i)), make([]byte, 1000)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == bolt.ErrDatabaseFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n += 10
+	}
+	if n == 0 {
+		t.Fatal("expected some writes to commit")
+	}
+	if h := db.Stats().Headroom; h >= maxSize/8 {
+		t.Fatalf("unexpected headroom once full: %d", h)
+	}
+
+	// The failed transaction was rolled back.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get(u64tob(uint64(n))); v != nil {
+			t.Fatalf("unexpected value of rolled back write")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deletes still commit, and free space for writes.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < n/2; i++ {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(0), make([]byte, 1000))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	if fi, err := os.Stat(db.f); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() > maxSize {
+		t.Fatalf("file size %d exceeds MaxSize", fi.Size())
+	}
+}
+
+// Ensure Stats reports no headroom limit without MaxSize.
+func TestDB_Stats_Headroom_Unlimited(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if h := db.Stats().Headroom; h != -1 {
+		t.Fatalf("unexpected headroom: %d", h)
+	}
+}
+
 // TestDB_Open_ReadOnly checks a database in read only mode can read but not write.
 func TestDB_Open_ReadOnly(t *testing.T) {
 	// Create a writable db, write k-v and close it.
@@ -515,6 +743,146 @@ func TestDB_Open_ReadOnly(t *testing.T) {
 	}
 }
 
+// Ensure that a database opened in PRead access mode reads its pages through
+// a bounded page cache, while readers keep their snapshot.
+func TestOpen_PRead(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{AccessMode: bolt.PRead, PageCacheSize: 8})
+	defer db.MustClose()
+
+	value := func(i int) []byte {
+		v := make([]byte, 10+(i%4)*5000)
+		for j := range v {
+			v[j] = byte(i * j)
+		}
+		return v
+	}
+	put := func(from, to int) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for i := from; i < to; i++ {
+				if err := b.Put(u64tob(uint64(i)), value(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(tx *bolt.Tx, n int) {
+		b := tx.Bucket([]byte("widgets"))
+		if got := b.Stats().KeyN; got != n {
+			t.Fatalf("unexpected key count: %d", got)
+		}
+		for i := 0; i < n; i++ {
+			if v := b.Get(u64tob(uint64(i))); !bytes.Equal(v, value(i)) {
+				t.Fatalf("unexpected value %d", i)
+			}
+		}
+	}
+
+	put(0, 500)
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(500, 1000)
+	check(rtx, 500)
+	if err := rtx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx, 1000)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if s := db.Stats(); s.PageCacheHit == 0 || s.PageCacheMiss == 0 {
+		t.Fatalf("unexpected page cache stats: hit=%d miss=%d", s.PageCacheHit, s.PageCacheMiss)
+	}
+	if info := db.Info(); info.Data != 0 {
+		t.Fatalf("unexpected data pointer: %x", info.Data)
+	}
+
+	// Reopen in the default access mode.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.o = nil
+	db.MustReopen()
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx, 1000)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that opening a database with an unknown access mode fails.
+func TestOpen_UnknownAccessMode(t *testing.T) {
+	path := tempfile()
+	defer os.RemoveAll(path)
+
+	if _, err := bolt.Open(path, 0666, &bolt.Options{AccessMode: "bogus"}); err != bolt.ErrUnknownAccessMode {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that a database can be opened as of its older meta page.
+func TestOpen_OlderMeta(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	for _, value := range []string{"bar", "baz"} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), []byte(value))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bolt.Open(db.f, 0666, &bolt.Options{OlderMeta: true}); err != bolt.ErrOlderMetaNotReadOnly {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	olderDB, err := bolt.Open(db.f, 0666, &bolt.Options{ReadOnly: true, OlderMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := olderDB.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(value, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", value)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := olderDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newer meta page is used again without the option.
+	db.MustReopen()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(value, []byte("baz")) {
+			t.Fatalf("unexpected value: %q", value)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestOpen_BigPage checks the database uses bigger pages when
 // changing PageSize.
 func TestOpen_BigPage(t *testing.T) {
@@ -531,6 +899,63 @@ func TestOpen_BigPage(t *testing.T) {
 	}
 }
 
+// Ensure that internal events are reported to the configured logger.
+func TestOpen_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	lg := &bolt.DefaultLogger{Logger: log.New(&buf, "", 0)}
+	lg.EnableDebug()
+
+	path := tempfile()
+	defer os.RemoveAll(path)
+
+	// The second write skips the freelist sync so the final open has to
+	// rebuild the freelist by scanning the file.
+	for _, noFreelistSync := range []bool{false, true, true} {
+		db, err := bolt.Open(path, 0666, &bolt.Options{Logger: lg, NoFreelistSync: noFreelistSync})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), make([]byte, 1<<20))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, msg := range []string{
+		"INFO: opening db file",
+		"INFO: opened db file",
+		"DEBUG: mapping",
+		"INFO: remapping db file",
+		"DEBUG: growing db file",
+		"INFO: freelist is not synced",
+		"INFO: closing db file",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(msg)) {
+			t.Errorf("expected log to contain %q:\n%s", msg, buf.String())
+		}
+	}
+}
+
+// Ensure the default logger only prints timestamps once enabled.
+func TestDefaultLogger_EnableTimestamps(t *testing.T) {
+	lg := bolt.NewDefaultLogger()
+	if flags := lg.Flags(); flags != 0 {
+		t.Fatalf("unexpected flags: %d", flags)
+	}
+	lg.EnableTimestamps()
+	if flags := lg.Flags(); flags != log.LstdFlags {
+		t.Fatalf("unexpected flags: %d", flags)
+	}
+}
+
 // TestOpen_RecoverFreeList tests opening the DB with free-list
 // write-out after no free list sync will recover the free list
 // and write it out.
@@ -1620,6 +2045,14 @@ type DB struct {
 	o *bolt.Options
 }
 
+// testAccessMode is used as an env variable for tests to select the access
+// mode.
+const testAccessMode = "TEST_ACCESS_MODE"
+
+// testPrefixCompression is used as an env variable for tests to enable
+// prefix compression.
+const testPrefixCompression = "TEST_PREFIX_COMPRESSION"
+
 // MustOpenDB returns a new, open DB at a temporary location.
 func MustOpenDB() *DB {
 	return MustOpenWithOption(nil)
@@ -1638,6 +2071,14 @@ func MustOpenWithOption(o *bolt.Options) *DB {
 	}
 	o.FreelistType = freelistType
 
+	if env := os.Getenv(testAccessMode); env == string(bolt.PRead) {
+		o.AccessMode = bolt.PRead
+	}
+
+	if env := os.Getenv(testPrefixCompression); env == "true" {
+		o.PrefixCompression = true
+	}
+
 	db, err := bolt.Open(f, 0666, o)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,291 @@
+package bbolt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Ensure spans of pages crossing a segment boundary are unmapped once no open
+// transaction used them.
+func TestDB_releaseSpans(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Each update grows the mmap by one segment, so some values cross
+	// segment boundaries.
+	for i := 0; i < 8; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte{byte(i)}, make([]byte, 100000+i*37))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spans := func() int {
+		db.spanlock.Lock()
+		defer db.spanlock.Unlock()
+		return len(db.spans) + len(db.oldSpans)
+	}
+	read := func(tx *Tx) {
+		if err := tx.Bucket([]byte("widgets")).ForEach(func(_, _ []byte) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update := func() {
+		if err := db.Update(func(*Tx) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Spans used by an open transaction are kept.
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(tx)
+	if spans() == 0 {
+		t.Fatal("expected spans")
+	}
+	update()
+	if spans() == 0 {
+		t.Fatal("spans of an open transaction released")
+	}
+
+	// They are released once it closes.
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	update()
+	if n := spans(); n != 0 {
+		t.Fatalf("unexpected spans: %d", n)
+	}
+}
+
+// Ensure a page span which can't be mapped in a transaction started with Begin
+// panics with a PageError the caller can recover, and that the transaction
+// can then be rolled back.
+func TestDB_spanPage_Error(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 8; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte{byte(i)}, make([]byte, 100000+i*37))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unmap the spans used so far, and map through a closed file.
+	if err := db.Update(func(*Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := db.file
+	db.file = f
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				perr, ok := p.(*PageError)
+				if !ok {
+					panic(p)
+				}
+				err = perr
+			}
+		}()
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		}
+		return nil
+	}
+	err = read()
+	db.file = file
+	if perr, ok := err.(*PageError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if perr.PageID < 2 {
+		t.Fatalf("unexpected page id: %d", perr.PageID)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is still usable.
+	if err := db.View(func(tx *Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(_, _ []byte) error { return nil })
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a page which can't be read in PRead access mode fails the
+// transaction with a PageError rather than crashing.
+func TestDB_preadPage_Error(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, &Options{AccessMode: PRead, PageCacheSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read through a closed file.
+	f, err := os.Open(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := db.file
+	db.file = f
+	err = db.View(func(tx *Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(_, _ []byte) error { return nil })
+	})
+	db.file = file
+	if perr, ok := err.(*PageError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if perr.PageID < 2 {
+		t.Fatalf("unexpected page id: %d", perr.PageID)
+	}
+
+	// The database is still usable.
+	if err := db.View(func(tx *Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(_, _ []byte) error { return nil })
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a full disk while growing the data file fails the transaction with
+// ErrNoSpace and leaves the database usable.
+func TestDB_grow_ErrNoSpace(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, &Options{Preallocate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	resize := db.ops.resize
+	db.ops.resize = func(size int64) error {
+		return &os.PathError{Op: "fallocate", Path: db.Path(), Err: syscall.ENOSPC}
+	}
+	put := func(key string) error {
+		return db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(key), make([]byte, 1<<20))
+		})
+	}
+	if err := put("full"); err != ErrNoSpace {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db.ops.resize = resize
+	if err := put("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("full")); v != nil {
+			t.Fatal("unexpected value of the failed transaction")
+		} else if v := b.Get([]byte("foo")); len(v) != 1<<20 {
+			t.Fatalf("unexpected value length: %d", len(v))
+		}
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a full disk while writing a value set by PutReader fails with
+// ErrNoSpace, whether it is reported as ENOSPC or as a short write.
+func TestTx_writeBlob_ErrNoSpace(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	writeAt := db.ops.writeAt
+	defer func() { db.ops.writeAt = writeAt }()
+	for _, fail := range []func(b []byte, off int64) (int, error){
+		func(b []byte, off int64) (int, error) {
+			return 0, &os.PathError{Op: "write", Path: db.Path(), Err: syscall.ENOSPC}
+		},
+		func(b []byte, off int64) (int, error) {
+			return len(b) / 2, nil
+		},
+	} {
+		db.ops.writeAt = fail
+		err := db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.PutReader([]byte("blob"), bytes.NewReader(make([]byte, 100000)), 100000)
+		})
+		if !errors.Is(err, ErrNoSpace) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		if tx.Bucket([]byte("widgets")) != nil {
+			t.Fatal("unexpected bucket of the failed transactions")
+		}
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,420 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// Buckets created with BucketOptions.DupSort hold a sorted set of values per
+// key. The values of a key are packed in its leaf value, flagged with
+// dupLeafFlag, while they are small. Larger sets are moved to a nested tree,
+// flagged with bucketLeafFlag|dupLeafFlag, whose keys are the values and
+// whose sequence is the number of values.
+
+// PutDup adds a value to the values of a key in a bucket created with
+// DupSort. Values are ordered by bytes.Compare and kept once: adding a value
+// which already exists does nothing. Returns ErrIncompatibleValue if the
+// bucket was not created with DupSort, ErrValueRequired if the value is
+// blank and ErrValueTooLarge if it is larger than MaxKeySize.
+func (b *Bucket) PutDup(key, value []byte) error {
+	if err := b.checkDup(key, value); err != nil {
+		return err
+	}
+
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+	if !bytes.Equal(key, k) {
+		key = cloneBytes(key)
+		c.node().put(key, key, encodeDups([][]byte{value}), 0, dupLeafFlag)
+		return nil
+	}
+
+	if (flags & bucketLeafFlag) != 0 {
+		tree := b.openDupTree(key, v)
+		tc := tree.Cursor()
+		if tk, _, _ := tc.seek(value); bytes.Equal(tk, value) {
+			return nil
+		}
+		value = cloneBytes(value)
+		tc.node().put(value, value, nil, 0, 0)
+		tree.bucket.sequence++
+		return nil
+	}
+
+	values := decodeDups(v)
+	i := sort.Search(len(values), func(i int) bool { return bytes.Compare(values[i], value) != -1 })
+	if i < len(values) && bytes.Equal(values[i], value) {
+		return nil
+	}
+	values = append(values, nil)
+	copy(values[i+1:], values[i:])
+	values[i] = value
+
+	key = cloneBytes(key)
+	if encoded := encodeDups(values); len(encoded) <= b.maxDupSize() {
+		c.node().put(key, key, encoded, 0, dupLeafFlag)
+		return nil
+	}
+
+	// Move the values to a nested tree.
+	var tree = Bucket{bucket: &bucket{}, rootNode: &node{isLeaf: true}, dupTree: true}
+	var treeValue = tree.write()
+	c.node().put(key, key, treeValue, 0, tree.leafFlags())
+	b.page = nil
+	t := b.openDupTree(key, treeValue)
+	for _, value := range values {
+		value = cloneBytes(value)
+		tc := t.Cursor()
+		tc.seek(value)
+		tc.node().put(value, value, nil, 0, 0)
+	}
+	t.bucket.sequence = uint64(len(values))
+	return nil
+}
+
+// DeleteDup removes a value from the values of a key in a bucket created with
+// DupSort. The key is removed with its last value. If the key or the value
+// does not exist then nothing is done and a nil error is returned.
+func (b *Bucket) DeleteDup(key, value []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if !b.dupSort {
+		return ErrIncompatibleValue
+	}
+
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+	if !bytes.Equal(key, k) {
+		return nil
+	}
+
+	var values [][]byte
+	if (flags & bucketLeafFlag) != 0 {
+		tree := b.openDupTree(key, v)
+		tc := tree.Cursor()
+		if tk, _, _ := tc.seek(value); !bytes.Equal(tk, value) {
+			return nil
+		}
+		tc.node().del(value)
+		tree.bucket.sequence--
+
+		// Move the values back to the leaf once they are small enough,
+		// leaving room to grow before moving them again.
+		size := 0
+		for tk, _ := tc.First(); tk != nil; tk, _ = tc.Next() {
+			if size += dupSize(tk); size > b.maxDupSize()/2 {
+				return nil
+			}
+			values = append(values, tk)
+		}
+		b.freeDupTree(key, v)
+	} else {
+		values = decodeDups(v)
+		i := sort.Search(len(values), func(i int) bool { return bytes.Compare(values[i], value) != -1 })
+		if i == len(values) || !bytes.Equal(values[i], value) {
+			return nil
+		}
+		values = append(values[:i], values[i+1:]...)
+	}
+
+	if len(values) == 0 {
+		c.node().del(key)
+		return nil
+	}
+	key = cloneBytes(key)
+	c.node().put(key, key, encodeDups(values), 0, dupLeafFlag)
+	return nil
+}
+
+// DupSort returns true if the bucket holds several sorted values per key.
+func (b *Bucket) DupSort() bool {
+	return b.dupSort
+}
+
+// checkDup returns an error if value cannot be added to the values of key.
+func (b *Bucket) checkDup(key, value []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if !b.dupSort {
+		return ErrIncompatibleValue
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if len(value) == 0 {
+		return ErrValueRequired
+	} else if len(value) > MaxKeySize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// putDup replaces the values of a key in a bucket created with DupSort by a
+// single value, as Put does.
+func (b *Bucket) putDup(key, value []byte) error {
+	if err := b.checkDup(key, value); err != nil {
+		return err
+	}
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+	if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
+		b.freeDupTree(key, v)
+	}
+	key = cloneBytes(key)
+	c.node().put(key, key, encodeDups([][]byte{value}), 0, dupLeafFlag)
+	return nil
+}
+
+// openDupTree returns the nested tree holding the values of key, whose leaf value
+// is v.
+func (b *Bucket) openDupTree(key, v []byte) *Bucket {
+	if child := b.buckets[string(key)]; child != nil {
+		return child
+	}
+	child := b.openBucket(v, bucketLeafFlag|dupLeafFlag)
+	if b.buckets != nil {
+		b.buckets[string(key)] = child
+	}
+	return child
+}
+
+// freeDupTree releases the pages of the nested tree holding the values of
+// key, whose leaf value is v.
+func (b *Bucket) freeDupTree(key, v []byte) {
+	tree := b.openDupTree(key, v)
+	delete(b.buckets, string(key))
+	tree.nodes = nil
+	tree.rootNode = nil
+	tree.free()
+}
+
+// maxDupSize returns the maximum size of the packed values of a key.
+func (b *Bucket) maxDupSize() int {
+	return b.tx.db.pageSize / 4
+}
+
+// dupSize returns the size of a packed value.
+func dupSize(value []byte) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(len(value))) + len(value)
+}
+
+// encodeDups packs sorted values, each prefixed with its length.
+func encodeDups(values [][]byte) []byte {
+	size := 0
+	for _, v := range values {
+		size += dupSize(v)
+	}
+	buf := make([]byte, size)
+	off := 0
+	for _, v := range values {
+		off += binary.PutUvarint(buf[off:], uint64(len(v)))
+		off += copy(buf[off:], v)
+	}
+	return buf
+}
+
+// decodeDups returns the values packed in buf. It returns nil if buf is
+// malformed.
+func decodeDups(buf []byte) [][]byte {
+	var values [][]byte
+	for len(buf) > 0 {
+		n, sz := binary.Uvarint(buf)
+		if sz <= 0 || n > uint64(len(buf)-sz) {
+			return nil
+		}
+		values = append(values, buf[sz:sz+int(n)])
+		buf = buf[sz+int(n):]
+	}
+	return values
+}
+
+// validDups returns true if buf holds values packed in strictly increasing
+// order.
+func validDups(buf []byte) bool {
+	values := decodeDups(buf)
+	if len(values) == 0 {
+		return false
+	}
+	for i, v := range values {
+		if len(v) == 0 || (i > 0 && bytes.Compare(values[i-1], v) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// dupCursor is the position of a cursor among the values of its current
+// key. Keys of a bucket without DupSort have a single value.
+type dupCursor struct {
+	values [][]byte // values of the key, unless they are in a tree
+	index  int      // position in values
+
+	tree  *Cursor // cursor over the tree holding the values, if any
+	count int
+}
+
+// newDupCursor returns the position on the first value of the current key of
+// the cursor, or nil if the cursor is not on a key.
+func (c *Cursor) newDupCursor() *dupCursor {
+	k, v, flags := c.keyValue()
+	if k == nil {
+		return nil
+	}
+	switch {
+	case (flags & (bucketLeafFlag | dupLeafFlag)) == bucketLeafFlag|dupLeafFlag:
+		tree := c.bucket.openDupTree(k, v)
+		d := &dupCursor{tree: tree.Cursor(), count: int(tree.sequence)}
+		d.tree.First()
+		return d
+	case (flags & dupLeafFlag) != 0:
+		values := decodeDups(v)
+		return &dupCursor{values: values, count: len(values)}
+	case (flags & (bucketLeafFlag | blobLeafFlag)) != 0:
+		return &dupCursor{values: [][]byte{nil}, count: 1}
+	}
+	return &dupCursor{values: [][]byte{v}, count: 1}
+}
+
+// value returns the current value.
+func (d *dupCursor) value() []byte {
+	if d.tree != nil {
+		k, _, _ := d.tree.keyValue()
+		return k
+	}
+	return d.values[d.index]
+}
+
+// next moves to the next value, or returns false at the last one.
+func (d *dupCursor) next() bool {
+	if d.tree != nil {
+		k, _ := d.tree.Next()
+		return k != nil
+	} else if d.index == len(d.values)-1 {
+		return false
+	}
+	d.index++
+	return true
+}
+
+// prev moves to the previous value, or returns false at the first one.
+func (d *dupCursor) prev() bool {
+	if d.tree != nil {
+		if k, _ := d.tree.Prev(); k == nil {
+			d.tree.First()
+			return false
+		}
+		return true
+	} else if d.index == 0 {
+		return false
+	}
+	d.index--
+	return true
+}
+
+// seek moves to the first value not less than value, or returns false if
+// there is none.
+func (d *dupCursor) seek(value []byte) bool {
+	if d.tree != nil {
+		k, _ := d.tree.Seek(value)
+		return k != nil
+	}
+	i := sort.Search(len(d.values), func(i int) bool { return bytes.Compare(d.values[i], value) != -1 })
+	if i == len(d.values) {
+		return false
+	}
+	d.index = i
+	return true
+}
+
+// NextDup moves the cursor to the next value of its current key and returns
+// the key and value. In buckets created with DupSort, the other methods move
+// between keys and return their first value. If the cursor is on the last
+// value of the key then a nil key and value are returned and the cursor
+// stays there.
+func (c *Cursor) NextDup() (key []byte, value []byte) {
+	_assert(c.bucket.tx.db != nil, "tx closed")
+	return c.moveDup((*dupCursor).next)
+}
+
+// PrevDup moves the cursor to the previous value of its current key and
+// returns the key and value. If the cursor is on the first value of the key
+// then a nil key and value are returned and the cursor stays there.
+func (c *Cursor) PrevDup() (key []byte, value []byte) {
+	_assert(c.bucket.tx.db != nil, "tx closed")
+	return c.moveDup((*dupCursor).prev)
+}
+
+// moveDup moves the cursor among the values of its current key with move.
+func (c *Cursor) moveDup(move func(*dupCursor) bool) ([]byte, []byte) {
+	if c.dup == nil {
+		if c.dup = c.newDupCursor(); c.dup == nil {
+			return nil, nil
+		}
+	}
+	if !move(c.dup) {
+		return nil, nil
+	}
+	k, _, _ := c.keyValue()
+	return k, c.dup.value()
+}
+
+// seekDupAfter moves the cursor to the first value of key greater than value,
+// or to the first value of the next key if there is none. It repositions the
+// cursor after one of its values was deleted, which may have changed how the
+// values of the key are stored.
+func (c *Cursor) seekDupAfter(key, value []byte) {
+	c.dup = nil
+	k, _, _ := c.seek(key)
+	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+		k, _, _ = c.next()
+	}
+	if k == nil {
+		return
+	}
+	c.dup = c.newDupCursor()
+	if !bytes.Equal(k, key) || c.dup.seek(value) {
+		return
+	}
+	c.dup = nil
+	if k, _, _ = c.next(); k == nil {
+		// Leave the cursor past the last key, like a deleted last key does.
+		ref := &c.stack[len(c.stack)-1]
+		ref.index = ref.count()
+		return
+	}
+	c.dup = c.newDupCursor()
+}
+
+// SeekBoth moves the cursor to a given key and to its first value not less
+// than the given value, and returns them. If the key does not exist, or if
+// all its values are less than value, a nil key and value are returned.
+func (c *Cursor) SeekBoth(key, value []byte) ([]byte, []byte) {
+	k, _, _ := c.seek(key)
+	c.dup = nil
+	if !bytes.Equal(key, k) {
+		return nil, nil
+	}
+	c.dup = c.newDupCursor()
+	if !c.dup.seek(value) {
+		return nil, nil
+	}
+	return k, c.dup.value()
+}
+
+// CountDups returns the number of values of the current key of the cursor.
+// It is always one for keys of buckets created without DupSort, and zero if
+// the cursor is not on a key.
+func (c *Cursor) CountDups() int {
+	if c.dup == nil {
+		if c.dup = c.newDupCursor(); c.dup == nil {
+			return 0
+		}
+	}
+	return c.dup.count
+}
@@ -1,6 +1,9 @@
 package bbolt
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // These errors can be returned when opening or calling methods on a DB.
 var (
@@ -26,6 +29,14 @@ var (
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
+
+	// ErrOlderMetaNotReadOnly is returned when opening a database with the
+	// OlderMeta option but without the ReadOnly option.
+	ErrOlderMetaNotReadOnly = errors.New("older meta requires read-only mode")
+
+	// ErrUnknownAccessMode is returned when opening a database with an
+	// unknown AccessMode option.
+	ErrUnknownAccessMode = errors.New("unknown access mode")
 )
 
 // These errors can occur when beginning or committing a Tx.
@@ -41,6 +52,16 @@ var (
 	// ErrDatabaseReadOnly is returned when a mutating transaction is started on a
 	// read-only database.
 	ErrDatabaseReadOnly = errors.New("database is in read-only mode")
+
+	// ErrDatabaseFull is returned when committing a transaction, or writing
+	// a value with PutReader, would grow the data file past Options.MaxSize.
+	// The transaction is rolled back.
+	ErrDatabaseFull = errors.New("database full")
+
+	// ErrNoSpace is returned when committing a transaction if the disk has
+	// no space left to grow the data file, and by Bucket.PutReader if it has
+	// no space left to write the value. The transaction is rolled back.
+	ErrNoSpace = errors.New("no space left on device to grow the database")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -64,8 +85,30 @@ var (
 	// ErrValueTooLarge is returned when inserting a value that is larger than MaxValueSize.
 	ErrValueTooLarge = errors.New("value too large")
 
+	// ErrValueRequired is returned when inserting a zero-length value in a
+	// bucket created with DupSort.
+	ErrValueRequired = errors.New("value required")
+
 	// ErrIncompatibleValue is returned when trying create or delete a bucket
 	// on an existing non-bucket key or when trying to create or delete a
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
+
+	// ErrUnknownComparator is returned when creating or opening a bucket with
+	// a comparator which is not registered.
+	ErrUnknownComparator = errors.New("unknown comparator")
 )
+
+// PageError is raised with panic when a page can't be read from the data
+// file. View, Update, Batch and Commit recover it and return it as an error,
+// after rolling back the transaction. With transactions started with Begin,
+// the panic reaches the caller, which should recover it and roll back.
+type PageError struct {
+	PageID int   // id of the page
+	Err    error // cause of the failure
+}
+
+// Error returns the formatted error.
+func (e *PageError) Error() string {
+	return fmt.Sprintf("page %d: %s", e.PageID, e.Err)
+}
@@ -0,0 +1,190 @@
+package bbolt
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ExportEncoding is the encoding of bucket names, keys and values in an
+// export stream.
+type ExportEncoding string
+
+const (
+	// ExportBase64 encodes bytes in standard base64.
+	ExportBase64 = ExportEncoding("base64")
+	// ExportHex encodes bytes in lowercase hexadecimal.
+	ExportHex = ExportEncoding("hex")
+)
+
+// ExportVersion is the version of the export stream format.
+const ExportVersion = 1
+
+// Types of ExportRecord.
+const (
+	ExportHeaderRecord = "header"
+	ExportBucketRecord = "bucket"
+	ExportKeyRecord    = "key"
+)
+
+// ExportRecord is a single line of an export stream. The stream starts with a
+// header record, followed by one record per bucket or key in depth-first key
+// order, a bucket record always preceding the records of its content.
+type ExportRecord struct {
+	// Type is one of ExportHeaderRecord, ExportBucketRecord or
+	// ExportKeyRecord.
+	Type string `json:"type"`
+
+	// Version and Encoding are only set on the header record.
+	Version  int            `json:"version,omitempty"`
+	Encoding ExportEncoding `json:"encoding,omitempty"`
+
+	// Bucket is the encoded path of the bucket containing the bucket or
+	// key. It is empty for top-level buckets.
+	Bucket []string `json:"bucket,omitempty"`
+
+	// Key is the encoded bucket name or key.
+	Key string `json:"key,omitempty"`
+
+	// Value is the encoded value of a key record.
+	Value *string `json:"value,omitempty"`
+
+	// Sequence is the sequence of a bucket record.
+	Sequence uint64 `json:"sequence,omitempty"`
+
+	// Comparator is the name of the comparator of a bucket record, if any.
+	// It must be registered when importing the stream.
+	Comparator string `json:"comparator,omitempty"`
+
+	// DupSort is set on the record of a bucket created with DupSort. Each of
+	// the values of its keys has its own key record.
+	DupSort bool `json:"dup_sort,omitempty"`
+}
+
+func (e ExportEncoding) encode(b []byte) string {
+	if e == ExportHex {
+		return hex.EncodeToString(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (e ExportEncoding) decode(s string) ([]byte, error) {
+	switch e {
+	case ExportHex:
+		return hex.DecodeString(s)
+	case ExportBase64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return nil, fmt.Errorf("unsupported export encoding: %q", e)
+}
+
+// Export writes every bucket and key of src to w as a stream of
+// newline-delimited JSON ExportRecords. Bucket names, keys and values are
+// encoded with enc. The stream does not depend on the file format, so it can
+// be diffed, reviewed and imported with Import into any version.
+func Export(w io.Writer, src *DB, enc ExportEncoding) error {
+	if enc != ExportBase64 && enc != ExportHex {
+		return fmt.Errorf("unsupported export encoding: %q", enc)
+	}
+
+	bw := bufio.NewWriter(w)
+	je := json.NewEncoder(bw)
+	if err := je.Encode(&ExportRecord{Type: ExportHeaderRecord, Version: ExportVersion, Encoding: enc}); err != nil {
+		return err
+	}
+
+	if err := walk(src, func(keys [][]byte, k, v []byte, blob io.ReadSeeker, seq uint64, opts BucketOptions) error {
+		// Values set by PutReader are exported like the others.
+		if blob != nil {
+			var err error
+			if v, err = io.ReadAll(blob); err != nil {
+				return err
+			}
+		}
+
+		r := ExportRecord{Key: enc.encode(k)}
+		for _, name := range keys {
+			r.Bucket = append(r.Bucket, enc.encode(name))
+		}
+		if v == nil {
+			r.Type, r.Sequence, r.Comparator, r.DupSort = ExportBucketRecord, seq, opts.Comparator, opts.DupSort
+		} else {
+			value := enc.encode(v)
+			r.Type, r.Value = ExportKeyRecord, &value
+		}
+		return je.Encode(&r)
+	}); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import reads a stream written by Export from r and writes its buckets and
+// keys into dst. Importing into an empty database recreates the exported one;
+// otherwise the content is merged, existing keys being overwritten. txMaxSize
+// limits the size of the transactions, like in Compact. A value of zero will
+// ignore transaction sizes.
+func Import(dst *DB, r io.Reader, txMaxSize int64) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var hdr ExportRecord
+	if err := dec.Decode(&hdr); err != nil {
+		return fmt.Errorf("read export header: %s", err)
+	} else if hdr.Type != ExportHeaderRecord {
+		return fmt.Errorf("missing export header, got %q record", hdr.Type)
+	} else if hdr.Version != ExportVersion {
+		return fmt.Errorf("unsupported export version: %d", hdr.Version)
+	}
+	enc := hdr.Encoding
+
+	w := &bucketWriter{db: dst, txMaxSize: txMaxSize}
+	defer w.rollback()
+
+	for line := 2; ; line++ {
+		var rec ExportRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("record %d: %s", line, err)
+		}
+
+		path := make([][]byte, 0, len(rec.Bucket)+1)
+		for _, s := range rec.Bucket {
+			name, err := enc.decode(s)
+			if err != nil {
+				return fmt.Errorf("record %d: bucket: %s", line, err)
+			}
+			path = append(path, name)
+		}
+		key, err := enc.decode(rec.Key)
+		if err != nil {
+			return fmt.Errorf("record %d: key: %s", line, err)
+		}
+
+		switch rec.Type {
+		case ExportBucketRecord:
+			err = w.createBucket(append(path, key), rec.Sequence, BucketOptions{Comparator: rec.Comparator, DupSort: rec.DupSort})
+		case ExportKeyRecord:
+			if len(path) == 0 {
+				return fmt.Errorf("record %d: key outside of a bucket", line)
+			} else if rec.Value == nil {
+				return fmt.Errorf("record %d: missing value", line)
+			}
+			var value []byte
+			if value, err = enc.decode(*rec.Value); err != nil {
+				return fmt.Errorf("record %d: value: %s", line, err)
+			}
+			err = w.put(path, key, value)
+		default:
+			return fmt.Errorf("record %d: unknown record type %q", line, rec.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %s", line, err)
+		}
+	}
+
+	return w.commit()
+}
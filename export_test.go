@@ -0,0 +1,113 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Ensure that an exported database can be imported into an identical one.
+func TestExport_Import(t *testing.T) {
+	for _, enc := range []bolt.ExportEncoding{bolt.ExportBase64, bolt.ExportHex} {
+		t.Run(string(enc), func(t *testing.T) {
+			src := MustOpenDB()
+			defer src.MustClose()
+			if err := src.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				if err := b.SetSequence(7); err != nil {
+					return err
+				}
+				for i := 0; i < 100; i++ {
+					if err := b.Put(u64tob(uint64(i)), []byte("value")); err != nil {
+						return err
+					}
+				}
+				if err := b.Put([]byte("empty"), []byte{}); err != nil {
+					return err
+				}
+				child, err := b.CreateBucket([]byte{0, 0xff})
+				if err != nil {
+					return err
+				}
+				if err := child.Put([]byte("foo"), []byte("bar")); err != nil {
+					return err
+				}
+				blob := strings.Repeat("*", 10000)
+				if err := child.PutReader([]byte("blob"), strings.NewReader(blob), int64(len(blob))); err != nil {
+					return err
+				}
+				rev, err := tx.CreateBucketWithOptions([]byte("reverse"), &bolt.BucketOptions{Comparator: "test-reverse"})
+				if err != nil {
+					return err
+				}
+				for _, k := range []string{"a", "b"} {
+					if err := rev.Put([]byte(k), []byte(k)); err != nil {
+						return err
+					}
+				}
+				dups, err := tx.CreateBucketWithOptions([]byte("dups"), &bolt.BucketOptions{DupSort: true})
+				if err != nil {
+					return err
+				}
+				for _, v := range []string{"2", "1"} {
+					if err := dups.PutDup([]byte("k"), []byte(v)); err != nil {
+						return err
+					}
+				}
+				_, err = tx.CreateBucket([]byte("empty"))
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := bolt.Export(&buf, src.DB, enc); err != nil {
+				t.Fatal(err)
+			}
+			exported := buf.String()
+			if n := strings.Count(exported, "\n"); n != 1+2+101+1+2+3+3 {
+				t.Fatalf("unexpected record count: %d", n)
+			}
+
+			dst := MustOpenDB()
+			defer dst.MustClose()
+			if err := bolt.Import(dst.DB, strings.NewReader(exported), 512); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(exported, `"comparator":"test-reverse"`) {
+				t.Fatal("expected comparator in export")
+			}
+
+			// Exporting the copy must give the same stream.
+			buf.Reset()
+			if err := bolt.Export(&buf, dst.DB, enc); err != nil {
+				t.Fatal(err)
+			} else if buf.String() != exported {
+				t.Fatalf("unexpected export:\n%s\nexpected:\n%s", buf.String(), exported)
+			}
+		})
+	}
+}
+
+// Ensure that importing rejects invalid streams.
+func TestImport_Invalid(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	for _, input := range []string{
+		``,
+		`{"type":"key","key":"AA==","value":"AA=="}`,
+		`{"type":"header","version":99,"encoding":"base64"}`,
+		"{\"type\":\"header\",\"version\":1,\"encoding\":\"base64\"}\n{\"type\":\"key\",\"key\":\"AA==\",\"value\":\"AA==\"}",
+		"{\"type\":\"header\",\"version\":1,\"encoding\":\"hex\"}\n{\"type\":\"bucket\",\"key\":\"zz\"}",
+	} {
+		if err := bolt.Import(db.DB, strings.NewReader(input), 0); err == nil {
+			t.Fatalf("expected error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package bbolt
+
+// fallocate grows the data file to size bytes. Blocks are not reserved
+// since fallocate() is only available on Linux.
+func fallocate(db *DB, size int64) error {
+	return db.file.Truncate(size)
+}
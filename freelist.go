@@ -93,6 +93,18 @@ func (f *freelist) pending_count() int {
 	return count
 }
 
+// isPending returns whether a given page is pending to be freed.
+func (f *freelist) isPending(id pgid) bool {
+	for _, txp := range f.pending {
+		for _, pid := range txp.ids {
+			if pid == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // copyall copies a list of all free ids and all pending ids in one sorted list.
 // f.count returns the minimum length required for dst.
 func (f *freelist) copyall(dst []pgid) {
@@ -0,0 +1,93 @@
+package bbolt
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Logger is the interface used by the database to report internal events
+// such as remapping, file growth, freelist reloads and commit failures.
+// Set Options.Logger to receive them; by default they are discarded.
+type Logger interface {
+	Debug(v ...interface{})
+	Debugf(format string, v ...interface{})
+
+	Info(v ...interface{})
+	Infof(format string, v ...interface{})
+
+	Warning(v ...interface{})
+	Warningf(format string, v ...interface{})
+
+	Error(v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// discardLogger is used when no logger is configured.
+var discardLogger = &DefaultLogger{Logger: log.New(io.Discard, "", 0)}
+
+const calldepth = 2
+
+// DefaultLogger is a Logger implementation backed by the standard library
+// log package. Debug messages are only written after EnableDebug is called.
+type DefaultLogger struct {
+	*log.Logger
+	debug bool
+}
+
+// NewDefaultLogger returns a DefaultLogger writing to stderr, without
+// timestamps until EnableTimestamps is called.
+func NewDefaultLogger() *DefaultLogger {
+	return &DefaultLogger{Logger: log.New(os.Stderr, "bbolt: ", 0)}
+}
+
+// EnableTimestamps adds date and time to every message.
+func (l *DefaultLogger) EnableTimestamps() {
+	l.SetFlags(l.Flags() | log.LstdFlags)
+}
+
+// EnableDebug enables writing of debug messages.
+func (l *DefaultLogger) EnableDebug() {
+	l.debug = true
+}
+
+func (l *DefaultLogger) Debug(v ...interface{}) {
+	if l.debug {
+		_ = l.Output(calldepth, header("DEBUG", fmt.Sprint(v...)))
+	}
+}
+
+func (l *DefaultLogger) Debugf(format string, v ...interface{}) {
+	if l.debug {
+		_ = l.Output(calldepth, header("DEBUG", fmt.Sprintf(format, v...)))
+	}
+}
+
+func (l *DefaultLogger) Info(v ...interface{}) {
+	_ = l.Output(calldepth, header("INFO", fmt.Sprint(v...)))
+}
+
+func (l *DefaultLogger) Infof(format string, v ...interface{}) {
+	_ = l.Output(calldepth, header("INFO", fmt.Sprintf(format, v...)))
+}
+
+func (l *DefaultLogger) Warning(v ...interface{}) {
+	_ = l.Output(calldepth, header("WARN", fmt.Sprint(v...)))
+}
+
+func (l *DefaultLogger) Warningf(format string, v ...interface{}) {
+	_ = l.Output(calldepth, header("WARN", fmt.Sprintf(format, v...)))
+}
+
+func (l *DefaultLogger) Error(v ...interface{}) {
+	_ = l.Output(calldepth, header("ERROR", fmt.Sprint(v...)))
+}
+
+func (l *DefaultLogger) Errorf(format string, v ...interface{}) {
+	_ = l.Output(calldepth, header("ERROR", fmt.Sprintf(format, v...)))
+}
+
+func header(lvl, msg string) string {
+	return fmt.Sprintf("%s: %s", lvl, msg)
+}
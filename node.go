@@ -38,29 +38,88 @@ func (n *node) minKeys() int {
 
 // size returns the size of the node after serialization.
 func (n *node) size() int {
-	sz, elsz := pageHeaderSize, n.pageElementSize()
+	s := n.sizer()
 	for i := 0; i < len(n.inodes); i++ {
-		item := &n.inodes[i]
-		sz += elsz + uintptr(len(item.key)) + uintptr(len(item.value))
+		s.add(&n.inodes[i])
 	}
-	return int(sz)
+	return int(s.size)
 }
 
 // sizeLessThan returns true if the node is less than a given size.
 // This is an optimization to avoid calculating a large node when we only need
 // to know if it fits inside a certain page size.
 func (n *node) sizeLessThan(v uintptr) bool {
-	sz, elsz := pageHeaderSize, n.pageElementSize()
+	s := n.sizer()
 	for i := 0; i < len(n.inodes); i++ {
-		item := &n.inodes[i]
-		sz += elsz + uintptr(len(item.key)) + uintptr(len(item.value))
-		if sz >= v {
+		if s.add(&n.inodes[i]); s.size >= v {
 			return false
 		}
 	}
 	return true
 }
 
+// prefixCompressed returns true if the node is written with prefix
+// compression when its keys share a prefix. Nodes of buckets with a
+// comparator are not, as their first and last keys sharing a prefix doesn't
+// mean the keys in between do.
+func (n *node) prefixCompressed() bool {
+	return n.bucket != nil && n.bucket.comparator == "" && n.bucket.tx != nil && n.bucket.tx.db != nil && n.bucket.tx.db.PrefixCompression
+}
+
+// sizer returns a nodeSizer for the inodes of the node.
+func (n *node) sizer() nodeSizer {
+	return nodeSizer{elsz: n.pageElementSize(), compress: n.prefixCompressed(), size: pageHeaderSize}
+}
+
+// nodeSizer computes the serialized size of a run of sorted inodes as they
+// are added. The size grows with each inode, even when prefix compression
+// is enabled.
+type nodeSizer struct {
+	elsz     uintptr
+	compress bool
+
+	first []byte  // key of the first inode
+	count int     // number of inodes
+	raw   uintptr // size without prefix compression
+	plen  int     // length of the prefix shared by the keys
+	size  uintptr
+}
+
+// sizeWith returns the size of the run with item added.
+func (s *nodeSizer) sizeWith(item *inode) uintptr {
+	raw := s.raw + s.elsz + uintptr(len(item.key)) + uintptr(len(item.value))
+	if !s.compress || s.count == 0 {
+		return pageHeaderSize + raw
+	}
+	plen := commonPrefixLen(s.first, item.key)
+	if plen > s.plen {
+		plen = s.plen
+	}
+	return pageHeaderSize + raw - uintptr(prefixSaving(s.count+1, plen))
+}
+
+// add adds item to the run and returns its new size.
+func (s *nodeSizer) add(item *inode) uintptr {
+	s.size = s.sizeWith(item)
+	s.raw += s.elsz + uintptr(len(item.key)) + uintptr(len(item.value))
+	if s.count == 0 {
+		s.first, s.plen = item.key, len(item.key)
+	} else if plen := commonPrefixLen(s.first, item.key); plen < s.plen {
+		s.plen = plen
+	}
+	s.count++
+	return s.size
+}
+
+// prefixSaving returns the number of bytes saved by storing a prefix of plen
+// bytes once for count keys, or 0 if it does not save space.
+func prefixSaving(count, plen int) int {
+	if saving := (count-1)*plen - prefixHeaderSize; saving > 0 {
+		return saving
+	}
+	return 0
+}
+
 // pageElementSize returns the size of each page element based on the type of node.
 func (n *node) pageElementSize() uintptr {
 	if n.isLeaf {
@@ -79,7 +138,7 @@ func (n *node) childAt(index int) *node {
 
 // childIndex returns the index of a given child node.
 func (n *node) childIndex(child *node) int {
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].key, child.key) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compareKeys(n.inodes[i].key, child.key) != -1 })
 	return index
 }
 
@@ -123,7 +182,7 @@ func (n *node) put(oldKey, newKey, value []byte, pgid pgid, flags uint32) {
 	}
 
 	// Find insertion index.
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].key, oldKey) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compareKeys(n.inodes[i].key, oldKey) != -1 })
 
 	// Add capacity and shift nodes if we don't have an exact match and need to insert.
 	exact := (len(n.inodes) > 0 && index < len(n.inodes) && bytes.Equal(n.inodes[index].key, oldKey))
@@ -143,7 +202,7 @@ func (n *node) put(oldKey, newKey, value []byte, pgid pgid, flags uint32) {
 // del removes a key from the node.
 func (n *node) del(key []byte) {
 	// Find index of key.
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].key, key) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compareKeys(n.inodes[i].key, key) != -1 })
 
 	// Exit if the key isn't found.
 	if index >= len(n.inodes) || !bytes.Equal(n.inodes[index].key, key) {
@@ -168,12 +227,12 @@ func (n *node) read(p *page) {
 		if n.isLeaf {
 			elem := p.leafPageElement(uint16(i))
 			inode.flags = elem.flags
-			inode.key = elem.key()
+			inode.key = p.leafKey(uint16(i))
 			inode.value = elem.value()
 		} else {
 			elem := p.branchPageElement(uint16(i))
 			inode.pgid = elem.pgid
-			inode.key = elem.key()
+			inode.key = p.branchKey(uint16(i))
 		}
 		_assert(len(inode.key) > 0, "read: zero-length inode key")
 	}
@@ -209,32 +268,51 @@ func (n *node) write(p *page) {
 	// Loop over each item and write it to the page.
 	// off tracks the offset into p of the start of the next data.
 	off := unsafe.Sizeof(*p) + n.pageElementSize()*uintptr(len(n.inodes))
+
+	// Store the prefix shared by the keys once, before the data, if it
+	// saves space. The elements then only hold the key suffixes.
+	var plen int
+	if n.prefixCompressed() {
+		first, last := n.inodes[0].key, n.inodes[len(n.inodes)-1].key
+		if plen = commonPrefixLen(first, last); prefixSaving(len(n.inodes), plen) > 0 {
+			p.flags |= prefixPageFlag
+			*(*uint16)(unsafeAdd(unsafe.Pointer(p), off)) = uint16(plen)
+			copy(unsafeByteSlice(unsafe.Pointer(p), off+prefixHeaderSize, 0, plen), first)
+			off += prefixHeaderSize + uintptr(plen)
+		} else {
+			plen = 0
+		}
+	}
+
 	for i, item := range n.inodes {
 		_assert(len(item.key) > 0, "write: zero-length inode key")
+		key := item.key[plen:]
 
 		// Create a slice to write into of needed size and advance
 		// byte pointer for next iteration.
-		sz := len(item.key) + len(item.value)
+		// The suffix and value may both be empty on compressed pages.
+		sz := len(key) + len(item.value)
 		b := unsafeByteSlice(unsafe.Pointer(p), off, 0, sz)
+		data := uintptr(unsafeAdd(unsafe.Pointer(p), off))
 		off += uintptr(sz)
 
 		// Write the page element.
 		if n.isLeaf {
 			elem := p.leafPageElement(uint16(i))
-			elem.pos = uint32(uintptr(unsafe.Pointer(&b[0])) - uintptr(unsafe.Pointer(elem)))
+			elem.pos = uint32(data - uintptr(unsafe.Pointer(elem)))
 			elem.flags = item.flags
-			elem.ksize = uint32(len(item.key))
+			elem.ksize = uint32(len(key))
 			elem.vsize = uint32(len(item.value))
 		} else {
 			elem := p.branchPageElement(uint16(i))
-			elem.pos = uint32(uintptr(unsafe.Pointer(&b[0])) - uintptr(unsafe.Pointer(elem)))
-			elem.ksize = uint32(len(item.key))
+			elem.pos = uint32(data - uintptr(unsafe.Pointer(elem)))
+			elem.ksize = uint32(len(key))
 			elem.pgid = item.pgid
 			_assert(elem.pgid != p.id, "write: circular dependency occurred")
 		}
 
 		// Write data for the element to the end of the page.
-		l := copy(b, item.key)
+		l := copy(b, key)
 		copy(b[l:], item.value)
 	}
 
@@ -310,21 +388,20 @@ func (n *node) splitTwo(pageSize uintptr) (*node, *node) {
 // This is only be called from split().
 func (n *node) splitIndex(threshold int) (index, sz uintptr) {
 	sz = pageHeaderSize
+	s := n.sizer()
 
 	// Loop until we only have the minimum number of keys required for the second page.
 	for i := 0; i < len(n.inodes)-minKeysPerPage; i++ {
 		index = uintptr(i)
-		inode := n.inodes[i]
-		elsize := n.pageElementSize() + uintptr(len(inode.key)) + uintptr(len(inode.value))
 
 		// If we have at least the minimum number of keys and adding another
 		// node would put us over the threshold then exit and return.
-		if index >= minKeysPerPage && sz+elsize > uintptr(threshold) {
+		if index >= minKeysPerPage && s.sizeWith(&n.inodes[i]) > uintptr(threshold) {
 			break
 		}
 
 		// Add the element size to the total size.
-		sz += elsize
+		sz = s.add(&n.inodes[i])
 	}
 
 	return
@@ -586,7 +663,7 @@ type nodes []*node
 func (s nodes) Len() int      { return len(s) }
 func (s nodes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s nodes) Less(i, j int) bool {
-	return bytes.Compare(s[i].inodes[0].key, s[j].inodes[0].key) == -1
+	return s[i].bucket.compareKeys(s[i].inodes[0].key, s[j].inodes[0].key) == -1
 }
 
 // inode represents an internal node inside of a node.
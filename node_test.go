@@ -99,6 +99,60 @@ func TestNode_write_LeafPage(t *testing.T) {
 	}
 }
 
+// Ensure that a node can serialize into a prefix compressed page.
+func TestNode_write_PrefixCompression(t *testing.T) {
+	n := &node{isLeaf: true, inodes: make(inodes, 0), bucket: &Bucket{tx: &Tx{db: &DB{PrefixCompression: true}, meta: &meta{pgid: 1}}}}
+	n.put([]byte("tenant/b"), []byte("tenant/b"), []byte("y"), 0, 0)
+	n.put([]byte("tenant/"), []byte("tenant/"), []byte{}, 0, 0)
+	n.put([]byte("tenant/a"), []byte("tenant/a"), []byte("x"), 0, 0)
+
+	var buf [4096]byte
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	n.write(p)
+	if (p.flags & prefixPageFlag) == 0 {
+		t.Fatal("expected prefix compressed page")
+	} else if prefix := p.prefix(); string(prefix) != "tenant/" {
+		t.Fatalf("exp=tenant/; got=%s", prefix)
+	}
+
+	// The size must match the bytes written.
+	last := p.leafPageElement(p.count - 1)
+	used := int(uintptr(unsafe.Pointer(last))-uintptr(unsafe.Pointer(p))) + int(last.pos+last.ksize+last.vsize)
+	if sz := n.size(); sz != used {
+		t.Fatalf("exp=%d; got=%d", used, sz)
+	}
+
+	n2 := &node{}
+	n2.read(p)
+	for i, exp := range []string{"tenant/", "tenant/a", "tenant/b"} {
+		if k := n2.inodes[i].key; string(k) != exp {
+			t.Fatalf("exp=%s; got=%s", exp, k)
+		}
+	}
+	if v := n2.inodes[1].value; string(v) != "x" {
+		t.Fatalf("exp=x; got=%s", v)
+	}
+
+	// Keys not sharing the prefix are searched before or after the elements.
+	for _, tt := range []struct {
+		key   string
+		index int
+		exact bool
+	}{
+		{"a", 0, false},
+		{"tenant", 0, false},
+		{"tenant/", 0, true},
+		{"tenant/a", 1, true},
+		{"tenant/ab", 2, false},
+		{"tenant/c", 3, false},
+		{"z", 3, false},
+	} {
+		if index, exact := p.searchKey([]byte(tt.key), nil); index != tt.index || exact != tt.exact {
+			t.Fatalf("%s: exp=%d,%v; got=%d,%v", tt.key, tt.index, tt.exact, index, exact)
+		}
+	}
+}
+
 // Ensure that a node can split into appropriate subgroups.
 func TestNode_split(t *testing.T) {
 	// Create a node.
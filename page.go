@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"sort"
@@ -14,17 +15,37 @@ const minKeysPerPage = 2
 const branchPageElementSize = unsafe.Sizeof(branchPageElement{})
 const leafPageElementSize = unsafe.Sizeof(leafPageElement{})
 
+// prefixHeaderSize is the size of the length of the key prefix stored after
+// the elements of a page flagged with prefixPageFlag.
+const prefixHeaderSize = 2
+
 const (
 	branchPageFlag   = 0x01
 	leafPageFlag     = 0x02
 	metaPageFlag     = 0x04
 	freelistPageFlag = 0x10
+	blobPageFlag     = 0x40
+	prefixPageFlag   = 0x80
 )
 
 const (
-	bucketLeafFlag = 0x01
+	bucketLeafFlag    = 0x01
+	blobLeafFlag      = 0x02
+	bucketExtLeafFlag = 0x04
+	dupLeafFlag       = 0x08
 )
 
+// validLeafFlags returns true if flags is a valid combination of leaf
+// element flags.
+func validLeafFlags(flags uint32) bool {
+	switch flags {
+	case 0, bucketLeafFlag, bucketLeafFlag | bucketExtLeafFlag, blobLeafFlag,
+		dupLeafFlag, bucketLeafFlag | dupLeafFlag:
+		return true
+	}
+	return false
+}
+
 type pgid uint64
 
 type page struct {
@@ -44,6 +65,8 @@ func (p *page) typ() string {
 		return "meta"
 	} else if (p.flags & freelistPageFlag) != 0 {
 		return "freelist"
+	} else if (p.flags & blobPageFlag) != 0 {
+		return "blob"
 	}
 	return fmt.Sprintf("unknown<%02x>", p.flags)
 }
@@ -87,6 +110,100 @@ func (p *page) branchPageElements() []branchPageElement {
 	return elems
 }
 
+// prefix returns the prefix shared by the keys of a leaf or branch page
+// written with prefix compression, or nil. It is stored once after the
+// elements, and the elements only hold the key suffixes.
+func (p *page) prefix() []byte {
+	if (p.flags & prefixPageFlag) == 0 {
+		return nil
+	}
+	off := unsafe.Sizeof(*p) + leafPageElementSize*uintptr(p.count)
+	if (p.flags & branchPageFlag) != 0 {
+		off = unsafe.Sizeof(*p) + branchPageElementSize*uintptr(p.count)
+	}
+	n := *(*uint16)(unsafeAdd(unsafe.Pointer(p), off))
+	return unsafeByteSlice(unsafe.Pointer(p), off+prefixHeaderSize, 0, int(n))
+}
+
+// leafKey returns the key of the leaf element at index. On pages written with
+// prefix compression, the key is copied with its prefix.
+func (p *page) leafKey(index uint16) []byte {
+	return p.expandKey(p.leafPageElement(index).key())
+}
+
+// branchKey returns the key of the branch element at index. On pages written
+// with prefix compression, the key is copied with its prefix.
+func (p *page) branchKey(index uint16) []byte {
+	return p.expandKey(p.branchPageElement(index).key())
+}
+
+// expandKey returns the key stored as suffix on the page.
+func (p *page) expandKey(suffix []byte) []byte {
+	prefix := p.prefix()
+	if len(prefix) == 0 {
+		return suffix
+	}
+	key := make([]byte, len(prefix)+len(suffix))
+	copy(key[copy(key, prefix):], suffix)
+	return key
+}
+
+// searchKey returns the index of the first element of a leaf or branch page
+// whose key is not less than key, and whether that key is equal to key. Keys
+// are ordered by compare, or by bytes.Compare if it is nil.
+func (p *page) searchKey(key []byte, compare Comparator) (index int, exact bool) {
+	if compare == nil {
+		compare = bytes.Compare
+	}
+
+	// Keys not starting with the page prefix sort before or after every
+	// element. The others are searched by suffix. Only pages of buckets
+	// ordered by bytes.Compare are written with a prefix.
+	if prefix := p.prefix(); len(prefix) > 0 {
+		n := len(prefix)
+		if len(key) < n {
+			n = len(key)
+		}
+		if ret := bytes.Compare(prefix[:n], key[:n]); ret < 0 {
+			return int(p.count), false
+		} else if ret > 0 || len(key) < len(prefix) {
+			return 0, false
+		}
+		key = key[len(prefix):]
+	}
+
+	var suffix func(i int) []byte
+	if (p.flags & leafPageFlag) != 0 {
+		elems := p.leafPageElements()
+		suffix = func(i int) []byte { return elems[i].key() }
+	} else {
+		elems := p.branchPageElements()
+		suffix = func(i int) []byte { return elems[i].key() }
+	}
+	index = sort.Search(int(p.count), func(i int) bool {
+		ret := compare(suffix(i), key)
+		if ret == 0 {
+			exact = true
+		}
+		return ret != -1
+	})
+	return index, exact
+}
+
+// commonPrefixLen returns the length of the longest common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
 // dump writes n bytes of the page to STDERR as hex output.
 func (p *page) hexdump(n int) {
 	buf := unsafeByteSlice(unsafe.Pointer(p), 0, 0, n)
@@ -139,6 +256,10 @@ type PageInfo struct {
 	Type          string
 	Count         int
 	OverflowCount int
+
+	// Pending is true for a free page which is still readable by an open
+	// transaction, and so cannot be reused yet.
+	Pending bool
 }
 
 type pgids []pgid
@@ -0,0 +1,167 @@
+package bbolt
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// AccessMode is the way pages are read from the data file.
+type AccessMode string
+
+const (
+	// Mmap reads pages from a read-only memory map of the data file.
+	Mmap = AccessMode("mmap")
+	// PRead reads pages with ReadAt into a bounded page cache, so the
+	// database is not limited by the address space.
+	PRead = AccessMode("pread")
+)
+
+// DefaultPageCacheSize is the default maximum number of pages held by the
+// page cache in PRead access mode.
+const DefaultPageCacheSize = 4096
+
+// pageCache holds the pages read in PRead access mode, along with their
+// overflow pages. Once it holds more than size pages, it evicts the least
+// recently used ones. Evicted pages stay valid for the transactions still
+// pointing into them and are garbage collected afterwards.
+type pageCache struct {
+	mu    sync.Mutex
+	size  int
+	n     int
+	lru   *list.List // of *cachedPage, most recently used first
+	items map[pgid]*list.Element
+
+	hit  int
+	miss int
+}
+
+// cachedPage is a page read from the data file, with its overflow pages.
+type cachedPage struct {
+	id  pgid
+	buf []byte
+	n   int // number of pages in buf
+}
+
+// newPageCache returns a page cache holding up to size pages.
+func newPageCache(size int) *pageCache {
+	return &pageCache{
+		size:  size,
+		lru:   list.New(),
+		items: make(map[pgid]*list.Element),
+	}
+}
+
+// get returns the cached page id, or nil if it is not in the cache.
+func (c *pageCache) get(id pgid) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[id]
+	if !ok {
+		c.miss++
+		return nil
+	}
+	c.hit++
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedPage).buf
+}
+
+// put adds page id, read into buf along with its overflow pages, to the cache.
+// Pages larger than the cache are not cached.
+func (c *pageCache) put(id pgid, buf []byte, n int) {
+	if n > c.size {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(id)
+	c.items[id] = c.lru.PushFront(&cachedPage{id: id, buf: buf, n: n})
+	c.n += n
+
+	// Evict the least recently used pages.
+	for c.n > c.size {
+		c.remove(c.lru.Back().Value.(*cachedPage).id)
+	}
+}
+
+// invalidate removes the pages from id to id+n-1 from the cache. It is called
+// once they are rewritten.
+func (c *pageCache) invalidate(id pgid, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < n && len(c.items) > 0; i++ {
+		c.remove(id + pgid(i))
+	}
+}
+
+// remove removes page id from the cache, if present. The cache must be locked.
+func (c *pageCache) remove(id pgid) {
+	if e, ok := c.items[id]; ok {
+		c.n -= e.Value.(*cachedPage).n
+		c.lru.Remove(e)
+		delete(c.items, id)
+	}
+}
+
+// stats returns the number of cache hits and misses.
+func (c *pageCache) stats() (hit, miss int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hit, c.miss
+}
+
+// preadPage returns page id read from the page cache, or from the data file
+// on a cache miss. It panics with a PageError if the page can't be read.
+func (db *DB) preadPage(id pgid) *page {
+	if buf := db.pageCache.get(id); buf != nil {
+		return (*page)(unsafe.Pointer(&buf[0]))
+	}
+
+	// Read the page header to find the number of overflow pages, then the
+	// whole run. The page must be in the file, but overflow pages past its
+	// end are left zeroed. A run larger than any value can only be a
+	// corrupted page, which is read alone.
+	off := int64(id) * int64(db.pageSize)
+	buf := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(buf, off); err == io.EOF {
+		panic(&PageError{PageID: int(id), Err: io.ErrUnexpectedEOF})
+	} else if err != nil {
+		panic(&PageError{PageID: int(id), Err: fmt.Errorf("pread error: %s", err)})
+	}
+	n := int((*page)(unsafe.Pointer(&buf[0])).overflow) + 1
+	if n > 1 && n <= maxAllocSize/db.pageSize {
+		buf = make([]byte, n*db.pageSize)
+		if _, err := db.file.ReadAt(buf, off); err != nil && err != io.EOF {
+			panic(&PageError{PageID: int(id), Err: fmt.Errorf("pread error: %s", err)})
+		}
+	} else {
+		n = 1
+	}
+
+	db.pageCache.put(id, buf, n)
+	return (*page)(unsafe.Pointer(&buf[0]))
+}
+
+// readMetaPages reads both meta pages into memory in PRead access mode.
+func (db *DB) readMetaPages() error {
+	buf := make([]byte, 2*db.pageSize)
+	if _, err := db.file.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("pread meta pages: %s", err)
+	}
+	db.metaBuf = buf
+	db.meta0 = db.pageInBuffer(buf, 0).meta()
+	db.meta1 = db.pageInBuffer(buf, 1).meta()
+	return nil
+}
+
+// updateMetaPage copies the meta page written to the data file into memory
+// in PRead access mode.
+func (db *DB) updateMetaPage(buf []byte) {
+	id := db.pageInBuffer(buf, 0).id
+	db.metalock.Lock()
+	copy(db.metaBuf[int(id)*db.pageSize:], buf)
+	db.metalock.Unlock()
+}
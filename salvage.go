@@ -0,0 +1,559 @@
+package bbolt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"unsafe"
+)
+
+// SalvageOrphansBucket is the name of the top-level bucket into which Salvage
+// writes the content of pages that are not reachable from any bucket. Each
+// unreachable subtree is written into a child bucket named after the id of
+// its topmost page, e.g. "page-42".
+const SalvageOrphansBucket = "salvaged-orphans"
+
+// SalvageReport describes the outcome of a Salvage call.
+type SalvageReport struct {
+	// PageCount is the number of pages in the source file.
+	PageCount int
+
+	// BucketCount is the number of buckets written to the destination,
+	// including the orphan buckets.
+	BucketCount int
+
+	// KeyCount is the number of key/value pairs written to the destination.
+	KeyCount int
+
+	// OrphanPageCount is the number of leaf and branch pages which were not
+	// reachable from any bucket and were recovered into SalvageOrphansBucket.
+	OrphanPageCount int
+
+	// OrphansSkipped is true if the unreachable pages were not recovered
+	// because the freelist was not synced or could not be read. Without it,
+	// the pages freed by past transactions, which hold deleted keys and
+	// older values, can't be told apart from orphans.
+	OrphansSkipped bool
+
+	// Errors lists the pages which could not be read, or whose elements
+	// could not be written to the destination.
+	Errors []*CheckError
+}
+
+// Salvage scans every page of the possibly corrupted database file at
+// srcPath, parses whatever leaf and branch pages are valid and writes all
+// key/value pairs it finds into dst. The bucket hierarchy is rebuilt from the
+// root of the newest valid meta page and from the nested bucket headers found
+// on leaf pages. Pages which are not reachable that way, and which are not
+// listed in the freelist, are recovered under SalvageOrphansBucket. They are
+// skipped if the freelist is missing, unless no meta page is valid.
+//
+// The source file is only read, and is not opened as a database, so Salvage
+// can be used on files which fail to open. txMaxSize limits the size of the
+// transactions written to dst, like in Compact. A value of zero will ignore
+// transaction sizes.
+func Salvage(dst *DB, srcPath string, txMaxSize int64) (*SalvageReport, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &salvager{
+		f:       f,
+		pages:   make(map[pgid]*salvagePage),
+		visited: make(map[pgid]bool),
+		report:  &SalvageReport{},
+	}
+	m := s.readMeta(fi.Size())
+	s.report.PageCount = int(s.pageN)
+
+	w := &bucketWriter{db: dst, txMaxSize: txMaxSize}
+	defer w.rollback()
+
+	// Walk the tree of the newest valid meta page.
+	if m != nil {
+		if err := s.walkPage(w, m.root.root, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// Recover the pages which are not reachable from the tree, unless the
+	// freelist is missing and stale pages would be recovered along.
+	if m != nil && s.freed == nil {
+		s.report.OrphansSkipped = true
+	} else if err := s.walkOrphans(w, m); err != nil {
+		return nil, err
+	}
+
+	if err := w.commit(); err != nil {
+		return nil, err
+	}
+	s.report.BucketCount, s.report.KeyCount = w.bucketN, w.keyN
+	return s.report, nil
+}
+
+// salvagePage is a copy of the content of a valid leaf or branch page.
+type salvagePage struct {
+	id       pgid
+	overflow uint32
+	isLeaf   bool
+	leafs    []salvageLeafElement
+	branches []salvageBranchElement
+}
+
+type salvageLeafElement struct {
+	flags uint32
+	key   []byte
+	value []byte
+}
+
+type salvageBranchElement struct {
+	key  []byte
+	pgid pgid
+}
+
+// salvager reads pages of a damaged database file.
+type salvager struct {
+	f        *os.File
+	pageSize int
+	pageN    pgid
+
+	// pages caches the result of parsing each page; a nil value marks a
+	// page which is not a valid leaf or branch page.
+	pages    map[pgid]*salvagePage
+	pageErrs map[pgid]error
+
+	// visited holds the pages reached by a walk, and walked counts the
+	// valid pages among them.
+	visited map[pgid]bool
+	walked  int
+
+	freed  map[pgid]bool
+	report *SalvageReport
+}
+
+func (s *salvager) errorf(id pgid, path [][]byte, format string, v ...interface{}) {
+	s.report.Errors = append(s.report.Errors, &CheckError{PageID: int(id), Bucket: path, Reason: fmt.Sprintf(format, v...)})
+}
+
+// readMeta determines the page size and the number of pages, and returns the
+// newest valid meta page, or nil if none is valid.
+func (s *salvager) readMeta(size int64) *meta {
+	var metas []*meta
+	readAt := func(off int64) *meta {
+		buf := make([]byte, pageHeaderSize+unsafe.Sizeof(meta{}))
+		if _, err := s.f.ReadAt(buf, off); err != nil {
+			return nil
+		}
+		m := (*page)(unsafe.Pointer(&buf[0])).meta()
+		if m.validate() != nil {
+			return nil
+		}
+		return m
+	}
+
+	// Meta page 0 holds the page size. If it is damaged, look for meta page
+	// 1 at the offsets of the usual page sizes.
+	if m := readAt(0); m != nil {
+		s.pageSize = int(m.pageSize)
+		metas = append(metas, m)
+	}
+	sizes := []int{s.pageSize}
+	if s.pageSize == 0 {
+		sizes = []int{os.Getpagesize(), 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	}
+	for _, sz := range sizes {
+		if int64(sz)*2 > size {
+			continue
+		}
+		if m := readAt(int64(sz)); m != nil && int(m.pageSize) == sz {
+			s.pageSize = sz
+			metas = append(metas, m)
+			break
+		}
+	}
+	if len(metas) == 0 {
+		// Without a valid meta page, assume the OS page size and let the
+		// whole file be recovered as orphans.
+		s.pageSize = os.Getpagesize()
+	}
+	s.pageN = pgid(size / int64(s.pageSize))
+	if len(metas) == 0 {
+		return nil
+	}
+
+	// Prefer the newest meta page whose root is readable.
+	sort.Slice(metas, func(i, j int) bool { return metas[i].txid > metas[j].txid })
+	m := metas[0]
+	for _, mm := range metas {
+		if _, err := s.page(mm.root.root); err == nil {
+			m = mm
+			break
+		}
+	}
+	s.readFreelist(m)
+	return m
+}
+
+// readFreelist loads the ids of the free pages listed in the freelist of m,
+// if it is readable.
+func (s *salvager) readFreelist(m *meta) {
+	if m.freelist == pgidNoFreelist {
+		return
+	}
+	buf, err := s.read(m.freelist)
+	if err != nil {
+		return
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if p.id != m.freelist || (p.flags&freelistPageFlag) == 0 {
+		return
+	}
+
+	idx, count := 0, int(p.count)
+	if count == 0xFFFF {
+		idx = 1
+		count = int(*(*pgid)(unsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p))))
+	}
+	if count < 0 || int(pageHeaderSize)+(idx+count)*int(unsafe.Sizeof(pgid(0))) > len(buf) {
+		return
+	}
+	var ids []pgid
+	data := unsafeIndex(unsafe.Pointer(p), unsafe.Sizeof(*p), unsafe.Sizeof(ids[0]), idx)
+	unsafeSlice(unsafe.Pointer(&ids), data, count)
+
+	s.freed = make(map[pgid]bool, count)
+	for _, id := range ids {
+		s.freed[id] = true
+	}
+}
+
+// read reads the page with the given id, including its overflow pages.
+func (s *salvager) read(id pgid) ([]byte, error) {
+	if id <= 1 || id >= s.pageN {
+		return nil, fmt.Errorf("page %d is out of the file bounds", id)
+	}
+	buf := make([]byte, s.pageSize)
+	if _, err := s.f.ReadAt(buf, int64(id)*int64(s.pageSize)); err != nil {
+		return nil, err
+	}
+	overflow := (*page)(unsafe.Pointer(&buf[0])).overflow
+	if overflow == 0 {
+		return buf, nil
+	}
+	if id+pgid(overflow) >= s.pageN || id+pgid(overflow) < id {
+		return nil, fmt.Errorf("overflow %d is out of the file bounds", overflow)
+	}
+	buf = make([]byte, (int(overflow)+1)*s.pageSize)
+	if _, err := s.f.ReadAt(buf, int64(id)*int64(s.pageSize)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// page returns the parsed page with the given id, or an error describing
+// why it is not a valid leaf or branch page.
+func (s *salvager) page(id pgid) (*salvagePage, error) {
+	if p, ok := s.pages[id]; ok {
+		if p == nil {
+			return nil, s.pageErrs[id]
+		}
+		return p, nil
+	}
+
+	buf, err := s.read(id)
+	if err == nil {
+		var p *salvagePage
+		if p, err = parseSalvagePage(buf, id, true); err == nil {
+			s.pages[id] = p
+			return p, nil
+		}
+	}
+	if s.pageErrs == nil {
+		s.pageErrs = make(map[pgid]error)
+	}
+	s.pages[id], s.pageErrs[id] = nil, err
+	return nil, err
+}
+
+// parseSalvagePage parses and copies the elements of a leaf or branch page
+// held in buf. If checkID is false, the page id in the header is ignored, as
+// is the case for inline buckets.
+func parseSalvagePage(buf []byte, id pgid, checkID bool) (*salvagePage, error) {
+	if len(buf) < int(pageHeaderSize) {
+		return nil, fmt.Errorf("page too short: %d bytes", len(buf))
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if checkID && p.id != id {
+		return nil, fmt.Errorf("page header has id %d", int(p.id))
+	}
+	isLeaf, isBranch := (p.flags&leafPageFlag) != 0, (p.flags&branchPageFlag) != 0
+	if isLeaf == isBranch {
+		return nil, fmt.Errorf("not a leaf or branch page: %s", p.typ())
+	}
+
+	elsz := int(branchPageElementSize)
+	if isLeaf {
+		elsz = int(leafPageElementSize)
+	}
+	if int(pageHeaderSize)+int(p.count)*elsz > len(buf) {
+		return nil, fmt.Errorf("%d elements do not fit in %d bytes", p.count, len(buf))
+	}
+	if (p.flags & prefixPageFlag) != 0 {
+		off := int(pageHeaderSize) + int(p.count)*elsz
+		if off+prefixHeaderSize > len(buf) || off+prefixHeaderSize+len(p.prefix()) > len(buf) {
+			return nil, fmt.Errorf("key prefix does not fit in %d bytes", len(buf))
+		}
+	}
+
+	sp := &salvagePage{id: id, overflow: p.overflow, isLeaf: isLeaf}
+	for i := uint16(0); i < p.count; i++ {
+		off := int(pageHeaderSize) + int(i)*elsz
+		if isLeaf {
+			e := p.leafPageElement(i)
+			end := off + int(e.pos) + int(e.ksize) + int(e.vsize)
+			if end > len(buf) || end < off {
+				return nil, fmt.Errorf("element %d ends beyond the page", i)
+			} else if !validLeafFlags(e.flags) {
+				return nil, fmt.Errorf("invalid flags %x on element %d", e.flags, i)
+			}
+			sp.leafs = append(sp.leafs, salvageLeafElement{flags: e.flags, key: cloneBytes(p.leafKey(i)), value: cloneBytes(e.value())})
+		} else {
+			e := p.branchPageElement(i)
+			end := off + int(e.pos) + int(e.ksize)
+			if end > len(buf) || end < off {
+				return nil, fmt.Errorf("element %d ends beyond the page", i)
+			}
+			sp.branches = append(sp.branches, salvageBranchElement{key: cloneBytes(p.branchKey(i)), pgid: e.pgid})
+		}
+	}
+	return sp, nil
+}
+
+// walkPage writes the content of the page with the given id, and of its
+// children, to the bucket at path. If dupKey is not nil, the page belongs to
+// the tree of values of dupKey in that bucket.
+func (s *salvager) walkPage(w *bucketWriter, id pgid, path [][]byte, dupKey []byte) error {
+	if s.visited[id] {
+		s.errorf(id, path, "page already referenced elsewhere")
+		return nil
+	}
+	s.visited[id] = true
+
+	p, err := s.page(id)
+	if err != nil {
+		s.errorf(id, path, "%s", err)
+		return nil
+	}
+	for i := id + 1; i <= id+pgid(p.overflow); i++ {
+		s.visited[i] = true
+	}
+	s.walked++
+
+	// The page is never parsed again, drop it from the cache.
+	delete(s.pages, id)
+
+	if !p.isLeaf {
+		for _, e := range p.branches {
+			if err := s.walkPage(w, e.pgid, path, dupKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if dupKey != nil {
+		s.walkDupLeaf(w, p, path, dupKey)
+		return nil
+	}
+	return s.walkLeaf(w, p, path)
+}
+
+// walkDupLeaf writes the keys of a leaf page of the tree of values of key as
+// values of key in the bucket at path.
+func (s *salvager) walkDupLeaf(w *bucketWriter, p *salvagePage, path [][]byte, key []byte) {
+	for _, e := range p.leafs {
+		if e.flags != 0 {
+			s.errorf(p.id, path, "key %x: invalid flags %x on duplicate value %x", key, e.flags, e.key)
+		} else if err := w.put(path, key, e.key); err != nil {
+			s.errorf(p.id, path, "put key %x: %s", key, err)
+		}
+	}
+}
+
+// walkLeaf writes the elements of a leaf page to the bucket at path.
+func (s *salvager) walkLeaf(w *bucketWriter, p *salvagePage, path [][]byte) error {
+	for _, e := range p.leafs {
+		if (e.flags & blobLeafFlag) != 0 {
+			s.errorf(p.id, path, "key %x: values set by PutReader are not salvaged", e.key)
+			continue
+		} else if (e.flags & dupLeafFlag) != 0 {
+			if err := s.walkDups(w, p, path, e); err != nil {
+				return err
+			}
+			continue
+		} else if (e.flags & bucketLeafFlag) == 0 {
+			if len(path) == 0 {
+				s.errorf(p.id, path, "key %x is not a bucket in the root bucket", e.key)
+				continue
+			}
+			if err := w.put(path, e.key, e.value); err != nil {
+				s.errorf(p.id, path, "put key %x: %s", e.key, err)
+			}
+			continue
+		}
+
+		child := append(path[:len(path):len(path)], e.key)
+		if len(e.value) < bucketHeaderSize {
+			s.errorf(p.id, child, "bucket header too short: %d bytes", len(e.value))
+			continue
+		}
+		var opts BucketOptions
+		var extSize int
+		if (e.flags & bucketExtLeafFlag) != 0 {
+			var ok bool
+			if opts, extSize, ok = readBucketExt(e.value); !ok {
+				s.errorf(p.id, child, "invalid bucket options")
+				continue
+			} else if opts.Comparator != "" && lookupComparator(opts.Comparator) == nil {
+				s.errorf(p.id, child, "unknown comparator %q", opts.Comparator)
+				continue
+			}
+		}
+		hdr := (*bucket)(unsafe.Pointer(&e.value[0]))
+		if err := w.createBucket(child, hdr.sequence, opts); err != nil {
+			s.errorf(p.id, child, "create bucket: %s", err)
+			continue
+		}
+		if hdr.root != 0 {
+			if err := s.walkPage(w, hdr.root, child, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Inline bucket.
+		ip, err := parseSalvagePage(e.value[bucketHeaderSize+extSize:], 0, false)
+		if err != nil {
+			s.errorf(p.id, child, "inline bucket: %s", err)
+			continue
+		} else if !ip.isLeaf {
+			s.errorf(p.id, child, "inline bucket is not a leaf page")
+			continue
+		}
+		ip.id = p.id
+		if err := s.walkLeaf(w, ip, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDups writes the values of a key with duplicates to the bucket at path.
+func (s *salvager) walkDups(w *bucketWriter, p *salvagePage, path [][]byte, e salvageLeafElement) error {
+	if len(path) == 0 {
+		s.errorf(p.id, path, "key %x is not a bucket in the root bucket", e.key)
+		return nil
+	} else if (e.flags & bucketLeafFlag) == 0 {
+		if !validDups(e.value) {
+			s.errorf(p.id, path, "key %x: invalid duplicate values", e.key)
+			return nil
+		}
+		for _, v := range decodeDups(e.value) {
+			if err := w.put(path, e.key, v); err != nil {
+				s.errorf(p.id, path, "put key %x: %s", e.key, err)
+			}
+		}
+		return nil
+	}
+
+	if len(e.value) < bucketHeaderSize {
+		s.errorf(p.id, path, "key %x: tree header too short: %d bytes", e.key, len(e.value))
+		return nil
+	}
+	hdr := (*bucket)(unsafe.Pointer(&e.value[0]))
+	if hdr.root != 0 {
+		return s.walkPage(w, hdr.root, path, e.key)
+	}
+	ip, err := parseSalvagePage(e.value[bucketHeaderSize:], 0, false)
+	if err != nil {
+		s.errorf(p.id, path, "key %x: inline tree: %s", e.key, err)
+		return nil
+	} else if !ip.isLeaf {
+		s.errorf(p.id, path, "key %x: inline tree is not a leaf page", e.key)
+		return nil
+	}
+	ip.id = p.id
+	s.walkDupLeaf(w, ip, path, e.key)
+	return nil
+}
+
+// walkOrphans writes the valid pages which were not visited from the tree
+// into SalvageOrphansBucket. The topmost page of each unreachable subtree is
+// walked first, so that subtrees are recovered whole.
+func (s *salvager) walkOrphans(w *bucketWriter, m *meta) error {
+	// Collect candidate pages and the pages they reference.
+	var candidates []pgid
+	referenced := make(map[pgid]bool)
+	for id := pgid(2); id < s.pageN; id++ {
+		if s.visited[id] || s.freed[id] || (m != nil && id == m.freelist) {
+			continue
+		}
+		p, err := s.page(id)
+		if err != nil {
+			buf, rerr := s.read(id)
+			if rerr == nil {
+				if flags := (*page)(unsafe.Pointer(&buf[0])).flags; flags&(leafPageFlag|branchPageFlag) != 0 {
+					s.errorf(id, nil, "%s", err)
+				}
+			}
+			continue
+		}
+		candidates = append(candidates, id)
+		for _, e := range p.branches {
+			referenced[e.pgid] = true
+		}
+		for _, e := range p.leafs {
+			if (e.flags&bucketLeafFlag) != 0 && len(e.value) >= bucketHeaderSize {
+				referenced[(*bucket)(unsafe.Pointer(&e.value[0])).root] = true
+			}
+		}
+		id += pgid(p.overflow)
+	}
+
+	walk := func(id pgid) error {
+		if s.visited[id] {
+			return nil
+		}
+		walked := s.walked
+		path := [][]byte{[]byte(SalvageOrphansBucket), []byte(fmt.Sprintf("page-%d", id))}
+		if err := w.createBucket(path[:1], 0, BucketOptions{}); err != nil {
+			return err
+		} else if err := w.createBucket(path, 0, BucketOptions{}); err != nil {
+			return err
+		}
+		if err := s.walkPage(w, id, path, nil); err != nil {
+			return err
+		}
+		s.report.OrphanPageCount += s.walked - walked
+		return nil
+	}
+	for _, id := range candidates {
+		if !referenced[id] {
+			if err := walk(id); err != nil {
+				return err
+			}
+		}
+	}
+	// Pages left over are only referenced from a cycle.
+	for _, id := range candidates {
+		if err := walk(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Ensure that salvaging a healthy database recovers all buckets and keys.
+func TestSalvage(t *testing.T) {
+	src := MustOpenDB()
+	defer src.MustClose()
+	if err := src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		child, err := b.CreateBucket([]byte("inline"))
+		if err != nil {
+			return err
+		}
+		if err := child.SetSequence(42); err != nil {
+			return err
+		}
+		return child.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := MustOpenDB()
+	defer dst.MustClose()
+	report, err := bolt.Salvage(dst.DB, src.Path(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", report.Errors)
+	} else if report.KeyCount != 1001 || report.BucketCount != 2 || report.OrphanPageCount != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if err := dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if n := b.Stats().KeyN; n != 1002 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		child := b.Bucket([]byte("inline"))
+		if v := child.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if seq := child.Sequence(); seq != 42 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that salvaging recovers the leaves of a destroyed branch page as orphans.
+func TestSalvage_Orphans(t *testing.T) {
+	src := MustOpenDB()
+	defer os.Remove(src.f)
+	if err := src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Find the branch page at the root of the bucket.
+	var branch int
+	if err := src.View(func(tx *bolt.Tx) error {
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				return err
+			} else if p == nil {
+				return nil
+			} else if p.Type == "branch" {
+				branch = id
+				return nil
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if branch == 0 {
+		t.Fatal("expected a branch page")
+	}
+	psize := src.Info().PageSize
+	if err := src.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Destroy the branch page.
+	f, err := os.OpenFile(src.f, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(make([]byte, psize), int64(branch*psize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := MustOpenDB()
+	defer dst.MustClose()
+	report, err := bolt.Salvage(dst.DB, src.f, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].PageID != branch {
+		t.Fatalf("unexpected errors: %v", report.Errors)
+	} else if report.KeyCount != 1000 || report.OrphanPageCount == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if err := dst.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		orphans := tx.Bucket([]byte(bolt.SalvageOrphansBucket))
+		if orphans == nil {
+			t.Fatal("expected orphans bucket")
+		}
+		var n int
+		if err := orphans.ForEach(func(k, _ []byte) error {
+			n += orphans.Bucket(k).Stats().KeyN
+			return nil
+		}); err != nil {
+			return err
+		}
+		if n != 1000 {
+			t.Fatalf("unexpected orphan key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that salvaging a database without a synced freelist does not bring
+// back the stale pages of past transactions as orphans.
+func TestSalvage_NoFreelistSync(t *testing.T) {
+	src := MustOpenWithOption(&bolt.Options{NoFreelistSync: true})
+	defer src.MustClose()
+	for _, value := range []string{"old", "new"} {
+		if err := src.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 100; i++ {
+				if err := b.Put(u64tob(uint64(i)), []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := MustOpenDB()
+	defer dst.MustClose()
+	report, err := bolt.Salvage(dst.DB, src.Path(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", report.Errors)
+	} else if report.KeyCount != 100 || report.OrphanPageCount != 0 || !report.OrphansSkipped {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if err := dst.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bolt.SalvageOrphansBucket)) != nil {
+			t.Fatal("unexpected orphans bucket")
+		}
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error {
+			if string(v) != "new" {
+				t.Fatalf("unexpected value for key %x: %q", k, v)
+			}
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
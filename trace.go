@@ -0,0 +1,61 @@
+package bbolt
+
+// TraceOp identifies an operation reported to a Tracer.
+type TraceOp string
+
+const (
+	// TraceTxCommit spans a whole Tx.Commit call.
+	TraceTxCommit = TraceOp("tx.commit")
+	// TraceTxRebalance spans the rebalancing of nodes with deletions.
+	TraceTxRebalance = TraceOp("tx.commit.rebalance")
+	// TraceTxSpill spans the spilling of dirty nodes onto pages.
+	TraceTxSpill = TraceOp("tx.commit.spill")
+	// TraceTxFreelist spans the writing of the new freelist.
+	TraceTxFreelist = TraceOp("tx.commit.freelist")
+	// TraceTxWrite spans the writing of dirty pages to the file.
+	TraceTxWrite = TraceOp("tx.commit.write")
+	// TraceTxSync spans the fsync of dirty pages.
+	TraceTxSync = TraceOp("tx.commit.sync")
+	// TraceTxWriteMeta spans the writing and syncing of the meta page.
+	TraceTxWriteMeta = TraceOp("tx.commit.meta")
+
+	// TraceMmap spans a (re)mapping of the data file.
+	TraceMmap = TraceOp("db.mmap")
+	// TraceGrow spans the growth of the data file.
+	TraceGrow = TraceOp("db.grow")
+	// TraceBatch spans the run of a batch of Batch calls.
+	TraceBatch = TraceOp("db.batch")
+)
+
+// TraceAttrs carries the attributes of a traced operation. Fields which do
+// not apply to an operation are left zero.
+type TraceAttrs struct {
+	TxID      int // transaction id
+	PageCount int // number of pages involved
+	Size      int // size in bytes, for mmap and grow
+	Calls     int // number of calls, for batch runs
+}
+
+// Tracer receives span-style callbacks around the phases of expensive
+// database operations so they can be bridged to a tracing system.
+//
+// StartSpan is called when an operation starts. The returned function, if
+// not nil, is called when the operation ends with its final attributes and
+// the error it failed with, if any. Spans may nest, e.g. a TraceMmap span
+// can occur inside a TraceTxSpill span.
+type Tracer interface {
+	StartSpan(op TraceOp, attrs TraceAttrs) func(attrs TraceAttrs, err error)
+}
+
+// startSpan starts a span on the configured tracer and returns the function
+// ending it. It is safe to call when no tracer is configured.
+func (db *DB) startSpan(op TraceOp, attrs TraceAttrs) func(attrs TraceAttrs, err error) {
+	if db.tracer != nil {
+		if end := db.tracer.StartSpan(op, attrs); end != nil {
+			return end
+		}
+	}
+	return endNoopSpan
+}
+
+func endNoopSpan(TraceAttrs, error) {}
@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -108,6 +109,13 @@ func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
 	return tx.root.CreateBucket(name)
 }
 
+// CreateBucketWithOptions creates a new bucket with the given options.
+// Returns an error if the bucket already exists, if the bucket name is blank, or if the comparator is not registered.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (tx *Tx) CreateBucketWithOptions(name []byte, opts *BucketOptions) (*Bucket, error) {
+	return tx.root.CreateBucketWithOptions(name, opts)
+}
+
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist.
 // Returns an error if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
@@ -115,6 +123,13 @@ func (tx *Tx) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
 	return tx.root.CreateBucketIfNotExists(name)
 }
 
+// CreateBucketIfNotExistsWithOptions creates a new bucket with the given options if it doesn't already exist.
+// Returns ErrIncompatibleValue if the bucket exists with a different comparator.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (tx *Tx) CreateBucketIfNotExistsWithOptions(name []byte, opts *BucketOptions) (*Bucket, error) {
+	return tx.root.CreateBucketIfNotExistsWithOptions(name, opts)
+}
+
 // DeleteBucket deletes a bucket.
 // Returns an error if the bucket cannot be found or if the key represents a non-bucket value.
 func (tx *Tx) DeleteBucket(name []byte) error {
@@ -123,10 +138,17 @@ func (tx *Tx) DeleteBucket(name []byte) error {
 
 // ForEach executes a function for each bucket in the root.
 // If the provided function returns an error then the iteration is stopped and
-// the error is returned to the caller.
+// the error is returned to the caller. ErrUnknownComparator is returned on a
+// bucket created with a comparator which is not registered, unless the
+// database was opened with Options.RawKeyOrder and the transaction is
+// read-only.
 func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
 	return tx.root.ForEach(func(k, v []byte) error {
-		return fn(k, tx.root.Bucket(k))
+		b, err := tx.root.lookupBucket(k)
+		if err != nil {
+			return err
+		}
+		return fn(k, b)
 	})
 }
 
@@ -138,7 +160,7 @@ func (tx *Tx) OnCommit(fn func()) {
 // Commit writes all changes to disk and updates the meta page.
 // Returns an error if a disk write error occurs, or if Commit is
 // called on a read-only transaction.
-func (tx *Tx) Commit() error {
+func (tx *Tx) Commit() (err error) {
 	_assert(!tx.managed, "managed tx commit not allowed")
 	if tx.db == nil {
 		return ErrTxClosed
@@ -148,16 +170,30 @@ func (tx *Tx) Commit() error {
 
 	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
 
+	db, lg := tx.db, tx.db.Logger()
+	attrs := TraceAttrs{TxID: tx.ID()}
+	endCommit := db.startSpan(TraceTxCommit, attrs)
+	defer func() { endCommit(TraceAttrs{TxID: attrs.TxID, PageCount: tx.stats.PageCount}, err) }()
+
+	// Roll back if a page can't be read.
+	defer tx.recoverPageError(&err)
+
 	// Rebalance nodes which have had deletions.
 	var startTime = time.Now()
+	endSpan := db.startSpan(TraceTxRebalance, attrs)
 	tx.root.rebalance()
+	endSpan(attrs, nil)
 	if tx.stats.Rebalance > 0 {
 		tx.stats.RebalanceTime += time.Since(startTime)
 	}
 
 	// spill data onto dirty pages.
 	startTime = time.Now()
-	if err := tx.root.spill(); err != nil {
+	endSpan = db.startSpan(TraceTxSpill, attrs)
+	err = tx.root.spill()
+	endSpan(TraceAttrs{TxID: attrs.TxID, PageCount: len(tx.pages)}, err)
+	if err != nil {
+		lg.Errorf("tx %d: spilling failed: %v", tx.meta.txid, err)
 		tx.rollback()
 		return err
 	}
@@ -168,12 +204,15 @@ func (tx *Tx) Commit() error {
 
 	// Free the old freelist because commit writes out a fresh freelist.
 	if tx.meta.freelist != pgidNoFreelist {
-		tx.db.freelist.free(tx.meta.txid, tx.db.page(tx.meta.freelist))
+		tx.db.freelist.free(tx.meta.txid, tx.page(tx.meta.freelist))
 	}
 
 	if !tx.db.NoFreelistSync {
-		err := tx.commitFreelist()
+		endSpan = db.startSpan(TraceTxFreelist, attrs)
+		err = tx.commitFreelist()
+		endSpan(TraceAttrs{TxID: attrs.TxID, PageCount: db.freelist.count()}, err)
 		if err != nil {
+			lg.Errorf("tx %d: writing freelist failed: %v", attrs.TxID, err)
 			return err
 		}
 	} else {
@@ -182,7 +221,12 @@ func (tx *Tx) Commit() error {
 
 	// Write dirty pages to disk.
 	startTime = time.Now()
-	if err := tx.write(); err != nil {
+	writeAttrs := TraceAttrs{TxID: attrs.TxID, PageCount: len(tx.pages)}
+	endSpan = db.startSpan(TraceTxWrite, writeAttrs)
+	err = tx.write()
+	endSpan(writeAttrs, err)
+	if err != nil {
+		lg.Errorf("tx %d: writing dirty pages failed: %v", tx.meta.txid, err)
 		tx.rollback()
 		return err
 	}
@@ -204,7 +248,12 @@ func (tx *Tx) Commit() error {
 	}
 
 	// Write meta to disk.
-	if err := tx.writeMeta(); err != nil {
+	metaAttrs := TraceAttrs{TxID: attrs.TxID, PageCount: 1}
+	endSpan = db.startSpan(TraceTxWriteMeta, metaAttrs)
+	err = tx.writeMeta()
+	endSpan(metaAttrs, err)
+	if err != nil {
+		lg.Errorf("tx %d: writing meta page failed: %v", tx.meta.txid, err)
 		tx.rollback()
 		return err
 	}
@@ -237,7 +286,7 @@ func (tx *Tx) commitFreelist() error {
 	tx.meta.freelist = p.id
 	// If the high water mark has moved up then attempt to grow the database.
 	if tx.meta.pgid > opgid {
-		if err := tx.db.grow(int(tx.meta.pgid+1) * tx.db.pageSize); err != nil {
+		if err := tx.db.grow(int64(tx.meta.pgid+1) * int64(tx.db.pageSize)); err != nil {
 			tx.rollback()
 			return err
 		}
@@ -281,7 +330,7 @@ func (tx *Tx) rollback() {
 			tx.db.freelist.noSyncReload(tx.db.freepages())
 		} else {
 			// Read free page list from freelist page.
-			tx.db.freelist.reload(tx.db.page(tx.db.meta().freelist))
+			tx.db.freelist.reload(tx.db.txPage(tx.db.meta().freelist, tx.meta.txid))
 		}
 	}
 	tx.close()
@@ -296,6 +345,7 @@ func (tx *Tx) close() {
 		var freelistFreeN = tx.db.freelist.free_count()
 		var freelistPendingN = tx.db.freelist.pending_count()
 		var freelistAlloc = tx.db.freelist.size()
+		var headroom = tx.db.headroom(tx.db.meta().pgid)
 
 		// Remove transaction ref & writer lock.
 		tx.db.rwtx = nil
@@ -307,6 +357,7 @@ func (tx *Tx) close() {
 		tx.db.stats.PendingPageN = freelistPendingN
 		tx.db.stats.FreeAlloc = (freelistFreeN + freelistPendingN) * tx.db.pageSize
 		tx.db.stats.FreelistInuse = freelistAlloc
+		tx.db.stats.Headroom = headroom
 		tx.db.stats.TxStats.add(&tx.stats)
 		tx.db.statlock.Unlock()
 	} else {
@@ -409,12 +460,31 @@ func (tx *Tx) CopyFile(path string, mode os.FileMode) error {
 // transaction, however, it is not safe to execute other writer transactions at
 // the same time.
 func (tx *Tx) Check() <-chan error {
+	return tx.CheckWithOptions(CheckOptions{})
+}
+
+// CheckWithOptions performs the same checks as Check and, if options.Deep is
+// set, a structural verification of every bucket tree. Structural problems
+// are reported as *CheckError values.
+func (tx *Tx) CheckWithOptions(options CheckOptions) <-chan error {
 	ch := make(chan error)
-	go tx.check(ch)
+	go tx.check(options, ch)
 	return ch
 }
 
-func (tx *Tx) check(ch chan error) {
+func (tx *Tx) check(options CheckOptions, ch chan error) {
+	// Report a page which can't be read, and stop there.
+	defer func() {
+		if p := recover(); p != nil {
+			perr, ok := p.(*PageError)
+			if !ok {
+				panic(p)
+			}
+			ch <- perr
+			close(ch)
+		}
+	}()
+
 	// Force loading free list if opened in ReadOnly mode.
 	tx.db.loadFreelist()
 
@@ -440,7 +510,7 @@ func (tx *Tx) check(ch chan error) {
 	}
 
 	// Recursively check buckets.
-	tx.checkBucket(&tx.root, reachable, freed, ch)
+	tx.checkBucket(&tx.root, nil, reachable, freed, options, ch)
 
 	// Ensure all pages below high water mark are either reachable or freed.
 	for i := pgid(0); i < tx.meta.pgid; i++ {
@@ -454,12 +524,29 @@ func (tx *Tx) check(ch chan error) {
 	close(ch)
 }
 
-func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bool, ch chan error) {
-	// Ignore inline buckets.
+func (tx *Tx) checkBucket(b *Bucket, path [][]byte, reachable map[pgid]*page, freed map[pgid]bool, options CheckOptions, ch chan error) {
+	// Ignore inline buckets, except for their blob values.
 	if b.root == 0 {
+		tx.checkBlobs(b, reachable, freed, ch)
 		return
 	}
 
+	// Verify the tree structure first. A damaged tree cannot be traversed
+	// safely so its pages and child buckets are skipped.
+	var badBuckets map[string]bool
+	if options.Deep {
+		var ok bool
+		var compare Comparator = bytes.Compare
+		if b.comparator != "" {
+			compare = b.compare
+		}
+		if ok, badBuckets = tx.checkTree(b.root, path, compare, ch); !ok {
+			return
+		}
+	}
+
+	tx.checkBlobs(b, reachable, freed, ch)
+
 	// Check every page used by this bucket.
 	b.tx.forEachPage(b.root, 0, func(p *page, _ int) {
 		if p.id > tx.meta.pgid {
@@ -483,13 +570,64 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bo
 		}
 	})
 
-	// Check each bucket within this bucket.
-	_ = b.ForEach(func(k, v []byte) error {
-		if child := b.Bucket(k); child != nil {
-			tx.checkBucket(child, reachable, freed, ch)
+	// Check each bucket within this bucket. They are opened even if their
+	// comparator is not registered, since their pages are reachable.
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		_, v, flags := c.keyValue()
+		if (flags&bucketLeafFlag) == 0 || badBuckets[string(k)] {
+			continue
 		}
-		return nil
-	})
+		child := b.openBucket(v, flags)
+		tx.checkBucket(child, append(path[:len(path):len(path)], cloneBytes(k)), reachable, freed, options, ch)
+	}
+}
+
+// checkBlobs checks the page runs of the values of b set by PutReader.
+func (tx *Tx) checkBlobs(b *Bucket, reachable map[pgid]*page, freed map[pgid]bool, ch chan error) {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if _, v, flags := c.keyValue(); (flags & blobLeafFlag) != 0 {
+			tx.checkBlob(k, v, reachable, freed, ch)
+		}
+	}
+}
+
+// checkBlob checks the page runs of the blob value of key k referenced by v.
+func (tx *Tx) checkBlob(k, v []byte, reachable map[pgid]*page, freed map[pgid]bool, ch chan error) {
+	if len(v) != blobRefSize {
+		ch <- fmt.Errorf("key %x: invalid blob reference size: %d", k, len(v))
+		return
+	}
+
+	ref := readBlobRef(v)
+	var size uint64
+	for id := ref.first; id != 0; {
+		if id >= tx.meta.pgid {
+			ch <- fmt.Errorf("page %d: out of bounds: %d", int(id), int(tx.meta.pgid))
+			return
+		}
+		p, h, err := tx.readBlobHeader(id)
+		if err != nil {
+			ch <- fmt.Errorf("key %x: %s", k, err)
+			return
+		}
+		for i := pgid(0); i <= pgid(p.overflow); i++ {
+			if _, ok := reachable[id+i]; ok {
+				ch <- fmt.Errorf("page %d: multiple references", int(id+i))
+				return
+			}
+			reachable[id+i] = &p
+		}
+		if freed[id] {
+			ch <- fmt.Errorf("page %d: reachable freed", int(id))
+		}
+		size += h.size
+		id = h.next
+	}
+	if size != ref.size {
+		ch <- fmt.Errorf("key %x: blob pages hold %d bytes, expected %d", k, size, ref.size)
+	}
 }
 
 // allocate returns a contiguous block of memory starting at a given page.
@@ -553,9 +691,20 @@ func (tx *Tx) write() error {
 		}
 	}
 
+	// Drop the rewritten pages from the page cache.
+	if tx.db.pageCache != nil {
+		for _, p := range pages {
+			tx.db.pageCache.invalidate(p.id, int(p.overflow)+1)
+		}
+	}
+
 	// Ignore file sync if flag is set on DB.
 	if !tx.db.NoSync || IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+		attrs := TraceAttrs{TxID: tx.ID(), PageCount: len(pages)}
+		endSpan := tx.db.startSpan(TraceTxSync, attrs)
+		err := fdatasync(tx.db)
+		endSpan(attrs, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -597,6 +746,11 @@ func (tx *Tx) writeMeta() error {
 		}
 	}
 
+	// Without the mmap, the meta page in memory must be updated.
+	if tx.db.pageCache != nil {
+		tx.db.updateMetaPage(buf)
+	}
+
 	// Update statistics.
 	tx.stats.Write++
 
@@ -614,7 +768,23 @@ func (tx *Tx) page(id pgid) *page {
 	}
 
 	// Otherwise return directly from the mmap.
-	return tx.db.page(id)
+	return tx.db.txPage(id, tx.meta.txid)
+}
+
+// recoverPageError rolls back the transaction and stores in err a PageError
+// raised while reading a page. Other panics are raised again. It must be
+// deferred.
+func (tx *Tx) recoverPageError(err *error) {
+	if p := recover(); p != nil {
+		perr, ok := p.(*PageError)
+		if !ok {
+			panic(p)
+		}
+		if tx.db != nil {
+			tx.rollback()
+		}
+		*err = perr
+	}
 }
 
 // forEachPage iterates over every page within a given page and executes a function.
@@ -642,8 +812,11 @@ func (tx *Tx) Page(id int) (*PageInfo, error) {
 		return nil, nil
 	}
 
+	// Force loading free list if opened in ReadOnly mode.
+	tx.db.loadFreelist()
+
 	// Build the page info.
-	p := tx.db.page(pgid(id))
+	p := tx.db.txPage(pgid(id), tx.meta.txid)
 	info := &PageInfo{
 		ID:            id,
 		Count:         int(p.count),
@@ -653,6 +826,7 @@ func (tx *Tx) Page(id int) (*PageInfo, error) {
 	// Determine the type (or if it's free).
 	if tx.db.freelist.freed(pgid(id)) {
 		info.Type = "free"
+		info.Pending = tx.db.freelist.isPending(pgid(id))
 	} else {
 		info.Type = p.typ()
 	}
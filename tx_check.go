@@ -0,0 +1,305 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// CheckOptions configures the consistency check performed by
+// Tx.CheckWithOptions.
+type CheckOptions struct {
+	// Deep enables structural verification of every bucket tree in addition
+	// to the reachability and freelist checks. It verifies that keys are
+	// strictly sorted within each page, that branch keys equal the first key
+	// of their child, that page types are consistent with their parent, that
+	// pages and their overflow stay below the high water mark, and that
+	// nested bucket headers and inline bucket values are valid.
+	Deep bool
+}
+
+// CheckError describes a structural problem found by a deep check.
+type CheckError struct {
+	// PageID is the page on which the problem was found. For problems in
+	// a nested bucket header or an inline bucket, this is the leaf page
+	// holding the bucket value.
+	PageID int
+
+	// Bucket is the path of the bucket the page belongs to. It is empty
+	// for pages of the root bucket.
+	Bucket [][]byte
+
+	// Reason describes the problem.
+	Reason string
+}
+
+// Error returns the formatted problem.
+func (e *CheckError) Error() string {
+	if len(e.Bucket) == 0 {
+		return fmt.Sprintf("page %d: %s", e.PageID, e.Reason)
+	}
+	names := make([]string, len(e.Bucket))
+	for i, name := range e.Bucket {
+		names[i] = fmt.Sprintf("%q", name)
+	}
+	return fmt.Sprintf("page %d: bucket %s: %s", e.PageID, strings.Join(names, "/"), e.Reason)
+}
+
+// treeChecker performs the deep structural verification of a single bucket.
+type treeChecker struct {
+	tx   *Tx
+	path [][]byte
+	ch   chan error
+
+	// compare orders the keys of the bucket. It is nil if the comparator of
+	// the bucket is not registered, and the order of the keys is not verified.
+	compare Comparator
+
+	// leafDepth is the depth of the first leaf found. All leaves must be
+	// at the same depth.
+	leafDepth int
+
+	// visited holds the pages of the tree checked so far, so that a page
+	// referenced twice, e.g. by a branch pointing back at an ancestor, is
+	// not descended into again.
+	visited map[pgid]bool
+
+	// badBuckets holds the names of child buckets whose header is invalid.
+	badBuckets map[string]bool
+}
+
+func (c *treeChecker) errorf(id pgid, path [][]byte, format string, v ...interface{}) {
+	c.ch <- &CheckError{PageID: int(id), Bucket: path, Reason: fmt.Sprintf(format, v...)}
+}
+
+// checkTree verifies the structure of the bucket tree starting at root, whose
+// keys are ordered by compare. It returns false if the tree is too damaged to
+// be traversed safely, and the set of child buckets which cannot be opened.
+func (tx *Tx) checkTree(root pgid, path [][]byte, compare Comparator, ch chan error) (bool, map[string]bool) {
+	c := &treeChecker{tx: tx, path: path, ch: ch, compare: compare, leafDepth: -1, visited: make(map[pgid]bool), badBuckets: make(map[string]bool)}
+	ok := c.checkPage(root, 0, 0, nil)
+	return ok, c.badBuckets
+}
+
+// checkPage verifies a page and, recursively, its children. parent is the
+// id of the referencing branch page (zero for the root) and parentKey is the
+// key under which the page is referenced.
+func (c *treeChecker) checkPage(id pgid, parent pgid, depth int, parentKey []byte) bool {
+	tx := c.tx
+	if id <= 1 || id >= tx.meta.pgid {
+		if parent == 0 {
+			c.errorf(id, c.path, "root page out of bounds: high water mark is %d", int(tx.meta.pgid))
+		} else {
+			c.errorf(parent, c.path, "child page %d out of bounds: high water mark is %d", int(id), int(tx.meta.pgid))
+		}
+		return false
+	}
+	if c.visited[id] {
+		c.errorf(parent, c.path, "child page %d already visited", int(id))
+		return false
+	}
+	c.visited[id] = true
+
+	p := tx.page(id)
+	if p.id != id {
+		c.errorf(id, c.path, "page header has id %d", int(p.id))
+		return false
+	}
+	if last := id + pgid(p.overflow); last >= tx.meta.pgid || last < id {
+		c.errorf(id, c.path, "overflow %d exceeds high water mark %d", p.overflow, int(tx.meta.pgid))
+		return false
+	}
+
+	isLeaf := (p.flags & leafPageFlag) != 0
+	isBranch := (p.flags & branchPageFlag) != 0
+	if isLeaf == isBranch {
+		if parent == 0 {
+			c.errorf(id, c.path, "invalid type for a bucket root: %s", p.typ())
+		} else {
+			c.errorf(id, c.path, "invalid type for a child of branch page %d: %s", int(parent), p.typ())
+		}
+		return false
+	}
+
+	size := (int(p.overflow) + 1) * tx.db.pageSize
+	if !c.checkElements(p, size, id, c.path, c.compare) {
+		return false
+	}
+
+	if p.count == 0 {
+		if isBranch {
+			c.errorf(id, c.path, "empty branch page")
+			return false
+		} else if parent != 0 {
+			c.errorf(id, c.path, "empty leaf page below branch page %d", int(parent))
+		}
+	}
+
+	// Ensure the branch key referencing this page matches its first key.
+	if parentKey != nil && p.count > 0 {
+		first := pageElementKey(p, 0)
+		if !bytes.Equal(parentKey, first) {
+			c.errorf(parent, c.path, "branch key %x does not match first key %x of child page %d", parentKey, first, int(id))
+		}
+	}
+
+	if isLeaf {
+		// All leaves must be at the same depth.
+		if c.leafDepth == -1 {
+			c.leafDepth = depth
+		} else if c.leafDepth != depth {
+			c.errorf(id, c.path, "leaf page at depth %d, expected depth %d", depth, c.leafDepth)
+		}
+
+		for i := uint16(0); i < p.count; i++ {
+			e := p.leafPageElement(i)
+			if !validLeafFlags(e.flags) {
+				c.errorf(id, c.path, "invalid flags %x on element %d", e.flags, i)
+			} else if (e.flags&blobLeafFlag) != 0 && e.vsize != uint32(blobRefSize) {
+				c.errorf(id, c.path, "invalid blob reference size %d on element %d", e.vsize, i)
+			} else if e.flags == dupLeafFlag && !validDups(e.value()) {
+				c.errorf(id, c.path, "invalid duplicate values on element %d", i)
+			}
+			if (e.flags & bucketLeafFlag) != 0 {
+				if k := p.leafKey(i); !c.checkBucketHeader(id, k, e.value(), e.flags) {
+					c.badBuckets[string(k)] = true
+				}
+			}
+		}
+		return true
+	}
+
+	ok := true
+	for i := uint16(0); i < p.count; i++ {
+		e := p.branchPageElement(i)
+		if !c.checkPage(e.pgid, id, depth+1, p.branchKey(i)) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// checkElements verifies that the element headers, keys and values of a leaf
+// or branch page fit within size bytes and that the keys are strictly sorted
+// by compare. The order is not verified if compare is nil.
+func (c *treeChecker) checkElements(p *page, size int, id pgid, path [][]byte, compare Comparator) bool {
+	isLeaf := (p.flags & leafPageFlag) != 0
+	elsz := int(branchPageElementSize)
+	if isLeaf {
+		elsz = int(leafPageElementSize)
+	}
+	if int(pageHeaderSize)+int(p.count)*elsz > size {
+		c.errorf(id, path, "%d elements do not fit in %d bytes", p.count, size)
+		return false
+	}
+	if (p.flags & prefixPageFlag) != 0 {
+		off := int(pageHeaderSize) + int(p.count)*elsz
+		if off+prefixHeaderSize > size || off+prefixHeaderSize+len(p.prefix()) > size {
+			c.errorf(id, path, "key prefix does not fit in %d bytes", size)
+			return false
+		}
+	}
+
+	var prev []byte
+	for i := uint16(0); i < p.count; i++ {
+		var off, end int
+		if isLeaf {
+			e := p.leafPageElement(i)
+			off = int(uintptr(unsafe.Pointer(e)) - uintptr(unsafe.Pointer(p)))
+			end = off + int(e.pos) + int(e.ksize) + int(e.vsize)
+		} else {
+			e := p.branchPageElement(i)
+			off = int(uintptr(unsafe.Pointer(e)) - uintptr(unsafe.Pointer(p)))
+			end = off + int(e.pos) + int(e.ksize)
+		}
+		if end > size {
+			c.errorf(id, path, "element %d ends at offset %d beyond page size %d", i, end, size)
+			return false
+		}
+
+		k := pageElementKey(p, i)
+		if len(k) == 0 {
+			c.errorf(id, path, "zero-length key on element %d", i)
+		} else if prev != nil && compare != nil && compare(prev, k) >= 0 {
+			c.errorf(id, path, "key %x on element %d is not greater than previous key %x", k, i, prev)
+		}
+		prev = k
+	}
+	return true
+}
+
+// checkBucketHeader verifies a nested bucket value stored on leaf page id
+// under the given key, with the given element flags. It returns false if the
+// bucket cannot be opened.
+func (c *treeChecker) checkBucketHeader(id pgid, key, value []byte, flags uint32) bool {
+	path := append(append([][]byte{}, c.path...), cloneBytes(key))
+	if len(value) < bucketHeaderSize {
+		c.errorf(id, path, "bucket header too short: %d bytes", len(value))
+		return false
+	}
+
+	// Verify the options stored after the header. Buckets whose comparator
+	// is not registered can still be traversed, but not their key order.
+	var extSize int
+	var compare Comparator = bytes.Compare
+	if (flags & bucketExtLeafFlag) != 0 {
+		opts, n, ok := readBucketExt(value)
+		if !ok {
+			c.errorf(id, path, "invalid bucket options")
+			return false
+		}
+		extSize = n
+		if opts.Comparator != "" {
+			if compare = lookupComparator(opts.Comparator); compare == nil {
+				c.errorf(id, path, "unknown comparator %q: key order not verified", opts.Comparator)
+			}
+		}
+	}
+
+	// Copy the value since it may not be aligned.
+	value = cloneBytes(value)
+	hdr := (*bucket)(unsafe.Pointer(&value[0]))
+
+	if hdr.root != 0 {
+		if len(value) != bucketHeaderSize+extSize {
+			c.errorf(id, path, "bucket with root %d has %d bytes of inline data", int(hdr.root), len(value)-bucketHeaderSize-extSize)
+		}
+		if hdr.root == 1 || hdr.root >= c.tx.meta.pgid {
+			c.errorf(id, path, "bucket root %d out of bounds: high water mark is %d", int(hdr.root), int(c.tx.meta.pgid))
+			return false
+		}
+		return true
+	}
+
+	// Verify the inline page.
+	size := len(value) - bucketHeaderSize - extSize
+	if size < int(pageHeaderSize) {
+		c.errorf(id, path, "inline bucket too short: %d bytes", size)
+		return false
+	}
+	p := (*page)(unsafe.Pointer(&value[bucketHeaderSize+extSize]))
+	if (p.flags&leafPageFlag) == 0 || (p.flags&branchPageFlag) != 0 {
+		c.errorf(id, path, "invalid inline page type: %s", p.typ())
+		return false
+	}
+	if !c.checkElements(p, size, id, path, compare) {
+		return false
+	}
+	for i := uint16(0); i < p.count; i++ {
+		if e := p.leafPageElement(i); e.flags&^blobLeafFlag != 0 {
+			c.errorf(id, path, "invalid flags %x on inline element %d", e.flags, i)
+		} else if e.flags != 0 && e.vsize != uint32(blobRefSize) {
+			c.errorf(id, path, "invalid blob reference size %d on inline element %d", e.vsize, i)
+		}
+	}
+	return true
+}
+
+// pageElementKey returns the key of the element at index on a leaf or branch page.
+func pageElementKey(p *page, index uint16) []byte {
+	if (p.flags & leafPageFlag) != 0 {
+		return p.leafKey(index)
+	}
+	return p.branchKey(index)
+}
@@ -2,10 +2,14 @@ package bbolt_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	bolt "go.etcd.io/bbolt"
@@ -60,6 +64,201 @@ func TestTx_Check_ReadOnly(t *testing.T) {
 	tx.Rollback()
 }
 
+// Ensure that a deep check passes on a healthy database with nested and inline buckets.
+func TestTx_CheckWithOptions_Deep(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		child, err := b.CreateBucket([]byte("inline"))
+		if err != nil {
+			return err
+		}
+		return child.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			t.Error(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a deep check reports unsorted keys and mismatched branch keys.
+func TestTx_CheckWithOptions_Deep_Corrupted(t *testing.T) {
+	db := MustOpenDB()
+	defer os.Remove(db.f)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Find the second leaf page of the bucket.
+	var leaf int
+	if err := db.View(func(tx *bolt.Tx) error {
+		var leaves int
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				return err
+			} else if p == nil {
+				break
+			}
+			if p.Type == "leaf" && p.Count > 1 {
+				if leaves++; leaves == 2 {
+					leaf = id
+					break
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if leaf == 0 {
+		t.Fatal("expected a leaf page")
+	}
+	psize := db.Info().PageSize
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite the first key of the leaf so it sorts after the second key.
+	buf, err := os.ReadFile(db.f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem := leaf*psize + pageHeaderSize
+	pos := int(binary.LittleEndian.Uint32(buf[elem+4:]))
+	copy(buf[elem+pos:], bytes.Repeat([]byte{0xff}, 8))
+	if err := os.WriteFile(db.f, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	db.MustReopen()
+	defer db.DB.Close()
+
+	var errs []*bolt.CheckError
+	if err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			cerr, ok := err.(*bolt.CheckError)
+			if !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			errs = append(errs, cerr)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var unsorted, mismatch bool
+	for _, err := range errs {
+		if len(err.Bucket) != 1 || string(err.Bucket[0]) != "widgets" {
+			t.Fatalf("unexpected bucket path: %v", err)
+		}
+		switch {
+		case err.PageID == leaf && strings.Contains(err.Reason, "is not greater than previous key"):
+			unsorted = true
+		case strings.Contains(err.Reason, fmt.Sprintf("of child page %d", leaf)):
+			mismatch = true
+		}
+	}
+	if !unsorted || !mismatch {
+		t.Fatalf("expected unsorted and mismatched keys, got: %v", errs)
+	}
+}
+
+// Ensure that a deep check reports a branch page referencing itself instead
+// of descending into it forever.
+func TestTx_CheckWithOptions_Deep_Cycle(t *testing.T) {
+	db := MustOpenDB()
+	defer os.Remove(db.f)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var root int
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		if p, err := tx.Page(root); err != nil {
+			return err
+		} else if p.Type != "branch" {
+			t.Fatalf("unexpected root page type: %s", p.Type)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	psize := db.Info().PageSize
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the second element of the root branch page back at the page.
+	buf, err := os.ReadFile(db.f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem := root*psize + pageHeaderSize + 16
+	binary.LittleEndian.PutUint64(buf[elem+8:], uint64(root))
+	if err := os.WriteFile(db.f, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	db.MustReopen()
+	defer db.DB.Close()
+
+	var visited bool
+	if err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.CheckWithOptions(bolt.CheckOptions{Deep: true}) {
+			if cerr, ok := err.(*bolt.CheckError); ok && cerr.PageID == root && strings.Contains(cerr.Reason, "already visited") {
+				visited = true
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !visited {
+		t.Fatal("expected the cycle to be reported")
+	}
+}
+
 // Ensure that committing a closed transaction returns an error.
 func TestTx_Commit_ErrTxClosed(t *testing.T) {
 	db := MustOpenDB()
@@ -528,6 +727,67 @@ func TestTx_OnCommit_Rollback(t *testing.T) {
 	}
 }
 
+// recordingTracer records the start and end of every span.
+type recordingTracer struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingTracer) StartSpan(op bolt.TraceOp, attrs bolt.TraceAttrs) func(bolt.TraceAttrs, error) {
+	r.record("start " + string(op))
+	return func(attrs bolt.TraceAttrs, err error) {
+		r.record("end " + string(op))
+	}
+}
+
+func (r *recordingTracer) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// Ensure that the phases of a commit are reported to the tracer.
+func TestTx_Commit_Tracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	db := MustOpenWithOption(&bolt.Options{Tracer: tracer})
+	defer db.MustClose()
+
+	tracer.events = nil
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"start tx.commit",
+		"start tx.commit.rebalance", "end tx.commit.rebalance",
+		"start tx.commit.spill", "end tx.commit.spill",
+		"start tx.commit.freelist",
+		"start db.grow", "end db.grow",
+		"end tx.commit.freelist",
+		"start tx.commit.write",
+		"start tx.commit.sync", "end tx.commit.sync",
+		"end tx.commit.write",
+		"start tx.commit.meta", "end tx.commit.meta",
+		"end tx.commit",
+	}
+	if !reflect.DeepEqual(exp, tracer.events) {
+		t.Fatalf("unexpected events:\nexp=%v\ngot=%v", exp, tracer.events)
+	}
+
+	tracer.events = nil
+	if err := db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if first, last := tracer.events[0], tracer.events[len(tracer.events)-1]; first != "start db.batch" || last != "end db.batch" {
+		t.Fatalf("unexpected batch events: %v", tracer.events)
+	}
+}
+
 // Ensure that the database can be copied to a file path.
 func TestTx_CopyFile(t *testing.T) {
 	db := MustOpenDB()
@@ -921,3 +1181,90 @@ func ExampleTx_CopyFile() {
 	// Output:
 	// The value for 'foo' in the clone is: bar
 }
+
+// Ensure that pages freed while a reader is open are reported as pending.
+func TestTx_Page_Pending(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the pages of the bucket readable while deleting it.
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rtx.Rollback() }()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var pending int
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				return err
+			} else if p == nil {
+				break
+			} else if p.Pending {
+				if p.Type != "free" {
+					t.Fatalf("unexpected type of pending page %d: %s", id, p.Type)
+				}
+				pending++
+			}
+		}
+		if pending == 0 {
+			t.Fatal("expected pending pages")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that page info can be retrieved from a read-only database.
+func TestTx_Page_ReadOnly(t *testing.T) {
+	db := MustOpenDB()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(db.f)
+
+	readOnlyDB, err := bolt.Open(db.f, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnlyDB.Close()
+	if err := readOnlyDB.View(func(tx *bolt.Tx) error {
+		p, err := tx.Page(0)
+		if err != nil {
+			return err
+		} else if p == nil || p.Type != "meta" {
+			t.Fatalf("unexpected page: %+v", p)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}